@@ -0,0 +1,382 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package analytics summarizes the CSV datafiles written by lgresu_mon
+// per day or per month: charged/discharged energy (kWh), round-trip
+// efficiency, minimal/maximal SOC and maximal temperature.
+//
+// The energy is integrated from the recorded voltage and current (the power
+// of a record is used until the next record). Gaps longer than
+// MAX_RECORD_INTERVAL (lgresu_mon not running, BMS offline) are not
+// integrated.
+//
+// The round-trip efficiency is computed over a SOC-matched window: the
+// longest period within the day/month that starts and ends with the same
+// SOC. The stored energy is the same at the start and at the end of the
+// window, therefore:
+//
+//	efficiency = discharged energy / charged energy * 100
+//
+// Example:
+//
+//	summaries, err := analytics.Summarize("/opt/lgresu", ".csv", analytics.DAY)
+//	analytics.WriteCsv(os.Stdout, summaries)
+//
+//	Period,ChargedKwh,DischargedKwh,Efficiency,MinSoc,MaxSoc,MaxTemp
+//	2018-05-31,6.52,5.87,94.1,23,100,27.4
+package analytics
+
+import (
+	"encoding/csv"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// summary periods (time layout of the period name)
+	DAY   string = "2006-01-02"
+	MONTH string = "2006-01"
+	// longer intervals between two records are not integrated
+	MAX_RECORD_INTERVAL time.Duration = 5 * time.Minute
+	// time format of the CSV datafiles
+	TIME_FORMAT string = "2006/01/02 15:04:05"
+)
+
+// Record contains the metrics of a single CSV data record.
+type Record struct {
+	Time    time.Time
+	Soc     uint16
+	Voltage float32
+	Current float32
+	Temp    float32
+	// false for datafiles without temperature column
+	HasTemp bool
+}
+
+// Summary contains the energy balance of a day or a month.
+type Summary struct {
+	// day (2018-05-31) or month (2018-05)
+	Period        string  `json:"period"`
+	ChargedKwh    float64 `json:"chargedKwh"`
+	DischargedKwh float64 `json:"dischargedKwh"`
+	// round-trip efficiency in % (0 without SOC-matched window)
+	Efficiency float64 `json:"efficiency"`
+	MinSoc     uint16  `json:"minSoc"`
+	MaxSoc     uint16  `json:"maxSoc"`
+	MaxTemp    float32 `json:"maxTemp"`
+	Records    int     `json:"records"`
+}
+
+// ReadDatafile reads the records of a CSV datafile. Columns are identified by the header. Offline
+// records (empty metrics) and records that cannot be parsed (partially written) are skipped.
+func ReadDatafile(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	// the number of columns has changed between versions of lgresu_mon
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	column := map[string]int{}
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, name := range []string{"Time", "Soc", "Voltage", "Current"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("ReadDatafile: column %s missing in header %q", name, strings.Join(header, ","))
+		}
+	}
+	tempColumn, hasTemp := column["Temp"]
+
+	records := []Record{}
+	for line := 2; ; line++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warnf("ReadDatafile: line %d skipped (%v)\n", line, err)
+			continue
+		}
+
+		value := func(name string) string {
+			if i := column[name]; i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+
+		// BMS offline
+		if len(value("Soc")) == 0 {
+			continue
+		}
+
+		rec, err := parseRecord(value("Time"), value("Soc"), value("Voltage"), value("Current"))
+		if err == nil && hasTemp && tempColumn < len(fields) {
+			var temp float64
+			temp, err = strconv.ParseFloat(fields[tempColumn], 32)
+			rec.Temp, rec.HasTemp = float32(temp), true
+		}
+		if err != nil {
+			log.Warnf("ReadDatafile: line %d skipped (%v)\n", line, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// parseRecord parses the time and the metrics of a CSV data record.
+func parseRecord(t, soc, voltage, current string) (rec Record, err error) {
+	if rec.Time, err = time.ParseInLocation(TIME_FORMAT, t, time.Local); err != nil {
+		return rec, err
+	}
+	s, err := strconv.ParseUint(soc, 10, 16)
+	if err != nil {
+		return rec, err
+	}
+	rec.Soc = uint16(s)
+	v, err := strconv.ParseFloat(voltage, 32)
+	if err != nil {
+		return rec, err
+	}
+	rec.Voltage = float32(v)
+	c, err := strconv.ParseFloat(current, 32)
+	if err != nil {
+		return rec, err
+	}
+	rec.Current = float32(c)
+	return rec, nil
+}
+
+// datafileOrder returns the date and the order of a datafile within the day: a datafile moved aside by the
+// datarecorder after a header change (YYYYMMDD<extension>.<n>) precedes the datafiles moved aside later
+// and the current datafile (YYYYMMDD<extension>). ok is false for other files (for example: energy.json).
+func datafileOrder(base string, extension string) (day string, n int, ok bool) {
+	i := strings.Index(base, extension)
+	if i < 0 {
+		return "", 0, false
+	}
+	day, suffix := base[:i], base[i+len(extension):]
+	if _, err := time.Parse("20060102", day); err != nil {
+		return "", 0, false
+	}
+
+	if len(suffix) == 0 {
+		return day, math.MaxInt32, true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(suffix, "."))
+	if err != nil || !strings.HasPrefix(suffix, ".") || n < 1 {
+		return "", 0, false
+	}
+	return day, n, true
+}
+
+// ReadDatafiles reads the records of all datafiles (<rootPath>/YYYY/MM/YYYYMMDD<extension> and the datafiles
+// moved aside after a header change: <rootPath>/YYYY/MM/YYYYMMDD<extension>.<n>) in chronological order.
+func ReadDatafiles(rootPath string, extension string) ([]Record, error) {
+	type datafile struct {
+		path string
+		day  string
+		n    int
+	}
+	datafiles := []datafile{}
+
+	// no datafiles written yet
+	if _, err := os.Stat(rootPath); os.IsNotExist(err) {
+		return []Record{}, nil
+	}
+
+	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		day, n, ok := datafileOrder(filepath.Base(path), extension)
+		if !ok {
+			return nil
+		}
+		datafiles = append(datafiles, datafile{path, day, n})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(datafiles, func(i, j int) bool {
+		if datafiles[i].day != datafiles[j].day {
+			return datafiles[i].day < datafiles[j].day
+		}
+		return datafiles[i].n < datafiles[j].n
+	})
+
+	records := []Record{}
+	for _, df := range datafiles {
+		f, err := os.Open(df.path)
+		if err != nil {
+			return nil, err
+		}
+		recs, err := ReadDatafile(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", df.path, err)
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+// Summarize reads all datafiles in rootPath and summarizes them per period (DAY or MONTH).
+func Summarize(rootPath string, extension string, period string) ([]Summary, error) {
+	records, err := ReadDatafiles(rootPath, extension)
+	if err != nil {
+		return nil, err
+	}
+	return SummarizeRecords(records, period), nil
+}
+
+// SummarizeRecords summarizes records (in chronological order) per period (DAY or MONTH). The energy
+// of the interval between two records is attributed to the period of the second record.
+func SummarizeRecords(records []Record, period string) []Summary {
+	summaries := []Summary{}
+
+	// charged/discharged energy (Wh) since the start of the period, indexed like records
+	charged := make([]float64, len(records))
+	discharged := make([]float64, len(records))
+
+	start := 0
+	// the current summary has a record with temperature (MaxTemp is valid)
+	hasTemp := false
+	for i, rec := range records {
+		name := rec.Time.Format(period)
+
+		if i == 0 || summaries[len(summaries)-1].Period != name {
+			if i > 0 {
+				summaries[len(summaries)-1].Efficiency = efficiency(records[start:i], charged[start:i], discharged[start:i])
+			}
+			summaries = append(summaries, Summary{Period: name, MinSoc: rec.Soc, MaxSoc: rec.Soc})
+			start = i
+			hasTemp = false
+		}
+		s := &summaries[len(summaries)-1]
+
+		if i > start {
+			charged[i], discharged[i] = charged[i-1], discharged[i-1]
+		}
+		if i > 0 {
+			prev := records[i-1]
+			if dt := rec.Time.Sub(prev.Time); dt > 0 && dt <= MAX_RECORD_INTERVAL {
+				wh := float64(prev.Voltage) * float64(prev.Current) * dt.Hours()
+				if wh > 0 {
+					charged[i] += wh
+				} else {
+					discharged[i] -= wh
+				}
+			}
+		}
+
+		s.Records++
+		s.ChargedKwh = charged[i] / 1000
+		s.DischargedKwh = discharged[i] / 1000
+		if rec.Soc < s.MinSoc {
+			s.MinSoc = rec.Soc
+		}
+		if rec.Soc > s.MaxSoc {
+			s.MaxSoc = rec.Soc
+		}
+		if rec.HasTemp && (!hasTemp || rec.Temp > s.MaxTemp) {
+			s.MaxTemp = rec.Temp
+			hasTemp = true
+		}
+	}
+	if len(records) > 0 {
+		summaries[len(summaries)-1].Efficiency = efficiency(records[start:], charged[start:], discharged[start:])
+	}
+
+	return summaries
+}
+
+// efficiency returns the round-trip efficiency (%) of the longest SOC-matched window (0 if there is
+// no window with charged and discharged energy). charged and discharged are cumulative.
+func efficiency(records []Record, charged []float64, discharged []float64) float64 {
+	// index of the first record with a given SOC
+	first := map[uint16]int{}
+	from, to := -1, -1
+
+	for i, rec := range records {
+		j, ok := first[rec.Soc]
+		if !ok {
+			first[rec.Soc] = i
+			continue
+		}
+		if charged[i]-charged[j] <= 0 || discharged[i]-discharged[j] <= 0 {
+			continue
+		}
+		if from < 0 || rec.Time.Sub(records[j].Time) > records[to].Time.Sub(records[from].Time) {
+			from, to = j, i
+		}
+	}
+
+	if from < 0 {
+		return 0
+	}
+	return (discharged[to] - discharged[from]) / (charged[to] - charged[from]) * 100
+}
+
+// CsvHeader returns the header for the CSV summaries written with WriteCsv().
+func CsvHeader() string {
+	return "Period,ChargedKwh,DischargedKwh,Efficiency,MinSoc,MaxSoc,MaxTemp\n"
+}
+
+// CsvRecord returns the summary as CSV data record.
+func (s Summary) CsvRecord() string {
+	return s.Period + "," +
+		strconv.FormatFloat(s.ChargedKwh, 'f', 2, 64) + "," +
+		strconv.FormatFloat(s.DischargedKwh, 'f', 2, 64) + "," +
+		strconv.FormatFloat(s.Efficiency, 'f', 1, 64) + "," +
+		strconv.Itoa(int(s.MinSoc)) + "," +
+		strconv.Itoa(int(s.MaxSoc)) + "," +
+		strconv.FormatFloat(float64(s.MaxTemp), 'f', 1, 32) + "\n"
+}
+
+// WriteCsv writes the summaries (including the header) in CSV format to w.
+func WriteCsv(w io.Writer, summaries []Summary) error {
+	if _, err := io.WriteString(w, CsvHeader()); err != nil {
+		return err
+	}
+	for _, s := range summaries {
+		if _, err := io.WriteString(w, s.CsvRecord()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analytics
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+// datafile returns a CSV datafile (lgresu_mon format) with a record every minute: 1 hour charge with
+// 20A (50% -> 60%) followed by 1 hour discharge with 18A (60% -> 50%) at 50V.
+func datafile(start time.Time) string {
+	var b bytes.Buffer
+	b.WriteString(rs.CsvRecordHeader())
+
+	for i := 0; i <= 120; i++ {
+		lgResu := rs.LgResuStatus{Voltage: 50, Current: 20, Soc: uint16(50 + 10*i/60), Temp: 20 + float32(i)/10}
+		if i >= 60 {
+			lgResu.Current, lgResu.Soc = -18, uint16(60-10*(i-60)/60)
+		}
+		b.WriteString(lgResu.CsvRecord(start.Add(time.Duration(i) * time.Minute)))
+	}
+	return b.String()
+}
+
+func TestSummarizeRecords(t *testing.T) {
+	start := time.Date(2018, time.May, 31, 10, 0, 0, 0, time.Local)
+
+	records, err := ReadDatafile(strings.NewReader(datafile(start)))
+	if err != nil || len(records) != 121 {
+		t.Fatalf("ReadDatafile() == %d records, %v, expect 121 records", len(records), err)
+	}
+
+	summaries := SummarizeRecords(records, DAY)
+
+	if len(summaries) != 1 {
+		t.Fatalf("SummarizeRecords() == %+v, expect 1 summary", summaries)
+	}
+	s := summaries[0]
+	if s.Period != "2018-05-31" || math.Abs(s.ChargedKwh-1) > 0.001 || math.Abs(s.DischargedKwh-0.9) > 0.001 ||
+		math.Abs(s.Efficiency-90) > 0.01 || s.MinSoc != 50 || s.MaxSoc != 60 || s.MaxTemp != 32 || s.Records != 121 {
+		t.Errorf("SummarizeRecords() == %+v, expect 1 kWh in, 0.9 kWh out, 90 %% efficiency", s)
+	}
+}
+
+// TestSummarizeMaxTemp tests the maximal temperature of periods below freezing and of periods that
+// start with records without temperature.
+func TestSummarizeMaxTemp(t *testing.T) {
+	start := time.Date(2018, time.January, 10, 10, 0, 0, 0, time.Local)
+
+	records := []Record{
+		{Time: start, Soc: 80, Voltage: 52, Current: -1},
+		{Time: start.Add(time.Minute), Soc: 80, Voltage: 52, Current: -1, Temp: -8.5, HasTemp: true},
+		{Time: start.Add(2 * time.Minute), Soc: 80, Voltage: 52, Current: -1, Temp: -3.2, HasTemp: true},
+		{Time: start.Add(3 * time.Minute), Soc: 80, Voltage: 52, Current: -1, Temp: -6.1, HasTemp: true},
+	}
+
+	summaries := SummarizeRecords(records, DAY)
+	if len(summaries) != 1 || summaries[0].MaxTemp != -3.2 {
+		t.Errorf("SummarizeRecords() == %+v, expect MaxTemp -3.2", summaries)
+	}
+}
+
+// TestReadDatafileFormats tests datafiles without temperature column, offline and partially written records.
+func TestReadDatafileFormats(t *testing.T) {
+	csvData := "Time,Soc,Voltage,Current\n" +
+		"2018/05/31 10:00:00,80,54.82,-1.10\n" +
+		",,,\n" +
+		"2018/05/31 10:01:00,80,54.8\n"
+
+	records, err := ReadDatafile(strings.NewReader(csvData))
+	expect := []Record{{Time: time.Date(2018, time.May, 31, 10, 0, 0, 0, time.Local), Soc: 80, Voltage: 54.82, Current: -1.1}}
+
+	if err != nil || !cmp.Equal(records, expect) {
+		t.Errorf("ReadDatafile() == %+v, %v, expect %+v", records, err, expect)
+	}
+
+	if _, err := ReadDatafile(strings.NewReader("Time,Soc\n")); err == nil {
+		t.Errorf("ReadDatafile() == nil for missing columns, expect error")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 3 days in 2 months, energy.json is ignored
+	for _, day := range []time.Time{
+		time.Date(2018, time.May, 30, 10, 0, 0, 0, time.Local),
+		time.Date(2018, time.May, 31, 10, 0, 0, 0, time.Local),
+		time.Date(2018, time.June, 1, 10, 0, 0, 0, time.Local)} {
+
+		path := filepath.Join(dir, day.Format("2006"), day.Format("01"))
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		fileName := filepath.Join(path, day.Format("20060102")+".csv")
+		if err := ioutil.WriteFile(fileName, []byte(datafile(day)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "energy.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := Summarize(dir, ".csv", MONTH)
+	if err != nil {
+		t.Fatalf("Summarize() == %v, expect nil", err)
+	}
+
+	if len(summaries) != 2 || summaries[0].Period != "2018-05" || math.Abs(summaries[0].ChargedKwh-2) > 0.001 ||
+		math.Abs(summaries[0].Efficiency-90) > 0.01 || summaries[1].Period != "2018-06" || summaries[1].Records != 121 {
+		t.Errorf("Summarize() == %+v, expect 2018-05: 2 kWh in, 2018-06: 121 records", summaries)
+	}
+
+	var b bytes.Buffer
+	if err := WriteCsv(&b, summaries); err != nil {
+		t.Fatalf("WriteCsv() == %v, expect nil", err)
+	}
+	expect := CsvHeader() +
+		"2018-05,2.00,1.80,90.0,50,60,32.0\n" +
+		"2018-06,1.00,0.90,90.0,50,60,32.0\n"
+	if b.String() != expect {
+		t.Errorf("WriteCsv() produce %q, expect %q", b.String(), expect)
+	}
+}
+
+// TestReadDatafilesMovedAside tests that datafiles moved aside by the datarecorder after a header change
+// (YYYYMMDD.csv.<n>) are read before the current datafile of the day.
+func TestReadDatafilesMovedAside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "2018", "05")
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, csvData := range map[string]string{
+		"20180531.csv.1": "Time,Soc,Voltage,Current\n2018/05/31 10:00:00,80,54.80,-1.00\n",
+		"20180531.csv.2": "Time,Soc,Voltage,Current,Power\n2018/05/31 10:01:00,79,54.70,-2.00,-109.4\n",
+		"20180531.csv": rs.CsvRecordHeader() +
+			(&rs.LgResuStatus{Soc: 78, Voltage: 54.6, Current: -3}).CsvRecord(time.Date(2018, time.May, 31, 10, 2, 0, 0, time.Local)),
+		"20180531.csv.bak": "Time,Soc,Voltage,Current\n2018/05/31 09:00:00,90,55.00,-1.00\n",
+		"20180530.csv":     "Time,Soc,Voltage,Current\n2018/05/30 23:59:00,81,54.90,-1.00\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(path, name), []byte(csvData), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ReadDatafiles(dir, ".csv")
+	socs := []uint16{}
+	for _, rec := range records {
+		socs = append(socs, rec.Soc)
+	}
+	if expect := []uint16{81, 80, 79, 78}; err != nil || !cmp.Equal(socs, expect) {
+		t.Errorf("ReadDatafiles() == SOC %v, %v, expect SOC %v, nil", socs, err, expect)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capacity estimates the usable battery capacity (Ah, kWh) from
+// discharge sessions and keeps a long-term (monthly) capacity trend.
+//
+// The usable capacity of a discharge session is the discharged capacity
+// divided by the SOC change:
+//
+//	capacity = discharged Ah / (start SOC - end SOC) * 100
+//
+// Only sessions with a SOC change of at least MIN_ESTIMATION_SOC are used
+// (the SOC is reported in 1% steps). The estimated capacity is the weighted
+// average of the last ESTIMATION_SESSIONS sessions (sum of discharged Ah /
+// sum of SOC changes), deeper discharges therefore have a higher weight.
+//
+// The estimated SOH (estimated capacity / nominal capacity) can be compared
+// with the SOH reported by the BMS.
+//
+// If no capacity estimates have been saved yet, the discharge sessions are
+// rebuilt from the CSV datafiles (the datafiles do not contain the SOH,
+// estimates of these sessions have no reported SOH). Only the sessions within
+// the datafile retention period (7 days by default) can be rebuilt.
+package capacity
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jens18/lgresu/analytics"
+	cc "github.com/jens18/lgresu/cyclecounter"
+	dr "github.com/jens18/lgresu/datarecorder"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// filename of the persisted capacity estimates (in the datafile root directory)
+	CAPACITY_FILE_NAME string = "capacity.json"
+	// extension of the CSV datafiles used to rebuild the discharge sessions
+	DATAFILE_EXTENSION string = ".csv"
+	// minimal SOC change (%) of a discharge session used for the estimation
+	MIN_ESTIMATION_SOC uint16 = 20
+	// number of sessions used for the estimated capacity
+	ESTIMATION_SESSIONS int = 10
+	// maximal number of estimates kept (the monthly trend is kept forever)
+	MAX_ESTIMATES int = 100
+)
+
+// Estimate contains the usable capacity estimated from a single discharge session.
+type Estimate struct {
+	Time     time.Time `json:"time"`
+	StartSoc uint16    `json:"startSoc"`
+	EndSoc   uint16    `json:"endSoc"`
+	// discharged capacity (Ah) and energy (Wh)
+	Ah float64 `json:"ah"`
+	Wh float64 `json:"wh"`
+	// usable capacity (Ah, kWh)
+	CapacityAh  float64 `json:"capacityAh"`
+	CapacityKwh float64 `json:"capacityKwh"`
+	// SOH reported by the BMS (0 if the SOH is unknown)
+	ReportedSoh uint16 `json:"reportedSoh"`
+}
+
+// TrendPoint contains the capacity estimation of a single month.
+type TrendPoint struct {
+	// month (for example: 2018-06)
+	Month    string `json:"month"`
+	Sessions int    `json:"sessions"`
+	// sum of discharged capacity (Ah), energy (Wh), SOC changes (%) and reported SOH (%)
+	Ah             float64 `json:"ah"`
+	Wh             float64 `json:"wh"`
+	Soc            float64 `json:"soc"`
+	ReportedSohSum float64 `json:"reportedSohSum"`
+	// number of sessions with reported SOH
+	ReportedSessions int `json:"reportedSessions"`
+	// usable capacity (Ah, kWh), estimated and average reported SOH (%, 0 if the SOH is unknown)
+	CapacityAh   float64 `json:"capacityAh"`
+	CapacityKwh  float64 `json:"capacityKwh"`
+	EstimatedSoh float64 `json:"estimatedSoh"`
+	ReportedSoh  float64 `json:"reportedSoh"`
+}
+
+// Report contains the comparison of the estimated capacity with the nominal capacity and the reported SOH.
+type Report struct {
+	NominalCapacity float64 `json:"nominalCapacity"`
+	// SOH reported by the BMS at the end of the last session with reported SOH (0 if the SOH is unknown)
+	ReportedSoh uint16 `json:"reportedSoh"`
+	// estimated usable capacity (Ah, kWh) and SOH (%) (0 without estimates)
+	CapacityAh   float64 `json:"capacityAh"`
+	CapacityKwh  float64 `json:"capacityKwh"`
+	EstimatedSoh float64 `json:"estimatedSoh"`
+	// number of sessions used for the estimation
+	Sessions  int          `json:"sessions"`
+	Trend     []TrendPoint `json:"trend"`
+	Estimates []Estimate   `json:"estimates"`
+}
+
+// capacityState contains the persisted state of the Tracker.
+type capacityState struct {
+	Estimates []Estimate   `json:"estimates"`
+	Trend     []TrendPoint `json:"trend"`
+}
+
+// Tracker collects the capacity estimates. Tracker can be used concurrently.
+type Tracker struct {
+	mu       sync.Mutex
+	fileName string
+	nominal  float64
+	state    capacityState
+}
+
+// NewTracker is the constructor for Tracker. fileName is the JSON file used to persist the capacity
+// estimates (no persistence if fileName is empty), nominal is the nominal battery capacity in Ah. Existing
+// estimates are read from fileName. If fileName does not exist, the estimates are rebuilt from the CSV
+// datafiles in the directory of fileName and saved.
+func NewTracker(fileName string, nominal float32) *Tracker {
+	tr, rebuilt := loadTracker(fileName, nominal)
+
+	if rebuilt {
+		if err := tr.Save(); err != nil {
+			log.Warnf("NewTracker: could not save capacity estimates (%v)\n", err)
+		}
+	}
+	return tr
+}
+
+// ReadTracker returns a read-only Tracker (for reports): the estimates are read from fileName (or rebuilt
+// from the CSV datafiles like NewTracker) but never written to fileName.
+func ReadTracker(fileName string, nominal float32) *Tracker {
+	tr, _ := loadTracker(fileName, nominal)
+	tr.fileName = ""
+	return tr
+}
+
+// loadTracker reads the estimates from fileName or rebuilds them from the CSV datafiles if fileName
+// does not exist. loadTracker returns true if the estimates have been rebuilt.
+func loadTracker(fileName string, nominal float32) (*Tracker, bool) {
+	tr := &Tracker{fileName: fileName, nominal: float64(nominal)}
+
+	if len(fileName) == 0 {
+		return tr, false
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		log.Infof("NewTracker: %s does not exist, rebuild capacity estimates from datafiles\n", fileName)
+		if err := tr.Backfill(filepath.Dir(fileName), DATAFILE_EXTENSION); err != nil {
+			log.Warnf("NewTracker: could not rebuild capacity estimates (%v), no capacity estimates\n", err)
+			return tr, false
+		}
+		return tr, true
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &tr.state)
+	}
+	if err != nil {
+		log.Warnf("NewTracker: could not read %s (%v), no capacity estimates\n", fileName, err)
+		tr.state = capacityState{}
+	}
+	return tr, false
+}
+
+// Backfill rebuilds the discharge sessions from the datafiles (<rootPath>/YYYY/MM/YYYYMMDD<extension>)
+// and adds their capacity estimates. The active session at the end of the datafiles is not added. Only
+// the datafiles within the datafile retention period (7 days by default) are available. Backfill does
+// not save the estimates (see Save).
+func (tr *Tracker) Backfill(rootPath string, extension string) error {
+	records, err := analytics.ReadDatafiles(rootPath, extension)
+	if err != nil {
+		return err
+	}
+
+	sessions := []cc.Session{}
+	counter := cc.NewCycleCounter("", float32(tr.nominal))
+	counter.OnSession(func(s cc.Session) { sessions = append(sessions, s) })
+	for _, rec := range records {
+		counter.Replay(rec.Time, &rs.LgResuStatus{Soc: rec.Soc, Voltage: rec.Voltage, Current: rec.Current},
+			analytics.MAX_RECORD_INTERVAL)
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	n := len(tr.state.Estimates)
+	for _, s := range sessions {
+		tr.addSession(s)
+	}
+	log.Infof("Tracker: %d capacity estimates from %d datafile records\n", len(tr.state.Estimates)-n, len(records))
+	return nil
+}
+
+// AddSession estimates the usable capacity from a discharge session. Charge sessions and sessions
+// with a SOC change below MIN_ESTIMATION_SOC are ignored.
+func (tr *Tracker) AddSession(s cc.Session) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if !tr.addSession(s) || len(tr.fileName) == 0 {
+		return
+	}
+	if err := tr.save(); err != nil {
+		log.Warnf("Tracker: could not save capacity estimates (%v)\n", err)
+	}
+}
+
+// addSession adds the capacity estimate of a discharge session (tr.mu must be locked). addSession returns
+// false if the session is ignored.
+func (tr *Tracker) addSession(s cc.Session) bool {
+	if s.Type != cc.SESSION_DISCHARGE || s.StartSoc < s.EndSoc+MIN_ESTIMATION_SOC {
+		return false
+	}
+
+	soc := float64(s.StartSoc - s.EndSoc)
+	e := Estimate{Time: s.End, StartSoc: s.StartSoc, EndSoc: s.EndSoc, Ah: s.Ah, Wh: s.Wh,
+		CapacityAh: s.Ah / soc * 100, CapacityKwh: s.Wh / soc * 100 / 1000, ReportedSoh: s.Soh}
+
+	log.Infof("Tracker: estimated capacity %.1f Ah (%.2f kWh) from discharge session %d %% -> %d %%\n",
+		e.CapacityAh, e.CapacityKwh, s.StartSoc, s.EndSoc)
+
+	tr.state.Estimates = append(tr.state.Estimates, e)
+	if len(tr.state.Estimates) > MAX_ESTIMATES {
+		tr.state.Estimates = tr.state.Estimates[len(tr.state.Estimates)-MAX_ESTIMATES:]
+	}
+
+	// monthly trend
+	month := s.End.Local().Format("2006-01")
+	if n := len(tr.state.Trend); n == 0 || tr.state.Trend[n-1].Month != month {
+		tr.state.Trend = append(tr.state.Trend, TrendPoint{Month: month})
+	}
+	tp := &tr.state.Trend[len(tr.state.Trend)-1]
+	tp.Sessions++
+	tp.Ah += s.Ah
+	tp.Wh += s.Wh
+	tp.Soc += soc
+	tp.CapacityAh = tp.Ah / tp.Soc * 100
+	tp.CapacityKwh = tp.Wh / tp.Soc * 100 / 1000
+	tp.EstimatedSoh = tp.CapacityAh / tr.nominal * 100
+	if s.Soh != 0 {
+		tp.ReportedSessions++
+		tp.ReportedSohSum += float64(s.Soh)
+		tp.ReportedSoh = tp.ReportedSohSum / float64(tp.ReportedSessions)
+	}
+	return true
+}
+
+// Report returns the estimated capacity (based on the last ESTIMATION_SESSIONS estimates) and
+// copies of the trend and the estimates.
+func (tr *Tracker) Report() Report {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	r := Report{NominalCapacity: tr.nominal}
+	r.Trend = append([]TrendPoint{}, tr.state.Trend...)
+	r.Estimates = append([]Estimate{}, tr.state.Estimates...)
+
+	estimates := r.Estimates
+	if len(estimates) > ESTIMATION_SESSIONS {
+		estimates = estimates[len(estimates)-ESTIMATION_SESSIONS:]
+	}
+	if len(estimates) == 0 {
+		return r
+	}
+
+	var ah, wh, soc float64
+	for _, e := range estimates {
+		ah += e.Ah
+		wh += e.Wh
+		soc += float64(e.StartSoc - e.EndSoc)
+	}
+	r.Sessions = len(estimates)
+	r.CapacityAh = ah / soc * 100
+	r.CapacityKwh = wh / soc * 100 / 1000
+	r.EstimatedSoh = r.CapacityAh / tr.nominal * 100
+	for _, e := range r.Estimates {
+		if e.ReportedSoh != 0 {
+			r.ReportedSoh = e.ReportedSoh
+		}
+	}
+	return r
+}
+
+// WriteReport writes the report as text to w.
+func (r Report) WriteReport(w io.Writer) error {
+	b := &errWriter{w: w}
+
+	b.printf("nameplate capacity:  %6.1f Ah\n", r.NominalCapacity)
+	if r.Sessions == 0 {
+		b.printf("estimated capacity:  no discharge sessions with a SOC change of at least %d %%\n", MIN_ESTIMATION_SOC)
+		return b.err
+	}
+	b.printf("estimated capacity:  %6.1f Ah (%.2f kWh, last %d discharge sessions)\n", r.CapacityAh, r.CapacityKwh, r.Sessions)
+	b.printf("estimated SOH:       %6.1f %%\n", r.EstimatedSoh)
+	if r.ReportedSoh != 0 {
+		b.printf("reported SOH:        %6d %%\n", r.ReportedSoh)
+	} else {
+		b.printf("reported SOH:        unknown (estimates from datafiles)\n")
+	}
+
+	b.printf("\n%-8s %11s %9s %8s %8s %8s\n", "month", "capacity", "energy", "SOH", "BMS SOH", "sessions")
+	for _, tp := range r.Trend {
+		b.printf("%-8s %8.1f Ah %5.2f kWh %6.1f %% %6.1f %% %8d\n",
+			tp.Month, tp.CapacityAh, tp.CapacityKwh, tp.EstimatedSoh, tp.ReportedSoh, tp.Sessions)
+	}
+	return b.err
+}
+
+// errWriter keeps the first write error.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (b *errWriter) printf(format string, a ...interface{}) {
+	if b.err == nil {
+		_, b.err = fmt.Fprintf(b.w, format, a...)
+	}
+}
+
+// Save writes the capacity estimates to the JSON file (nothing is written without JSON file).
+func (tr *Tracker) Save() error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if len(tr.fileName) == 0 {
+		return nil
+	}
+	return tr.save()
+}
+
+// save writes the capacity estimates to the JSON file (tr.mu must be locked).
+func (tr *Tracker) save() error {
+	return dr.WriteJSONFile(tr.fileName, tr.state)
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capacity
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	cc "github.com/jens18/lgresu/cyclecounter"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+// session returns a discharge session with a usable capacity of capacityAh at 50 V.
+func session(end time.Time, startSoc, endSoc uint16, capacityAh float64) cc.Session {
+	ah := capacityAh * float64(startSoc-endSoc) / 100
+	return cc.Session{Type: cc.SESSION_DISCHARGE, Start: end.Add(-time.Hour), End: end,
+		StartSoc: startSoc, EndSoc: endSoc, Ah: ah, Wh: ah * 50, Soh: 99}
+}
+
+var TestSessions = []cc.Session{
+	// ignored: charge session, SOC change below MIN_ESTIMATION_SOC
+	{Type: cc.SESSION_CHARGE, End: time.Date(2018, time.June, 1, 12, 0, 0, 0, time.Local), StartSoc: 20, EndSoc: 100, Ah: 151, Soh: 99},
+	session(time.Date(2018, time.June, 2, 6, 0, 0, 0, time.Local), 100, 85, 180),
+	// used
+	session(time.Date(2018, time.June, 3, 6, 0, 0, 0, time.Local), 100, 50, 180),
+	session(time.Date(2018, time.June, 4, 6, 0, 0, 0, time.Local), 80, 30, 180),
+	session(time.Date(2018, time.July, 1, 6, 0, 0, 0, time.Local), 100, 20, 170),
+}
+
+func TestTrackerReport(t *testing.T) {
+	tr := NewTracker("", rs.NOMINAL_CAPACITY)
+
+	if r := tr.Report(); r.Sessions != 0 || r.CapacityAh != 0 {
+		t.Errorf("tr.Report() == %+v without sessions, expect no estimation", r)
+	}
+
+	for _, s := range TestSessions {
+		tr.AddSession(s)
+	}
+
+	r := tr.Report()
+
+	// (90 + 90 + 136) Ah / (50 + 50 + 80) %
+	expect := (90.0 + 90.0 + 136.0) / 180 * 100
+	if r.Sessions != 3 || math.Abs(r.CapacityAh-expect) > 0.01 || math.Abs(r.CapacityKwh-expect*50/1000) > 0.001 ||
+		math.Abs(r.EstimatedSoh-expect/189*100) > 0.01 || r.ReportedSoh != 99 || r.NominalCapacity != 189 {
+		t.Errorf("tr.Report() == %+v, expect 3 sessions, capacity %.2f Ah", r, expect)
+	}
+
+	if len(r.Trend) != 2 || r.Trend[0].Month != "2018-06" || math.Abs(r.Trend[0].CapacityAh-180) > 0.01 ||
+		r.Trend[1].Month != "2018-07" || math.Abs(r.Trend[1].CapacityAh-170) > 0.01 || r.Trend[1].ReportedSoh != 99 {
+		t.Errorf("r.Trend == %+v, expect 2018-06: 180 Ah, 2018-07: 170 Ah", r.Trend)
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteReport(&b); err != nil {
+		t.Fatalf("r.WriteReport() == %v, expect nil", err)
+	}
+	if !strings.Contains(b.String(), "estimated capacity:   175.6 Ah") || !strings.Contains(b.String(), "2018-07     170.0 Ah") {
+		t.Errorf("r.WriteReport() produce:\n%s", b.String())
+	}
+}
+
+// TestTrackerPersistence tests that the capacity estimates survive a restart.
+func TestTrackerPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, CAPACITY_FILE_NAME)
+
+	tr := NewTracker(fileName, rs.NOMINAL_CAPACITY)
+	for _, s := range TestSessions {
+		tr.AddSession(s)
+	}
+
+	restarted := NewTracker(fileName, rs.NOMINAL_CAPACITY)
+	if r := restarted.Report(); !cmp.Equal(r, tr.Report()) {
+		t.Errorf("restarted.Report() == %+v, expect %+v", r, tr.Report())
+	}
+}
+
+// TestTrackerBackfill tests that the discharge sessions are rebuilt from the datafiles if no capacity
+// estimates have been saved.
+func TestTrackerBackfill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// one record per minute: idle, discharge 100% -> 50% with 18.9A (301 minutes), idle (ends the session)
+	csvData := rs.CsvRecordHeader()
+	now := time.Date(2018, time.June, 2, 0, 0, 0, 0, time.Local)
+	for i := 0; i < 30; i++ {
+		csvData += (&rs.LgResuStatus{Soc: 100, Voltage: 56, Current: 0}).CsvRecord(now)
+		now = now.Add(time.Minute)
+	}
+	for k := 0; k <= 300; k++ {
+		csvData += (&rs.LgResuStatus{Soc: uint16(100 - k/6), Voltage: 50, Current: -18.9}).CsvRecord(now)
+		now = now.Add(time.Minute)
+	}
+	for i := 0; i < 30; i++ {
+		csvData += (&rs.LgResuStatus{Soc: 50, Voltage: 49, Current: 0.3}).CsvRecord(now)
+		now = now.Add(time.Minute)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "2018", "06"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "2018", "06", "20180602.csv"), []byte(csvData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(dir, CAPACITY_FILE_NAME)
+
+	// a report does not save the rebuilt estimates
+	r := ReadTracker(fileName, rs.NOMINAL_CAPACITY).Report()
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("ReadTracker() created %s (%v), expect no file", fileName, err)
+	}
+	if cmp.Equal(r, NewTracker("", rs.NOMINAL_CAPACITY).Report()) {
+		t.Errorf("ReadTracker().Report() == %+v, expect rebuilt estimates", r)
+	}
+
+	r = NewTracker(fileName, rs.NOMINAL_CAPACITY).Report()
+
+	expect := 18.9 * 301 / 60 / 50 * 100
+	if r.Sessions != 1 || math.Abs(r.CapacityAh-expect) > 0.01 || r.ReportedSoh != 0 ||
+		len(r.Trend) != 1 || r.Trend[0].ReportedSoh != 0 {
+		t.Errorf("tr.Report() == %+v, expect 1 session, capacity %.2f Ah, unknown SOH", r, expect)
+	}
+
+	// the rebuilt estimates are saved
+	if restarted := NewTracker(fileName, rs.NOMINAL_CAPACITY).Report(); !cmp.Equal(restarted, r) {
+		t.Errorf("restarted.Report() == %+v, expect %+v", restarted, r)
+	}
+}
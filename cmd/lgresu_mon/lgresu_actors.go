@@ -20,10 +20,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/brutella/can"
+	"github.com/jens18/lgresu/analytics"
+	"github.com/jens18/lgresu/capacity"
+	cc "github.com/jens18/lgresu/cyclecounter"
+	"github.com/jens18/lgresu/keepalive"
 	rs "github.com/jens18/lgresu/lgresustatus"
+	"github.com/jens18/lgresu/pylontech"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -33,6 +39,10 @@ type DatarecorderIf interface {
 
 const (
 	keepAliveInterval int = 20
+	eventLogSize      int = 100
+	// keep-alive profiles (in addition to the built-in keep-alive profiles)
+	BMS_KEEP_ALIVE   string = "bms"
+	LEARN_KEEP_ALIVE string = "learn"
 )
 
 type CanbusIf interface {
@@ -40,23 +50,131 @@ type CanbusIf interface {
 	Disconnect() error
 }
 
-// terminateMonitor receive operating system signal messages (SIGTERM, SIGKILL) via osSigChan and
-// issue a message via the termSigChan before disconnecting from the CANBus and terminating the server.
-func terminateMonitor(osSigChan <-chan os.Signal, termSigChan chan<- bool, bus CanbusIf) {
+// StatusUpdaterIf computes derived metrics (ie. power and energy counters) after every status update.
+type StatusUpdaterIf interface {
+	Update(time.Time, *rs.LgResuStatus)
+}
+
+// KeepAliveGateIf decides if a keep-alive message is send (ie. no other device sends keep-alive messages).
+type KeepAliveGateIf interface {
+	Allow(time.Time) bool
+}
+
+// SaverIf persists state (energy counters, estimated SOC, cycle history, capacity estimates).
+type SaverIf interface {
+	Save() error
+}
+
+type EventRecorderIf interface {
+	RecordEvent(rs.Event, *rs.LgResuStatus)
+}
+
+// eventRecorder keeps the most recent warning/alarm events in memory (for HTTP requests)
+// and writes every event to an event datafile. In discovery mode (discoveryRecorder != nil)
+// every event is also written together with the key metrics at the time of the event to
+// a discovery datafile.
+type eventRecorder struct {
+	eventLog          *rs.EventLog
+	dataRecorder      DatarecorderIf
+	discoveryRecorder DatarecorderIf
+}
+
+// RecordEvent adds a warning/alarm event to the event log and the event datafile. lgResu
+// is the status at the time of the event.
+func (er *eventRecorder) RecordEvent(e rs.Event, lgResu *rs.LgResuStatus) {
+	log.Infof("RecordEvent: %s %s %s\n", e.Type, e.Description, e.State)
+
+	er.eventLog.Add(e)
+	er.dataRecorder.WriteToDatafile(e.Time, e.CsvRecord())
+
+	if er.discoveryRecorder != nil {
+		er.discoveryRecorder.WriteToDatafile(e.Time, e.DiscoveryCsvRecord(lgResu))
+	}
+}
+
+// batteryConfig contains the name and the CANBus interface of a single battery.
+type batteryConfig struct {
+	name   string
+	ifName string
+}
+
+// parseBatteries parses a comma separated list of named batteries with their CANBus interfaces
+// (for example: resu1=can0,resu2=can1). Battery names are used as datafile directory names.
+func parseBatteries(s string) ([]batteryConfig, error) {
+	configs := []batteryConfig{}
+	names := map[string]bool{}
+
+	for _, b := range strings.Split(s, ",") {
+		nameIf := strings.Split(strings.TrimSpace(b), "=")
+		if len(nameIf) != 2 || len(nameIf[0]) == 0 || len(nameIf[1]) == 0 {
+			return nil, fmt.Errorf("parseBatteries: invalid battery %q, expect <name>=<interface>", b)
+		}
+		if strings.ContainsAny(nameIf[0], "/\\.") {
+			return nil, fmt.Errorf("parseBatteries: invalid battery name %q", nameIf[0])
+		}
+		if names[nameIf[0]] {
+			return nil, fmt.Errorf("parseBatteries: duplicate battery name %q", nameIf[0])
+		}
+		names[nameIf[0]] = true
+		configs = append(configs, batteryConfig{nameIf[0], nameIf[1]})
+	}
+	return configs, nil
+}
+
+// battery contains the channels to request the latest status of a single battery from its
+// brokerRecord goroutine.
+type battery struct {
+	name           string
+	httpSigChan    chan bool
+	recordHttpChan chan rs.LgResuStatus
+}
+
+// status returns the latest status of the battery (without metrics if the battery is offline).
+func (b *battery) status() rs.LgResuStatus {
+	// signal a request has arrived (and block)
+	b.httpSigChan <- true
+	// receive the latest lgResu status update (and block)
+	lgResu := <-b.recordHttpChan
+	return lgResu.OfflineStatus()
+}
+
+// saveState persists the state of all savers (errors are logged).
+func saveState(savers []SaverIf) {
+	for _, s := range savers {
+		if err := s.Save(); err != nil {
+			log.Warnf("saveState: could not save state (%v)\n", err)
+		}
+	}
+}
+
+// terminateMonitor receive operating system signal messages (SIGTERM, SIGKILL) via osSigChan or a CANBus
+// error via busErrChan (the CANBus connection is lost and no longer receives messages) and close termSigChan
+// before disconnecting from the CANBus(es), persisting the state of savers and terminating the server.
+func terminateMonitor(osSigChan <-chan os.Signal, busErrChan <-chan error, termSigChan chan<- bool, savers []SaverIf,
+	buses ...CanbusIf) {
 
 	select {
 	case <-osSigChan:
 		fmt.Printf("main: received SIGKILL/SIGTERM\n")
-		// terminate sendKeepAlive goroutine
-		termSigChan <- true
+	case err := <-busErrChan:
+		log.Errorf("main: CANBus connection lost (%v)\n", err)
+	}
+
+	// terminate all sendKeepAlive goroutines
+	close(termSigChan)
+	for _, bus := range buses {
 		bus.Disconnect()
-		time.Sleep(time.Second * 1)
-		os.Exit(1)
 	}
+	time.Sleep(time.Second * 1)
+	saveState(savers)
+	os.Exit(1)
 }
 
-// decodeCanFrame returns a function that implements the can.Handler interface.
-func decodeCanFrame(recordEmitChan chan<- rs.LgResuStatus) func(can.Frame) {
+// decodeCanFrame returns a function that implements the can.Handler interface. CANBus frames
+// are decoded with the decoder of the selected BMS profile. Warning/alarm transitions are
+// send to eventRecorder. Derived metrics are computed by updaters after every status update.
+func decodeCanFrame(decoder rs.Decoder, recordEmitChan chan<- rs.LgResuStatus, eventRecorder EventRecorderIf,
+	updaters ...StatusUpdaterIf) func(can.Frame) {
 	// https://www.calhoun.io/5-useful-ways-to-use-closures-in-go/
 
 	// lgResu holds the current state of metrics from the LG Resu 10.
@@ -66,7 +184,46 @@ func decodeCanFrame(recordEmitChan chan<- rs.LgResuStatus) func(can.Frame) {
 	lgResu := &rs.LgResuStatus{}
 
 	return func(frm can.Frame) {
-		lgResu.DecodeLgResuCanbusMessage(frm.ID, frm.Data[:])
+		length := int(frm.Length)
+		if length > len(frm.Data) {
+			length = len(frm.Data)
+		}
+
+		// invalid messages are counted (and do not update lgResu)
+		u, err := decoder.Decode(frm.ID, frm.Data[:length])
+		switch err.(type) {
+		case nil:
+			now := time.Now()
+			previous := *lgResu
+			u.Apply(lgResu)
+			lgResu.ReceivedFields |= u.Fields
+
+			// messages without metrics (ie. keep-alive messages send by the inverter) and messages
+			// of other CANBus nodes (0x35C/0x35E) do not indicate that the BMS is online
+			if u.Fields&^rs.UPDATE_OTHER_NODES != 0 {
+				lgResu.Received(frm.ID, now)
+
+				for _, updater := range updaters {
+					updater.Update(now, lgResu)
+				}
+			}
+
+			for _, e := range rs.Transitions(now, &previous, lgResu) {
+				eventRecorder.RecordEvent(e, lgResu)
+			}
+
+			// charge/discharge requests and manufacturer name (0x35C/0x35E) of other CANBus nodes
+			if strings.Join(previous.Requests, ",") != strings.Join(lgResu.Requests, ",") ||
+				previous.Manufacturer != lgResu.Manufacturer {
+				log.Infof("decodeCanFrame: %#03x: requests %v, manufacturer %q\n", frm.ID, lgResu.Requests, lgResu.Manufacturer)
+			}
+		case *rs.UnknownMessageError:
+			log.Debugf("decodeCanFrame: %v\n", err)
+			lgResu.UnknownMessages++
+		default:
+			log.Warnf("decodeCanFrame: %v\n", err)
+			lgResu.DecodeErrors++
+		}
 
 		// send the latest lgResu status update (and block (briefly until BrokerRecord has read lgResu))
 		recordEmitChan <- *lgResu
@@ -104,11 +261,30 @@ func writeRecord(writeSigChan chan<- bool,
 // and responds to lower frequency requests to either persist the LgResuStatus object (writeSigChan to
 // signal a request, recordWriteChan to send the LgResuStatus object) or to respond to a pending
 // HTTP request (httpSignChan to signal a request, httpWriteChan to send the LgResuStatus object).
+// Messages that have not been received within staleTimeout are marked as stale before the LgResuStatus
+// object is send.
 func brokerRecord(recordEmitChan <-chan rs.LgResuStatus,
 	writeSigChan <-chan bool, recordWriteChan chan<- rs.LgResuStatus,
-	httpSigChan <-chan bool, httpWriteChan chan<- rs.LgResuStatus) {
-
-	lgResu := rs.LgResuStatus{}
+	httpSigChan <-chan bool, httpWriteChan chan<- rs.LgResuStatus,
+	staleTimeout time.Duration) {
+
+	// the BMS is offline until the first message is received
+	lgResu := rs.LgResuStatus{Offline: true}
+	offline := true
+
+	// fresh returns lgResu with updated stale flags
+	fresh := func() rs.LgResuStatus {
+		lgResu.UpdateFreshness(time.Now(), staleTimeout)
+		if lgResu.Offline != offline {
+			offline = lgResu.Offline
+			if offline {
+				log.Warnf("BrokerRecord(): no message received within %v, BMS is offline\n", staleTimeout)
+			} else {
+				log.Infof("BrokerRecord(): BMS is online\n")
+			}
+		}
+		return lgResu
+	}
 
 	for {
 		select {
@@ -116,42 +292,300 @@ func brokerRecord(recordEmitChan <-chan rs.LgResuStatus,
 			log.Debugf("BrokerRecord(): received %v\n", lgResu)
 		case <-writeSigChan:
 			log.Debugf("BrokerRecord(): received writeSigChan\n")
-			recordWriteChan <- lgResu
+			recordWriteChan <- fresh()
 		case <-httpSigChan:
 			log.Debugf("BrokerRecord(): received httpSigChan\n")
-			httpWriteChan <- lgResu
+			httpWriteChan <- fresh()
 		}
 	}
 }
 
-// sendKeepAlive send keep-alive messages in KeepAliveInterval second intervals until it receives termination message.
-func sendKeepAlive(c <-chan bool, bus CanbusIf, interval int) {
-	lgResu := &rs.LgResuStatus{}
+// sendKeepAlive send keep-alive messages in the interval of the keep-alive profile until it receives a
+// termination message. The keep-alive profile is replaced by profiles received via profileChan (ie. a
+// learned keep-alive message). A keep-alive message is only send if all gates allow it.
+func sendKeepAlive(c <-chan bool, bus CanbusIf, profile keepalive.Profile, profileChan <-chan keepalive.Profile,
+	gates ...KeepAliveGateIf) {
 	frm := can.Frame{}
 
 	for {
+		// no keep-alive message: wait for termination or a new profile
+		var timeout <-chan time.Time
+		if profile.Data != nil {
+			timeout = time.After(profile.Interval)
+		}
+
 		select {
 		case <-c:
 			log.Debugf("sendKeepAlive: received termination message\n")
 			return
-		case <-time.After(time.Duration(interval) * time.Second):
-			log.Infof("sendKeepAlive: %d sec time out, sending keep-alive\n", interval)
+		case profile = <-profileChan:
+			log.Infof("sendKeepAlive: keep-alive profile %v\n", profile)
+		case <-timeout:
+			if !allowKeepAlive(time.Now(), gates) {
+				log.Debugf("sendKeepAlive: %v time out, keep-alive suppressed\n", profile.Interval)
+				continue
+			}
+			log.Infof("sendKeepAlive: %v time out, sending keep-alive\n", profile.Interval)
+
+			log.Debugf("sendKeepAlive: %#4x # % -24X \n", profile.ID, profile.Data)
+
+			frm.ID = profile.ID
+			frm.Length = uint8(len(profile.Data))
+			// copy must be 'tricked' into treating the array as a slice
+			copy(frm.Data[:], profile.Data)
+
+			bus.Publish(frm)
+		}
+	}
+}
 
-			id, data := lgResu.CreateKeepAliveMessage()
+// policyGate adapts the keep-alive policy (independent of the time) to KeepAliveGateIf.
+type policyGate struct {
+	policy *keepalive.Policy
+}
 
-			log.Debugf("sendKeepAlive: %#4x # % -24X \n", id, data)
+func (g policyGate) Allow(time.Time) bool {
+	return g.policy.Allow()
+}
 
-			frm.ID = id
-			frm.Length = uint8(len(data))
-			// copy must be 'tricked' into treating the array as a slice
-			copy(frm.Data[:], data[0:8])
+// allowKeepAlive returns true if all gates allow a keep-alive message.
+func allowKeepAlive(t time.Time, gates []KeepAliveGateIf) bool {
+	for _, g := range gates {
+		if !g.Allow(t) {
+			return false
+		}
+	}
+	return true
+}
 
-			bus.Publish(frm)
+// watchKeepAlive returns a function that implements the can.Handler interface. Received CANBus frames
+// are passed to the arbiter to detect keep-alive messages send by other devices.
+func watchKeepAlive(arbiter *keepalive.Arbiter) func(can.Frame) {
+	return func(frm can.Frame) {
+		arbiter.Observe(time.Now(), frm.ID)
+	}
+}
+
+// relayKeepAlive returns a function that implements the can.Handler interface. Keep-alive messages (id)
+// received from the inverter on the bridge CANBus are published on bus (BMS CANBus) and passed to the
+// arbiter (no keep-alive messages are send while the inverter keep-alive message is relayed).
+func relayKeepAlive(bus CanbusIf, id uint32, arbiter *keepalive.Arbiter) func(can.Frame) {
+	return func(frm can.Frame) {
+		if frm.ID != id {
+			return
+		}
+		if int(frm.Length) > len(frm.Data) {
+			frm.Length = uint8(len(frm.Data))
+		}
+		log.Debugf("relayKeepAlive: %#4x # % -24X \n", frm.ID, frm.Data[:frm.Length])
+
+		arbiter.Observe(time.Now(), frm.ID)
+		bus.Publish(frm)
+	}
+}
+
+// publishBridge publishes the Pylontech messages of the bridge on bus (bridge CANBus) every interval.
+func publishBridge(c <-chan bool, bus CanbusIf, bridge *pylontech.Bridge, interval time.Duration) {
+	frm := can.Frame{}
+
+	for {
+		select {
+		case <-c:
+			log.Debugf("publishBridge: received termination message\n")
+			return
+		case <-time.After(interval):
+			messages := bridge.Messages(time.Now())
+			if messages == nil {
+				log.Debugf("publishBridge: no BMS status, no messages published\n")
+				continue
+			}
+
+			for _, m := range messages {
+				frm.ID = m.Id
+				frm.Length = uint8(len(m.Data))
+				copy(frm.Data[:], m.Data)
+
+				bus.Publish(frm)
+			}
+		}
+	}
+}
+
+// learnKeepAlive returns a function that implements the can.Handler interface. The keep-alive message
+// of another device is learned from the received CANBus frames, saved to fileName and send to
+// profileChan (to be replayed by sendKeepAlive).
+func learnKeepAlive(learner *keepalive.Learner, profileChan chan<- keepalive.Profile, fileName string) func(can.Frame) {
+	learned := false
+
+	return func(frm can.Frame) {
+		if learned {
+			return
+		}
+
+		length := int(frm.Length)
+		if length > len(frm.Data) {
+			length = len(frm.Data)
+		}
+
+		p, ok := learner.Observe(time.Now(), frm.ID, frm.Data[:length])
+		if !ok {
+			return
+		}
+		learned = true
+
+		if err := keepalive.SaveProfile(fileName, p); err != nil {
+			log.Warnf("learnKeepAlive: could not save learned keep-alive message (%v)\n", err)
+		}
+		profileChan <- p
+	}
+}
+
+// Events processes HTTP requests and generates a JSON response containing the most recent warning/alarm events.
+func Events(eventLog *rs.EventLog) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		events := eventLog.Events()
+		log.Infof("Events: %d events \n", len(events))
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(events)
+	}
+}
+
+// KeepAlive processes HTTP requests and generates a JSON response containing the keep-alive role
+// (active or passive).
+func KeepAlive(arbiter *keepalive.Arbiter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		status := arbiter.Status(time.Now())
+		log.Infof("KeepAlive: role %s \n", status.Role)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// KeepAlivePolicy processes HTTP requests and generates a JSON response containing the state and the
+// decisions of the keep-alive policy. A POST request with the form value override (auto, on or off)
+// sets the manual override.
+func KeepAlivePolicy(policy *keepalive.Policy) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method == http.MethodPost {
+			override := r.FormValue("override")
+			log.Infof("KeepAlivePolicy: manual override %s \n", override)
+
+			if err := policy.SetOverride(time.Now(), override); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		status := policy.Status()
+		log.Infof("KeepAlivePolicy: state %s, override %s \n", status.State, status.Override)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// Cycles processes HTTP requests and generates a JSON response containing the cycle history (equivalent
+// full cycles, DoD histogram, charge/discharge sessions).
+func Cycles(cycleCounter *cc.CycleCounter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		history := cycleCounter.History()
+		log.Infof("Cycles: %.2f equivalent full cycles, %d sessions \n", history.EquivalentCycles, len(history.Sessions))
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// Capacity processes HTTP requests and generates a JSON response containing the estimated usable capacity
+// and SOH trend.
+func Capacity(tracker *capacity.Tracker) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		report := tracker.Report()
+		log.Infof("Capacity: %.1f Ah estimated from %d sessions \n", report.CapacityAh, report.Sessions)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// Analytics processes HTTP requests and generates a JSON response containing the energy balance per
+// period (analytics.DAY or analytics.MONTH) computed from the CSV datafiles. The query parameter
+// format=csv generates a CSV response.
+func Analytics(dataDirRoot string, period string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		summaries, err := analytics.Summarize(dataDirRoot, ".csv", period)
+		if err != nil {
+			log.Warnf("Analytics: %v \n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Analytics: %d summaries \n", len(summaries))
+
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+			analytics.WriteCsv(w, summaries)
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(summaries)
+	}
+}
+
+// batteriesStatus contains the status of every battery and the aggregate status.
+type batteriesStatus struct {
+	Aggregate rs.LgResuStatus            `json:"aggregate"`
+	Batteries map[string]rs.LgResuStatus `json:"batteries"`
+}
+
+// aggregateStatus returns the status of every battery and the aggregate status.
+func aggregateStatus(batteries []*battery) batteriesStatus {
+	statuses := make([]rs.LgResuStatus, len(batteries))
+	bs := batteriesStatus{Batteries: map[string]rs.LgResuStatus{}}
+
+	for i, b := range batteries {
+		statuses[i] = b.status()
+		bs.Batteries[b.name] = statuses[i]
+	}
+	bs.Aggregate = rs.Aggregate(statuses)
+	return bs
+}
+
+// Aggregate processes HTTP requests and generates a JSON response containing the aggregate status of
+// all batteries (same format as Index).
+func Aggregate(batteries []*battery) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		lgResu := aggregateStatus(batteries).Aggregate
+		log.Infof("Aggregate: lgResu = %+v \n", lgResu)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(lgResu)
+	}
+}
+
+// Batteries processes HTTP requests and generates a JSON response containing the status of every
+// battery (by name) and the aggregate status.
+func Batteries(batteries []*battery) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		bs := aggregateStatus(batteries)
+		log.Infof("Batteries: %d batteries \n", len(bs.Batteries))
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(bs)
 	}
 }
 
-// Index processes HTTP requests and generates a JSON response.
+// Index processes HTTP requests and generates a JSON response. The metrics of an offline BMS are 0.
 func Index(httpSigChan chan<- bool, recordHttpChan <-chan rs.LgResuStatus) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -162,6 +596,7 @@ func Index(httpSigChan chan<- bool, recordHttpChan <-chan rs.LgResuStatus) func(
 
 		// receive the latest lgResu status update (and block)
 		lgResu = <-recordHttpChan
+		lgResu = lgResu.OfflineStatus()
 		log.Infof("Index: lgResu = %+v \n", lgResu)
 
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
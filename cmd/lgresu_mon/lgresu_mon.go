@@ -21,13 +21,26 @@ import (
 	"fmt"
 	"github.com/brutella/can"
 	"github.com/gorilla/mux"
+	"github.com/jens18/lgresu/analytics"
+	"github.com/jens18/lgresu/capacity"
+	cc "github.com/jens18/lgresu/cyclecounter"
 	dr "github.com/jens18/lgresu/datarecorder"
+	_ "github.com/jens18/lgresu/discoveraes"
+	em "github.com/jens18/lgresu/energymeter"
+	"github.com/jens18/lgresu/keepalive"
 	rs "github.com/jens18/lgresu/lgresustatus"
+	_ "github.com/jens18/lgresu/lithiumate"
+	"github.com/jens18/lgresu/pylontech"
+	se "github.com/jens18/lgresu/socestimator"
+	tr "github.com/jens18/lgresu/timeremaining"
+	"github.com/jens18/lgresu/transport"
 	log "github.com/sirupsen/logrus"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 var (
@@ -38,11 +51,27 @@ func main() {
 	log.Infof("lgresu_mon:\n")
 
 	// default value is the virtual CANBus interface: vcan0
-	i := flag.String("if", "vcan0", "network interface name")
+	i := flag.String("if", "vcan0", "network interface name or CANBus URL (example: socketcan://can0, socketcand://host:29536/can0, slcan:///dev/ttyACM0, cannelloni://host:20000, loopback: cannelloni://127.0.0.1:20001?local=127.0.0.1:20000)")
+	batteryList := flag.String("batteries", "", "named batteries with their network interfaces (example: resu1=can0,resu2=can1), replaces -if")
+	bridgeIf := flag.String("bridge", "", "bridge network interface name: publish Pylontech compatible messages and relay the inverter keep-alive message (single battery only)")
+	bms := flag.String("bms", rs.LG_RESU_PROFILE, "BMS profile: "+strings.Join(rs.Profiles(), ", "))
 	logLevel := flag.String("d", "info", "log level: debug, info, warn, error")
 	port := flag.String("p", "9090", "port number")
 	dataDirRoot := flag.String("dr", "/opt/lgresu", "root directory for metric datafiles")
 	retentionPeriod := flag.Int("r", 7, "metric datafile retention period in days")
+	capacityReport := flag.Bool("capacity", false, "write the usable capacity and SOH trend estimated from the datafile root directory (every battery with -batteries) to stdout")
+	dbcFile := flag.String("dbc", "", "DBC file with message definitions (replaces the decoder of the BMS profile)")
+	exportDbc := flag.Bool("exportdbc", false, "write the built-in LG Resu 10 LV message definitions in DBC format to stdout")
+	discover := flag.Bool("discover", false, "record every warning/alarm bit change in a discovery datafile (.bits)")
+	ka := flag.String("ka", BMS_KEEP_ALIVE, "keep-alive profile: "+BMS_KEEP_ALIVE+" (keep-alive of the BMS profile), "+
+		LEARN_KEEP_ALIVE+" (learn and replay the keep-alive of another device)")
+	kaData := flag.String("kadata", "", "keep-alive payload in hex (example: 0000000000000000), overrides the keep-alive profile")
+	kaId := flag.Uint("kaid", 0, "keep-alive message id (example: 0x305), overrides the keep-alive profile")
+	kaPolicy := flag.String("kapolicy", "", "keep-alive policy rules (example: soc<10:hold,voltage<47.5:hold,temp>50:stop,alarm=*:stop)")
+	kaTimeout := flag.Int("katimeout", int(keepalive.FOREIGN_TIMEOUT/time.Second), "time in seconds without keep-alive message from another device before keep-alive messages are send again")
+	kaInterval := flag.Int("kainterval", 0, "keep-alive interval in seconds, overrides the keep-alive profile")
+	labels := flag.String("labels", "", "warning/alarm bit label file")
+	staleTimeout := flag.Int("st", 30, "stale data timeout in seconds (BMS is offline if no message is received)")
 	v := flag.Bool("v", false, "version number")
 
 	flag.Parse()
@@ -52,6 +81,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *exportDbc == true {
+		if err := rs.LgResuDbc().WriteDbc(os.Stdout); err != nil {
+			log.Fatalf("lgresu_mon: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if *capacityReport == true {
+		// single battery (datafile root directory) or named batteries (datafile directory per battery)
+		configs := []batteryConfig{{"", *i}}
+		if len(*batteryList) != 0 {
+			var err error
+			if configs, err = parseBatteries(*batteryList); err != nil {
+				log.Fatalf("lgresu_mon: %v", err)
+			}
+		}
+		for _, cfg := range configs {
+			dataDir := filepath.Join(*dataDirRoot, cfg.name)
+			if len(cfg.name) != 0 {
+				fmt.Printf("battery %s:\n", cfg.name)
+			}
+			tracker := capacity.ReadTracker(filepath.Join(dataDir, capacity.CAPACITY_FILE_NAME), rs.NOMINAL_CAPACITY)
+			if err := tracker.Report().WriteReport(os.Stdout); err != nil {
+				log.Fatalf("lgresu_mon: %v", err)
+			}
+			fmt.Printf("\n")
+		}
+		os.Exit(0)
+	}
+
 	if len(*i) == 0 {
 		flag.Usage()
 		os.Exit(1)
@@ -71,65 +130,247 @@ func main() {
 		os.Exit(1)
 	}
 
-	iface, err := net.InterfaceByName(*i)
+	profile, err := rs.LookupProfile(*bms)
 
 	if err != nil {
-		log.Fatalf("lgresu_mon: Could not find network interface %s (%v)", *i, err)
+		log.Fatalf("lgresu_mon: %v", err)
+	}
+
+	// decode messages with the DBC message definitions (instead of the BMS profile)
+	var decoder rs.Decoder = profile
+	if len(*dbcFile) != 0 {
+		dbc, err := rs.LoadDbc(*dbcFile)
+		if err != nil {
+			log.Fatalf("lgresu_mon: Could not load DBC file %s (%v)", *dbcFile, err)
+		}
+		decoder = rs.NewDbcDecoder(dbc)
+	}
+
+	// relabel (unknown) warning/alarm bits
+	if len(*labels) != 0 {
+		if err := rs.LoadBitLabels(*labels); err != nil {
+			log.Fatalf("lgresu_mon: Could not load warning/alarm bit labels %s (%v)", *labels, err)
+		}
 	}
 
-	// bind to socket
-	conn, err := can.NewReadWriteCloserForInterface(iface)
+	// keep-alive message send to the BMS
+	var kaProfile keepalive.Profile
+	switch *ka {
+	case BMS_KEEP_ALIVE:
+		kaProfile = keepalive.FromBmsProfile(profile, time.Duration(keepAliveInterval)*time.Second)
+	case LEARN_KEEP_ALIVE:
+		kaProfile = keepalive.Profile{Name: keepalive.LEARNED_PROFILE, ID: rs.INV_KEEP_ALIVE}
+	default:
+		log.Fatalf("lgresu_mon: unknown keep-alive profile %q (expect %s or %s)", *ka, BMS_KEEP_ALIVE, LEARN_KEEP_ALIVE)
+	}
+	if *kaId != 0 {
+		kaProfile.ID = uint32(*kaId)
+	}
+	if len(*kaData) != 0 {
+		if kaProfile.Data, err = keepalive.ParsePayload(*kaData); err != nil {
+			log.Fatalf("lgresu_mon: %v", err)
+		}
+	}
+	if *kaInterval != 0 {
+		kaProfile.Interval = time.Duration(*kaInterval) * time.Second
+	}
 
+	// keep-alive policy (no keep-alive message on alarms, over-temperature, ...)
+	kaRules, err := keepalive.ParsePolicy(*kaPolicy)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("lgresu_mon: %v", err)
 	}
 
-	bus := can.NewBus(conn)
+	// single battery (-if) or named batteries (-batteries)
+	configs := []batteryConfig{{"", *i}}
+	if len(*batteryList) != 0 {
+		if configs, err = parseBatteries(*batteryList); err != nil {
+			log.Fatalf("lgresu_mon: %v", err)
+		}
+	}
+
+	if len(*bridgeIf) != 0 && len(configs) != 1 {
+		log.Fatalf("lgresu_mon: bridge mode (-bridge) supports a single battery")
+	}
 
 	// channel to receive os.Kill/SIGKILL(9) and os.Interrupt/SIGTERM(15) notifications
 	osSigChan := make(chan os.Signal)
-	// channel to terminate sendKeepAlive goroutine
+	// channel to terminate sendKeepAlive goroutines
 	termSigChan := make(chan bool)
+	// channel to receive CANBus errors (the CANBus connection is lost)
+	busErrChan := make(chan error)
 
-	// channel to signal request from WriteRecord to BrokerRecord
-	writeSigChan := make(chan bool)
+	router := mux.NewRouter().StrictSlash(true)
 
-	// channel to signal request from Index to BrokerRecord
-	httpSigChan := make(chan bool)
+	// persisted state of all batteries (saved before terminating)
+	savers := []SaverIf{}
 
-	// channel to receive data from BrokerRecord to WriteRecord
-	recordWriteChan := make(chan rs.LgResuStatus)
+	// openBus connects to a CANBus interface (network interface name or CANBus URL).
+	openBus := func(ifName string) *can.Bus {
+		conn, err := transport.Open(ifName)
 
-	// channel to receive data from BrokerRecord to Index
-	recordHttpChan := make(chan rs.LgResuStatus)
+		if err != nil {
+			log.Fatalf("lgresu_mon: %v", err)
+		}
 
-	recordEmitChan := make(chan rs.LgResuStatus)
+		return can.NewBus(conn)
+	}
 
-	signal.Notify(osSigChan, os.Interrupt)
-	signal.Notify(osSigChan, os.Kill)
+	// publish receives messages from a CANBus until the connection is lost.
+	publish := func(bus *can.Bus, ifName string) {
+		err := bus.ConnectAndPublish()
+		busErrChan <- fmt.Errorf("%s: %v", ifName, err)
+	}
 
-	// terminate sendKeepAlive and CANBus
-	go terminateMonitor(osSigChan, termSigChan, bus)
+	// bridge mode: Pylontech compatible messages are published on the bridge CANBus
+	var bridgeBus *can.Bus
+	if len(*bridgeIf) != 0 {
+		bridgeBus = openBus(*bridgeIf)
+	}
 
-	// send keep-alive message to LG Resu 10
-	go sendKeepAlive(termSigChan, bus, keepAliveInterval)
+	// startBattery connects to the CANBus interface of a battery and starts the goroutines to decode,
+	// record and publish its status. Datafiles are written to dataDir, HTTP handlers are registered
+	// with the path prefix.
+	startBattery := func(cfg batteryConfig, dataDir string, prefix string) (*battery, *can.Bus) {
+		bus := openBus(cfg.ifName)
 
-	// respond to record requests and receive new records
-	go brokerRecord(recordEmitChan, writeSigChan, recordWriteChan, httpSigChan, recordHttpChan)
+		b := &battery{name: cfg.name,
+			// channel to signal request from Index to BrokerRecord
+			httpSigChan: make(chan bool),
+			// channel to receive data from BrokerRecord to Index
+			recordHttpChan: make(chan rs.LgResuStatus)}
 
-	// receive update messages from LG Resu 10
-	bus.SubscribeFunc(decodeCanFrame(recordEmitChan))
-	go bus.ConnectAndPublish()
+		// channel to signal request from WriteRecord to BrokerRecord
+		writeSigChan := make(chan bool)
 
-	dr := dr.NewDatarecorder(*dataDirRoot, ".csv", *retentionPeriod, rs.CsvRecordHeader())
+		// channel to receive data from BrokerRecord to WriteRecord
+		recordWriteChan := make(chan rs.LgResuStatus)
 
-	// write record to datafile (60 second recordingFrequency)
-	go writeRecord(writeSigChan, recordWriteChan, dr, 60)
+		recordEmitChan := make(chan rs.LgResuStatus)
 
-	router := mux.NewRouter().StrictSlash(true)
+		// learn mode: replay the learned keep-alive message or learn it from the CANBus
+		kaChan := make(chan keepalive.Profile)
+		kaBattery := kaProfile
+		if *ka == LEARN_KEEP_ALIVE {
+			kaFileName := filepath.Join(dataDir, keepalive.KEEP_ALIVE_FILE_NAME)
+			learned, err := keepalive.LoadProfile(kaFileName)
+			switch {
+			case err == nil:
+				kaBattery = learned
+			case os.IsNotExist(err):
+				log.Infof("lgresu_mon: learning keep-alive message %#03x on %s\n", kaBattery.ID, cfg.ifName)
+				bus.SubscribeFunc(learnKeepAlive(keepalive.NewLearner(kaBattery.ID, keepalive.LEARN_FRAMES), kaChan, kaFileName))
+			default:
+				log.Fatalf("lgresu_mon: Could not load learned keep-alive message (%v)", err)
+			}
+		}
+		log.Infof("lgresu_mon: keep-alive profile %v\n", kaBattery)
+
+		// passive (no keep-alive message) while another device sends keep-alive messages
+		arbiter := keepalive.NewArbiter(kaBattery.ID, time.Duration(*kaTimeout)*time.Second)
+		bus.SubscribeFunc(watchKeepAlive(arbiter))
+
+		// no keep-alive message if the battery state violates the keep-alive policy
+		policy := keepalive.NewPolicy(kaRules)
+
+		// send keep-alive message to LG Resu 10
+		go sendKeepAlive(termSigChan, bus, kaBattery, kaChan, arbiter, policyGate{policy})
+
+		// respond to record requests and receive new records
+		go brokerRecord(recordEmitChan, writeSigChan, recordWriteChan, b.httpSigChan, b.recordHttpChan,
+			time.Duration(*staleTimeout)*time.Second)
+
+		// warning/alarm events (most recent events and event datafile)
+		eventLog := rs.NewEventLog(eventLogSize)
+		events := &eventRecorder{eventLog,
+			dr.NewDatarecorder(dataDir, ".log", *retentionPeriod, rs.EventCsvRecordHeader()), nil}
+
+		// discovery mode: warning/alarm bit changes and key metrics (discovery datafile)
+		if *discover {
+			events.discoveryRecorder = dr.NewDatarecorder(dataDir, ".bits", *retentionPeriod, rs.DiscoveryCsvRecordHeader())
+		}
+
+		// power and energy counters (persisted in the datafile directory)
+		energyMeter := em.NewEnergyMeter(filepath.Join(dataDir, em.ENERGY_FILE_NAME))
+
+		// time to empty/full (rolling average of the battery current)
+		estimator := tr.NewEstimator(rs.NOMINAL_CAPACITY, tr.AVERAGE_WINDOW)
+
+		// SOC estimated by coulomb counting (cross-check of the BMS SOC, persisted in the datafile directory)
+		socEstimator := se.NewEstimator(filepath.Join(dataDir, se.SOC_FILE_NAME), rs.NOMINAL_CAPACITY)
+
+		// equivalent full cycles, DoD histogram, charge/discharge sessions (persisted in the datafile directory)
+		cycleCounter := cc.NewCycleCounter(filepath.Join(dataDir, cc.CYCLE_FILE_NAME), rs.NOMINAL_CAPACITY)
+
+		// usable capacity estimated from discharge sessions (persisted in the datafile directory)
+		capacityTracker := capacity.NewTracker(filepath.Join(dataDir, capacity.CAPACITY_FILE_NAME), rs.NOMINAL_CAPACITY)
+		cycleCounter.OnSession(capacityTracker.AddSession)
+
+		savers = append(savers, energyMeter, socEstimator, cycleCounter, capacityTracker)
+
+		updaters := []StatusUpdaterIf{energyMeter, estimator, socEstimator, cycleCounter, policy}
+
+		// bridge mode: translate the status into Pylontech messages, relay the inverter keep-alive message
+		if bridgeBus != nil {
+			bridge := pylontech.NewBridge(time.Duration(*staleTimeout) * time.Second)
+			updaters = append(updaters, bridge)
+
+			bridgeBus.SubscribeFunc(relayKeepAlive(bus, kaBattery.ID, arbiter))
+			go publishBridge(termSigChan, bridgeBus, bridge, pylontech.PUBLISH_INTERVAL)
+		}
+
+		// receive update messages from LG Resu 10
+		bus.SubscribeFunc(decodeCanFrame(decoder, recordEmitChan, events, updaters...))
+		go publish(bus, cfg.ifName)
+
+		dataRecorder := dr.NewDatarecorder(dataDir, ".csv", *retentionPeriod, rs.CsvRecordHeader())
+
+		// write record to datafile (60 second recordingFrequency)
+		go writeRecord(writeSigChan, recordWriteChan, dataRecorder, 60)
+
+		router.HandleFunc(prefix+"/events", Events(eventLog))
+		router.HandleFunc(prefix+"/cycles", Cycles(cycleCounter))
+		router.HandleFunc(prefix+"/keepalive", KeepAlive(arbiter))
+		router.HandleFunc(prefix+"/keepalive/policy", KeepAlivePolicy(policy))
+		router.HandleFunc(prefix+"/capacity", Capacity(capacityTracker))
+		router.HandleFunc(prefix+"/analytics/daily", Analytics(dataDir, analytics.DAY))
+		router.HandleFunc(prefix+"/analytics/monthly", Analytics(dataDir, analytics.MONTH))
+
+		return b, bus
+	}
+
+	batteries := []*battery{}
+	buses := []CanbusIf{}
+
+	if len(configs) == 1 && len(configs[0].name) == 0 {
+		b, bus := startBattery(configs[0], *dataDirRoot, "/api")
+		batteries, buses = append(batteries, b), append(buses, bus)
+		router.HandleFunc("/", Index(b.httpSigChan, b.recordHttpChan))
+	} else {
+		for _, cfg := range configs {
+			// every battery has its own datafile directory
+			b, bus := startBattery(cfg, filepath.Join(*dataDirRoot, cfg.name), "/api/batteries/"+cfg.name)
+			batteries, buses = append(batteries, b), append(buses, bus)
+			router.HandleFunc("/api/batteries/"+cfg.name, Index(b.httpSigChan, b.recordHttpChan))
+		}
+		router.HandleFunc("/", Aggregate(batteries))
+		router.HandleFunc("/api/batteries", Batteries(batteries))
+	}
+
+	if bridgeBus != nil {
+		log.Infof("lgresu_mon: bridge mode, publishing Pylontech messages on %s\n", *bridgeIf)
+		buses = append(buses, bridgeBus)
+		go publish(bridgeBus, *bridgeIf)
+	}
+
+	signal.Notify(osSigChan, os.Interrupt)
+	signal.Notify(osSigChan, os.Kill)
+
+	// terminate sendKeepAlive and CANBus(es), save the persisted state (on SIGTERM or if a CANBus connection
+	// is lost)
+	go terminateMonitor(osSigChan, busErrChan, termSigChan, savers, buses...)
 
 	router.PathPrefix("/data").Handler(http.StripPrefix("/data", http.FileServer(http.Dir("data/"))))
-	router.HandleFunc("/", Index(httpSigChan, recordHttpChan))
 
 	log.Fatal(http.ListenAndServe(":"+*port, router))
 }
@@ -16,14 +16,24 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/brutella/can"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jens18/lgresu/analytics"
+	"github.com/jens18/lgresu/capacity"
+	cc "github.com/jens18/lgresu/cyclecounter"
+	"github.com/jens18/lgresu/keepalive"
 	rs "github.com/jens18/lgresu/lgresustatus"
+	"github.com/jens18/lgresu/pylontech"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -37,13 +47,32 @@ func (d *MockDatarecorder) WriteToDatafile(currentTime time.Time, record string)
 	d.Cnt++
 }
 
+type MockEventRecorder struct {
+	Events []rs.Event
+}
+
+func (er *MockEventRecorder) RecordEvent(e rs.Event, lgResu *rs.LgResuStatus) {
+	er.Events = append(er.Events, e)
+}
+
+type MockStatusUpdater struct {
+	Cnt int
+}
+
+func (u *MockStatusUpdater) Update(t time.Time, lgResu *rs.LgResuStatus) {
+	u.Cnt++
+	lgResu.Power = lgResu.Voltage * lgResu.Current
+}
+
 type MockCanbus struct {
 	PublishCnt    int
 	DisconnectCnt int
+	LastFrame     can.Frame
 }
 
-func (c *MockCanbus) Publish(can.Frame) error {
+func (c *MockCanbus) Publish(frm can.Frame) error {
 	c.PublishCnt++
+	c.LastFrame = frm
 	return nil
 }
 
@@ -62,7 +91,7 @@ var canbusTestMessages = []struct {
 		Identifier: rs.BMS_VOLT_AMP_TEMP,
 		Data:       [8]byte{0x4b, 0x15, 0xed, 0xff, 0xba, 0x00, 0x00, 0x00},
 	},
-	// ? (LG Resu -> Inverter): unknown message type (appears to be a constant)
+	// serial number (LG Resu -> Inverter): model, serial number, firmware/hardware revision (constant)
 	{
 		Identifier: rs.BMS_SERIAL_NUM,
 		Data:       [8]byte{0x04, 0xc0, 0x00, 0x1f, 0x03, 0x00, 0x00, 0x00},
@@ -132,6 +161,7 @@ func TestIndex(t *testing.T) {
 
 	// prepare lgResuStatus message
 	lgResu := &rs.LgResuStatus{Soc: 78, Soh: 99, Voltage: 54.55, Current: -1, Temp: 26.1}
+	lgResu.Received(rs.BMS_SOC_SOH, time.Now())
 
 	// channel to signal request from Index
 	httpSigChan := make(chan bool)
@@ -180,6 +210,91 @@ func TestIndex(t *testing.T) {
 	}
 }
 
+// TestIndexOffline tests that the HTTP request does not return the last known metrics of an offline BMS.
+func TestIndexOffline(t *testing.T) {
+
+	lgResu := rs.LgResuStatus{Soc: 78, Soh: 99, Voltage: 54.55, Current: -1, Temp: 26.1, Offline: true}
+
+	httpSigChan := make(chan bool)
+	recordHttpChan := make(chan rs.LgResuStatus)
+
+	go func() {
+		<-httpSigChan
+		recordHttpChan <- lgResu
+	}()
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Index(httpSigChan, recordHttpChan)).ServeHTTP(rr, req)
+
+	var status map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status["offline"] != true || status["soc"] != 0.0 || status["voltage"] != 0.0 ||
+		status["current"] != 0.0 || status["temp"] != 0.0 || status["soh"] != 99.0 {
+		t.Errorf("Index() handler returned %v for an offline BMS, expect offline without metrics \n", status)
+	}
+}
+
+func TestParseBatteries(t *testing.T) {
+	configs, err := parseBatteries("resu1=can0, resu2=can1")
+	expect := []batteryConfig{{"resu1", "can0"}, {"resu2", "can1"}}
+
+	if err != nil || !cmp.Equal(configs, expect, cmp.AllowUnexported(batteryConfig{})) {
+		t.Errorf("parseBatteries() == %+v, %v, expect %+v \n", configs, err, expect)
+	}
+
+	for _, s := range []string{"can0", "resu1=", "resu1=can0,resu1=can1", "../resu=can0"} {
+		if _, err := parseBatteries(s); err == nil {
+			t.Errorf("parseBatteries(%q) == nil, expect error \n", s)
+		}
+	}
+}
+
+// TestBatteries tests if the HTTP request returns the status of every battery and the aggregate status.
+func TestBatteries(t *testing.T) {
+
+	batteries := []*battery{}
+	for i, lgResu := range []rs.LgResuStatus{
+		{Soc: 80, Soh: 100, Voltage: 54, Current: -10},
+		{Soc: 60, Soh: 100, Voltage: 54, Current: -5}} {
+
+		b := &battery{fmt.Sprintf("resu%d", i+1), make(chan bool), make(chan rs.LgResuStatus)}
+		batteries = append(batteries, b)
+		lgResu.Received(rs.BMS_SOC_SOH, time.Now())
+
+		// simulate BrokerRecord, issue exactly one lgResu object
+		go func(lgResu rs.LgResuStatus) {
+			<-b.httpSigChan
+			b.recordHttpChan <- lgResu
+		}(lgResu)
+	}
+
+	req, err := http.NewRequest("GET", "/api/batteries", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Batteries(batteries)).ServeHTTP(rr, req)
+
+	var bs batteriesStatus
+	err = json.Unmarshal(rr.Body.Bytes(), &bs)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(bs.Batteries) != 2 || bs.Batteries["resu2"].Soc != 60 || bs.Aggregate.Soc != 70 || bs.Aggregate.Current != -15 {
+		t.Errorf("Batteries() handler returned %+v, expect resu1, resu2 and aggregate SOC 70 \n", bs)
+	}
+}
+
 // TestWriteRecord tests if a request for an lgResu object is made after 1 second.
 func TestWriteRecord(t *testing.T) {
 
@@ -225,8 +340,10 @@ func TestSendKeepAlive(t *testing.T) {
 
 	canbus := &MockCanbus{}
 
+	profile, _ := rs.LookupProfile(rs.LG_RESU_PROFILE)
+
 	// send keep-alive message to LG Resu 10
-	go sendKeepAlive(termSigChan, canbus, 1)
+	go sendKeepAlive(termSigChan, canbus, keepalive.FromBmsProfile(profile, time.Second), nil)
 
 	time.Sleep(3 * time.Second)
 
@@ -246,12 +363,192 @@ func TestSendKeepAlive(t *testing.T) {
 	}
 }
 
+// MockKeepAliveGate allows keep-alive messages if Allowed is true.
+type MockKeepAliveGate struct {
+	Allowed bool
+}
+
+func (g *MockKeepAliveGate) Allow(time.Time) bool {
+	return g.Allowed
+}
+
+// TestSendKeepAliveGates tests that keep-alive messages are only send if all gates allow them.
+func TestSendKeepAliveGates(t *testing.T) {
+
+	termSigChan := make(chan bool)
+	defer close(termSigChan)
+
+	canbus := &MockCanbus{}
+	profile := keepalive.Profile{ID: rs.INV_KEEP_ALIVE, Data: make([]byte, 8), Interval: 200 * time.Millisecond}
+
+	go sendKeepAlive(termSigChan, canbus, profile, nil, &MockKeepAliveGate{true}, &MockKeepAliveGate{false})
+
+	time.Sleep(500 * time.Millisecond)
+
+	if canbus.PublishCnt != 0 {
+		t.Errorf("sendKeepAlive() generated %d keep alive messages, expect 0 messages \n", canbus.PublishCnt)
+	}
+}
+
+// TestWatchKeepAlive tests if foreign keep-alive messages switch the arbiter to passive.
+func TestWatchKeepAlive(t *testing.T) {
+
+	arbiter := keepalive.NewArbiter(rs.INV_KEEP_ALIVE, time.Minute)
+	watchKeepAlive(arbiter)(can.Frame{ID: rs.INV_KEEP_ALIVE, Length: 8})
+
+	req, err := http.NewRequest("GET", "/api/keepalive", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(KeepAlive(arbiter)).ServeHTTP(rr, req)
+
+	var status keepalive.ArbiterStatus
+	err = json.Unmarshal(rr.Body.Bytes(), &status)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if status.Role != keepalive.ROLE_PASSIVE || status.ForeignMessages != 1 {
+		t.Errorf("KeepAlive() handler returned %+v, expect passive role \n", status)
+	}
+}
+
+// TestRelayKeepAlive tests that only the inverter keep-alive message is relayed to the BMS CANBus.
+func TestRelayKeepAlive(t *testing.T) {
+
+	canbus := &MockCanbus{}
+	arbiter := keepalive.NewArbiter(rs.INV_KEEP_ALIVE, time.Minute)
+
+	relay := relayKeepAlive(canbus, rs.INV_KEEP_ALIVE, arbiter)
+	relay(can.Frame{ID: rs.INV_KEEP_ALIVE, Length: 8})
+	relay(can.Frame{ID: rs.BMS_SOC_SOH, Length: 8})
+
+	if canbus.PublishCnt != 1 {
+		t.Errorf("relayKeepAlive() published %d messages, expect 1 \n", canbus.PublishCnt)
+	}
+
+	// invalid length (for example: received with socketcand or slcan) is limited to 8 bytes
+	relay(can.Frame{ID: rs.INV_KEEP_ALIVE, Length: 15})
+	if canbus.PublishCnt != 2 || canbus.LastFrame.Length != 8 {
+		t.Errorf("relayKeepAlive() published %d messages, last frame %+v, expect 2, length 8 \n",
+			canbus.PublishCnt, canbus.LastFrame)
+	}
+
+	// no keep-alive message is send while the inverter keep-alive message is relayed
+	if arbiter.Allow(time.Now()) {
+		t.Errorf("relayKeepAlive() arbiter.Allow() == true, expect false \n")
+	}
+}
+
+// TestPublishBridge tests that the Pylontech messages are published once the BMS status is known.
+func TestPublishBridge(t *testing.T) {
+
+	canbus := &MockCanbus{}
+	bridge := pylontech.NewBridge(time.Minute)
+	c := make(chan bool)
+
+	go publishBridge(c, canbus, bridge, 10*time.Millisecond)
+
+	// no BMS status
+	time.Sleep(25 * time.Millisecond)
+	if canbus.PublishCnt != 0 {
+		t.Errorf("publishBridge() published %d messages without BMS status, expect 0 \n", canbus.PublishCnt)
+	}
+
+	bridge.Update(time.Now(), &rs.LgResuStatus{Soc: 77, Soh: 99, Voltage: 54.51,
+		ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_SOH | rs.UPDATE_VOLTAGE})
+	time.Sleep(25 * time.Millisecond)
+	close(c)
+	time.Sleep(15 * time.Millisecond)
+
+	// 0x351, 0x355, 0x356, 0x359, 0x35C, 0x35E every interval
+	if canbus.PublishCnt == 0 || canbus.PublishCnt%6 != 0 {
+		t.Errorf("publishBridge() published %d messages, expect a multiple of 6 \n", canbus.PublishCnt)
+	}
+}
+
+// TestKeepAlivePolicy tests the manual override of the keep-alive policy.
+func TestKeepAlivePolicy(t *testing.T) {
+
+	rules, _ := keepalive.ParsePolicy("soc<10:hold")
+	policy := keepalive.NewPolicy(rules)
+	policy.Update(time.Now(), &rs.LgResuStatus{Soc: 5, Soh: 99, ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_SOH})
+
+	req, err := http.NewRequest("POST", "/api/keepalive/policy", strings.NewReader("override=on"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(KeepAlivePolicy(policy)).ServeHTTP(rr, req)
+
+	var status keepalive.PolicyStatus
+	err = json.Unmarshal(rr.Body.Bytes(), &status)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if status.State != keepalive.STATE_HELD || status.Override != keepalive.OVERRIDE_ON || !status.Allowed {
+		t.Errorf("KeepAlivePolicy() handler returned %+v, expect held, override on \n", status)
+	}
+
+	// invalid override
+	req, _ = http.NewRequest("POST", "/api/keepalive/policy?override=maybe", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(KeepAlivePolicy(policy)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("KeepAlivePolicy() handler returned status code %v for an invalid override, expect %v \n",
+			rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestLearnKeepAlive tests if a learned keep-alive message is saved and replayed.
+func TestLearnKeepAlive(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "lgresu_mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	termSigChan := make(chan bool)
+	defer close(termSigChan)
+
+	kaChan := make(chan keepalive.Profile)
+	canbus := &MockCanbus{}
+	fileName := filepath.Join(dir, keepalive.KEEP_ALIVE_FILE_NAME)
+
+	// no keep-alive message until the keep-alive message is learned
+	go sendKeepAlive(termSigChan, canbus, keepalive.Profile{ID: rs.INV_KEEP_ALIVE}, kaChan)
+
+	learn := learnKeepAlive(keepalive.NewLearner(rs.INV_KEEP_ALIVE, 2), kaChan, fileName)
+	learn(can.Frame{ID: rs.INV_KEEP_ALIVE, Length: 2, Data: [8]uint8{0x01, 0x02}})
+	time.Sleep(500 * time.Millisecond)
+	learn(can.Frame{ID: rs.INV_KEEP_ALIVE, Length: 2, Data: [8]uint8{0x01, 0x02}})
+
+	if p, err := keepalive.LoadProfile(fileName); err != nil || !cmp.Equal(p.Data, []byte{0x01, 0x02}) {
+		t.Errorf("learnKeepAlive() saved %v, %v, expect payload 01 02 \n", p, err)
+	}
+
+	time.Sleep(1200 * time.Millisecond)
+
+	if canbus.PublishCnt != 2 {
+		t.Errorf("sendKeepAlive() replayed %d keep alive messages, expect %d messages \n", canbus.PublishCnt, 2)
+	}
+}
+
 // TestDecodeCanFrame tests decoding of a CanBus frame in a closure.
 func TestDecodeCanFrame(t *testing.T) {
 
 	recordEmitChan := make(chan rs.LgResuStatus)
 
-	decoder := decodeCanFrame(recordEmitChan)
+	profile, _ := rs.LookupProfile(rs.LG_RESU_PROFILE)
+
+	decoder := decodeCanFrame(profile, recordEmitChan, &MockEventRecorder{})
 
 	frm := can.Frame{
 		ID:     rs.BMS_SOC_SOH,
@@ -274,6 +571,282 @@ func TestDecodeCanFrame(t *testing.T) {
 	}
 }
 
+// TestDecodeCanFrameCountsErrors tests that invalid CanBus frames are counted and ignored.
+func TestDecodeCanFrameCountsErrors(t *testing.T) {
+
+	recordEmitChan := make(chan rs.LgResuStatus)
+
+	profile, _ := rs.LookupProfile(rs.LG_RESU_PROFILE)
+
+	decoder := decodeCanFrame(profile, recordEmitChan, &MockEventRecorder{})
+
+	frms := []can.Frame{
+		// truncated state of charge/health
+		{ID: rs.BMS_SOC_SOH, Length: 2, Data: [8]byte{0x4d, 0x00, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		// unknown message id
+		{ID: 0x123, Length: 8},
+		// valid state of charge/health
+		{ID: rs.BMS_SOC_SOH, Length: 8, Data: [8]byte{0x4d, 0x00, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+
+	lgResu := rs.LgResuStatus{}
+
+	for _, frm := range frms {
+		go decoder(frm)
+		lgResu = <-recordEmitChan
+	}
+
+	if lgResu.Soc != 77 || lgResu.DecodeErrors != 1 || lgResu.UnknownMessages != 1 {
+		t.Errorf("decodeCanFrame() produce Soc = %d, DecodeErrors = %d, UnknownMessages = %d, expect 77, 1, 1 \n",
+			lgResu.Soc, lgResu.DecodeErrors, lgResu.UnknownMessages)
+	}
+}
+
+// TestDecodeCanFrameTracksMessages tests that valid BMS messages (but not keep-alive messages) are counted.
+func TestDecodeCanFrameTracksMessages(t *testing.T) {
+
+	recordEmitChan := make(chan rs.LgResuStatus)
+
+	profile, _ := rs.LookupProfile(rs.LG_RESU_PROFILE)
+
+	updater := &MockStatusUpdater{}
+
+	decoder := decodeCanFrame(profile, recordEmitChan, &MockEventRecorder{}, updater)
+
+	frms := []can.Frame{
+		{ID: rs.BMS_SOC_SOH, Length: 8, Data: [8]byte{0x4d, 0x00, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{ID: rs.INV_KEEP_ALIVE, Length: 8},
+		{ID: rs.BMS_SOC_SOH, Length: 8, Data: [8]byte{0x4d, 0x00, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+
+	lgResu := rs.LgResuStatus{}
+
+	for _, frm := range frms {
+		go decoder(frm)
+		lgResu = <-recordEmitChan
+	}
+
+	if len(lgResu.Messages) != 1 || lgResu.Messages["0x355"].Count != 2 {
+		t.Errorf("decodeCanFrame() produce Messages = %+v, expect 0x355 (2 messages) \n", lgResu.Messages)
+	}
+
+	// derived metrics are computed for every valid BMS message
+	if updater.Cnt != 2 {
+		t.Errorf("decodeCanFrame() called updater %d times, expect 2 \n", updater.Cnt)
+	}
+}
+
+// TestDecodeCanFrameRequests tests that charge/discharge requests and the manufacturer name of other
+// CANBus nodes are decoded.
+func TestDecodeCanFrameRequests(t *testing.T) {
+
+	recordEmitChan := make(chan rs.LgResuStatus)
+
+	profile, _ := rs.LookupProfile(rs.LG_RESU_PROFILE)
+
+	decoder := decodeCanFrame(profile, recordEmitChan, &MockEventRecorder{})
+
+	frms := []can.Frame{
+		{ID: rs.BMS_REQUEST, Length: 2, Data: [8]byte{0xc0, 0x00}},
+		{ID: rs.BMS_MANUFACTURER, Length: 8, Data: [8]byte{'P', 'Y', 'L', 'O', 'N', ' ', ' ', ' '}},
+	}
+
+	lgResu := rs.LgResuStatus{}
+
+	for _, frm := range frms {
+		go decoder(frm)
+		lgResu = <-recordEmitChan
+	}
+
+	if !cmp.Equal(lgResu.Requests, []string{"CHARGE_ENABLE", "DISCHARGE_ENABLE"}) || lgResu.Manufacturer != "PYLON" {
+		t.Errorf("decodeCanFrame() produce Requests = %v, Manufacturer = %q, expect [CHARGE_ENABLE DISCHARGE_ENABLE], \"PYLON\" \n",
+			lgResu.Requests, lgResu.Manufacturer)
+	}
+
+	// messages of other CANBus nodes do not indicate that the BMS is online
+	if len(lgResu.Messages) != 0 {
+		t.Errorf("decodeCanFrame() produce Messages = %+v, expect no messages \n", lgResu.Messages)
+	}
+}
+
+// TestDecodeCanFrameRecordsEvents tests that warning transitions are recorded as events.
+func TestDecodeCanFrameRecordsEvents(t *testing.T) {
+
+	recordEmitChan := make(chan rs.LgResuStatus)
+
+	profile, _ := rs.LookupProfile(rs.LG_RESU_PROFILE)
+
+	eventRecorder := &MockEventRecorder{}
+
+	decoder := decodeCanFrame(profile, recordEmitChan, eventRecorder)
+
+	// BATTERY_HIGH_TEMP warning raised, repeated and cleared
+	for _, data := range [][8]byte{
+		{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	} {
+		go decoder(can.Frame{ID: rs.BMS_WARN_ALARM, Length: 8, Data: data})
+		<-recordEmitChan
+	}
+
+	if len(eventRecorder.Events) != 2 ||
+		eventRecorder.Events[0].State != rs.EVENT_RAISED || eventRecorder.Events[1].State != rs.EVENT_CLEARED {
+		t.Errorf("decodeCanFrame() recorded events %+v, expect BATTERY_HIGH_TEMP raised and cleared \n",
+			eventRecorder.Events)
+	}
+}
+
+// TestEventRecorderDiscovery tests that events are written to the discovery datafile in discovery mode only.
+func TestEventRecorderDiscovery(t *testing.T) {
+
+	lgResu := &rs.LgResuStatus{Soc: 77, Voltage: 54.51, Current: -1.9, Temp: 18.6}
+	e := rs.Event{Time: time.Now(), Type: rs.EVENT_ALARM, Description: "UNKNOWN_aa0", State: rs.EVENT_RAISED}
+
+	events := &MockDatarecorder{}
+	er := &eventRecorder{rs.NewEventLog(10), events, nil}
+	er.RecordEvent(e, lgResu)
+
+	discovery := &MockDatarecorder{}
+	er.discoveryRecorder = discovery
+	er.RecordEvent(e, lgResu)
+
+	if events.Cnt != 2 || discovery.Cnt != 1 {
+		t.Errorf("RecordEvent() wrote %d event records, %d discovery records, expect 2, 1 \n",
+			events.Cnt, discovery.Cnt)
+	}
+}
+
+// TestEvents tests if the HTTP request returns the recorded events as JSON.
+func TestEvents(t *testing.T) {
+
+	eventLog := rs.NewEventLog(10)
+	eventLog.Add(rs.Event{Time: time.Now(), Type: rs.EVENT_WARNING, Description: "BATTERY_HIGH_TEMP", State: rs.EVENT_RAISED})
+
+	req, err := http.NewRequest("GET", "/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Events(eventLog)).ServeHTTP(rr, req)
+
+	var events []rs.Event
+	err = json.Unmarshal(rr.Body.Bytes(), &events)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(events) != 1 || events[0].Description != "BATTERY_HIGH_TEMP" {
+		t.Errorf("Events() handler returned %+v, expect BATTERY_HIGH_TEMP event \n", events)
+	}
+}
+
+// TestCycles tests if the HTTP request returns the cycle history as JSON.
+func TestCycles(t *testing.T) {
+
+	cycleCounter := cc.NewCycleCounter("", rs.NOMINAL_CAPACITY)
+	cycleCounter.Update(time.Now(), &rs.LgResuStatus{Soc: 80, Soh: 99, Current: -10, ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_CURRENT})
+
+	req, err := http.NewRequest("GET", "/api/cycles", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Cycles(cycleCounter)).ServeHTTP(rr, req)
+
+	var history cc.History
+	err = json.Unmarshal(rr.Body.Bytes(), &history)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if history.Active == nil || history.Active.Type != cc.SESSION_DISCHARGE {
+		t.Errorf("Cycles() handler returned %+v, expect active discharge session \n", history)
+	}
+}
+
+func TestAnalytics(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "lgresu_mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 1 hour charge with 500W
+	csvData := rs.CsvRecordHeader()
+	now := time.Date(2018, time.May, 31, 10, 0, 0, 0, time.Local)
+	for i := 0; i <= 60; i++ {
+		lgResu := rs.LgResuStatus{Soc: 50, Voltage: 50, Current: 10, Temp: 21}
+		csvData += lgResu.CsvRecord(now.Add(time.Duration(i) * time.Minute))
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "20180531.csv"), []byte(csvData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "/api/analytics/daily?format=csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Analytics(dir, analytics.DAY)).ServeHTTP(rr, req)
+
+	expect := analytics.CsvHeader() + "2018-05-31,0.50,0.00,0.0,50,50,21.0\n"
+	if rr.Body.String() != expect {
+		t.Errorf("Analytics() handler returned %q, expect %q \n", rr.Body.String(), expect)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+
+	tracker := capacity.NewTracker("", rs.NOMINAL_CAPACITY)
+	tracker.AddSession(cc.Session{Type: cc.SESSION_DISCHARGE, End: time.Now(), StartSoc: 100, EndSoc: 50, Ah: 90, Wh: 4500, Soh: 99})
+
+	req, err := http.NewRequest("GET", "/api/capacity", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Capacity(tracker)).ServeHTTP(rr, req)
+
+	var report capacity.Report
+	err = json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if report.Sessions != 1 || report.CapacityAh != 180 || report.ReportedSoh != 99 {
+		t.Errorf("Capacity() handler returned %+v, expect capacity 180 Ah from 1 session \n", report)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "lgresu_mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// counters are saved at most every 60 seconds during updates
+	fileName := filepath.Join(dir, cc.CYCLE_FILE_NAME)
+	cycleCounter := cc.NewCycleCounter(fileName, rs.NOMINAL_CAPACITY)
+	now := time.Now()
+	cycleCounter.Update(now, &rs.LgResuStatus{Soc: 80, Soh: 99, Current: -10, ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_CURRENT})
+	cycleCounter.Update(now.Add(10*time.Second), &rs.LgResuStatus{Soc: 80, Soh: 99, Current: -10, ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_CURRENT})
+
+	saveState([]SaverIf{cycleCounter})
+
+	history := cc.NewCycleCounter(fileName, rs.NOMINAL_CAPACITY).History()
+	if !history.Time.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("saveState() saved history of %v, expect %v \n", history.Time, now.Add(10*time.Second))
+	}
+}
+
 //
 func TestBrokerRecord(t *testing.T) {
 
@@ -291,10 +864,11 @@ func TestBrokerRecord(t *testing.T) {
 
 	recordEmitChan := make(chan rs.LgResuStatus)
 
-	go brokerRecord(recordEmitChan, writeSigChan, recordWriteChan, httpSigChan, recordHttpChan)
+	go brokerRecord(recordEmitChan, writeSigChan, recordWriteChan, httpSigChan, recordHttpChan, 10*time.Second)
 
 	// prepare lgResuStatus message
 	lgResu := &rs.LgResuStatus{Soc: 78, Soh: 99, Voltage: 54.55, Current: -1, Temp: 26.1}
+	lgResu.Received(rs.BMS_SOC_SOH, time.Now())
 
 	recordEmitChan <- *lgResu
 
@@ -312,6 +886,21 @@ func TestBrokerRecord(t *testing.T) {
 		t.Errorf("brokerRecord() produce Soc = %d, expect Soc = 78 \n",
 			lgResuWrite.Soc)
 	}
+
+	if lgResuWrite.Offline || lgResuHttp.Offline {
+		t.Errorf("brokerRecord() produce Offline = true, expect Offline = false \n")
+	}
+
+	// no message received within the stale timeout
+	lgResu.Received(rs.BMS_SOC_SOH, time.Now().Add(-time.Minute))
+	recordEmitChan <- *lgResu
+
+	httpSigChan <- true
+	lgResuHttp = <-recordHttpChan
+
+	if !lgResuHttp.Offline || !lgResuHttp.Messages["0x355"].Stale {
+		t.Errorf("brokerRecord() produce Offline = false, expect Offline = true \n")
+	}
 }
 
 // TestIntegration tests if the HTTP request returns a JSON object.
@@ -319,7 +908,7 @@ func TestIntegration(t *testing.T) {
 
 	// start lgresu_mon server, combine stdout and stderr
 	cmd := exec.Command("sh", "-c",
-		"go run lgresu_mon.go lgresu_actors.go -if vcan0 -d debug -p 9090 -dr data -r 7 > lg_resu_mon.log 2>&1")
+		"go run lgresu_mon.go lgresu_actors.go -if vcan0 -bms lgresu -d debug -p 9090 -dr data -r 7 -st 30 > lg_resu_mon.log 2>&1")
 	// https://medium.com/@felixge/killing-a-child-process-and-all-of-its-children-in-go-54079af94773
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
@@ -4,48 +4,110 @@ import (
 	"flag"
 	"fmt"
 	"github.com/brutella/can"
+	aes "github.com/jens18/lgresu/discoveraes"
 	rs "github.com/jens18/lgresu/lgresustatus"
+	ltm "github.com/jens18/lgresu/lithiumate"
+	"github.com/jens18/lgresu/transport"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"time"
 )
 
-// canbusTestMessages contains test messages generated by the LG Resu 10 LV battery BMS
-var canbusTestMessages = []struct {
+// canbusTestMessage contains a single test message generated by a battery BMS
+type canbusTestMessage struct {
 	Identifier uint32
 	Data       [8]byte
-}{
-	// volt/amp/temp (LG Resu -> Inverter):
+}
+
+// lgResuTestStatus contains the simulated state of the LG Resu 10 LV battery BMS
+var lgResuTestStatus = rs.LgResuStatus{
+	Soc: 77, Soh: 99, Voltage: 54.51, Current: -1.9, Temp: 18.6,
+	MaxVoltage: 57.70, MaxChargeCurrent: 91.80, MaxDischargeCurrent: 91.80,
+	Model: "RESU_10_LV", SerialNum: 192, FirmwareVersion: "3.1", HardwareVersion: 3,
+	Requests: []string{"CHARGE_ENABLE", "DISCHARGE_ENABLE"}, Manufacturer: "LG",
+}
+
+// lgResuTestMessages returns the messages with the given id's generated by the LG Resu 10 LV battery BMS for lgResu
+func lgResuTestMessages(lgResu *rs.LgResuStatus, ids []uint32) []canbusTestMessage {
+	messages := []canbusTestMessage{}
+
+	for _, id := range ids {
+		tm := canbusTestMessage{Identifier: id}
+		copy(tm.Data[:], lgResu.EncodeLgResuCanbusMessage(id))
+		messages = append(messages, tm)
+	}
+	return messages
+}
+
+// discoverTestMessages contains test messages generated by the Discover AES battery BMS
+var discoverTestMessages = []canbusTestMessage{
+	// volt/amp/temp (Discover AES -> Inverter):
+	{
+		Identifier: aes.AES_VOLT_AMP_TEMP,
+		Data:       [8]byte{0x6e, 0x14, 0x83, 0xff, 0xd7, 0x00, 0x00, 0x00},
+	},
+	// charge/discharge limits (Discover AES -> Inverter):
+	{
+		Identifier: aes.AES_LIMITS,
+		Data:       [8]byte{0x30, 0x02, 0xe8, 0x03, 0xe8, 0x03, 0xa4, 0x01},
+	},
+	// state of charge/health (Discover AES -> Inverter):
+	{
+		Identifier: aes.AES_SOC_SOH,
+		Data:       [8]byte{0x55, 0x00, 0x64, 0x00, 0x48, 0x21, 0x00, 0x00},
+	},
+	// alarms/warnings (Discover AES -> Inverter): all alarms/warnings inactive
 	{
-		Identifier: rs.BMS_VOLT_AMP_TEMP,
-		Data:       [8]byte{0x4b, 0x15, 0xed, 0xff, 0xba, 0x00, 0x00, 0x00},
+		Identifier: aes.AES_FAULTS,
+		Data:       [8]byte{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa},
 	},
-	// ? (LG Resu -> Inverter): unknown message type (appears to be a constant)
+}
+
+// lithiumateTestMessages contains test messages generated by the Elithion Lithiumate BMS
+var lithiumateTestMessages = []canbusTestMessage{
+	// voltage (Lithiumate -> Inverter):
+	{
+		Identifier: ltm.LTM_VOLTAGE,
+		Data:       [8]byte{0x00, 0x34, 0x20, 0x05, 0x22, 0x0c, 0x00, 0x00},
+	},
+	// current (Lithiumate -> Inverter):
 	{
-		Identifier: rs.BMS_SERIAL_NUM,
-		Data:       [8]byte{0x04, 0xc0, 0x00, 0x1f, 0x03, 0x00, 0x00, 0x00},
+		Identifier: ltm.LTM_CURRENT,
+		Data:       [8]byte{0x00, 0x0f, 0x00, 0x64, 0x00, 0x96, 0x00, 0x00},
 	},
-	// configuration parameters (LG Resu -> Inverter):
+	// state of charge (Lithiumate -> Inverter):
 	{
-		Identifier: rs.BMS_LIMITS,
-		Data:       [8]byte{0x41, 0x02, 0x96, 0x03, 0x96, 0x03, 0x00, 0x00},
+		Identifier: ltm.LTM_SOC,
+		Data:       [8]byte{0x40, 0x00, 0x24, 0x00, 0x64, 0x00, 0x61, 0x00},
 	},
-	// state of charge/health (LG Resu -> Inverter):
+	// temperature (Lithiumate -> Inverter):
 	{
-		Identifier: rs.BMS_SOC_SOH,
-		Data:       [8]byte{0x4d, 0x00, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00},
+		Identifier: ltm.LTM_TEMPERATURE,
+		Data:       [8]byte{0xfb, 0x00, 0xf9, 0x02, 0xfd, 0x09, 0x00, 0x00},
 	},
-	// warnings/alarms (LG Resu -> Inverter):
+	// state (Lithiumate -> Inverter): no faults/warnings
 	{
-		Identifier: rs.BMS_WARN_ALARM,
+		Identifier: ltm.LTM_STATE,
 		Data:       [8]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 	},
 }
 
+// simulatorMessages contains the test messages for every simulated BMS profile
+var simulatorMessages = map[string][]canbusTestMessage{
+	rs.LG_RESU_PROFILE:     lgResuTestMessages(&lgResuTestStatus, rs.LgResuMessageIds),
+	aes.DISCOVER_PROFILE:   discoverTestMessages,
+	ltm.LITHIUMATE_PROFILE: lithiumateTestMessages,
+}
+
 // default value is the virtual CANBus interface: vcan0
-var i = flag.String("if", "vcan0", "network interface name")
+var i = flag.String("if", "vcan0", "network interface name or CANBus URL (example: socketcan://can0, socketcand://host:29536/can0, slcan:///dev/ttyACM0, cannelloni://host:20000, loopback: cannelloni://127.0.0.1:20001?local=127.0.0.1:20000)")
+
+// default value is the LG Resu 10 LV
+var bms = flag.String("bms", rs.LG_RESU_PROFILE, "simulated BMS profile: "+rs.LG_RESU_PROFILE+", "+aes.DISCOVER_PROFILE+", "+ltm.LITHIUMATE_PROFILE)
+
+// emulate a complete battery toward a real inverter (LG Resu 10 LV messages and 0x35C/0x35E messages)
+var emulate = flag.Bool("emulate", false, "emulate a complete battery: send charge/discharge requests (0x35C) and manufacturer (0x35E) messages ("+rs.LG_RESU_PROFILE+" only)")
 
 func main() {
 
@@ -57,17 +119,24 @@ func main() {
 		os.Exit(1)
 	}
 
-	iface, err := net.InterfaceByName(*i)
+	testMessages, ok := simulatorMessages[*bms]
+	if !ok {
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	if err != nil {
-		log.Fatalf("lgresu_sim: Could not find network interface %s (%v)", *i, err)
+	if *emulate {
+		if *bms != rs.LG_RESU_PROFILE {
+			flag.Usage()
+			os.Exit(1)
+		}
+		testMessages = lgResuTestMessages(&lgResuTestStatus, rs.BatteryMessageIds)
 	}
 
-	// bind to socket
-	conn, err := can.NewReadWriteCloserForInterface(iface)
+	conn, err := transport.Open(*i)
 
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("lgresu_sim: %v", err)
 	}
 
 	bus := can.NewBus(conn)
@@ -87,8 +156,8 @@ func main() {
 	f := can.Frame{}
 
 	for {
-		// send all LG Resu 10 test messages in one block
-		for _, tm := range canbusTestMessages {
+		// send all test messages of the simulated BMS in one block
+		for _, tm := range testMessages {
 
 			f.ID = tm.Identifier
 			f.Length = uint8(len(tm.Data))
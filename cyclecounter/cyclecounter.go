@@ -0,0 +1,329 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cyclecounter tracks the battery wear: equivalent full cycles,
+// a depth of discharge (DoD) histogram and the history of charge/discharge
+// sessions.
+//
+// Equivalent full cycles are the total discharged capacity (Ah) divided by
+// the nominal capacity. A session is a period in which the battery is
+// continuously charged or discharged (current above SESSION_CURRENT). A
+// session ends if the battery is idle for SESSION_IDLE_TIME or if the
+// current direction is reversed for at least SESSION_SWITCH_TIME (short
+// load spikes do not end a session). Sessions with a SOC change below
+// MIN_SESSION_SOC are not recorded.
+//
+// The DoD histogram counts discharge sessions in 10% buckets: bucket 0
+// contains sessions with a DoD of 0-9%, bucket 9 sessions with a DoD of 90-100%.
+//
+// The cycle history is persisted in a JSON file and survives restarts of lgresu_mon.
+package cyclecounter
+
+import (
+	"encoding/json"
+	dr "github.com/jens18/lgresu/datarecorder"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// filename of the persisted cycle history (in the datafile root directory)
+	CYCLE_FILE_NAME string = "cycles.json"
+	// session types
+	SESSION_CHARGE    string = "charge"
+	SESSION_DISCHARGE string = "discharge"
+	// the battery is charged/discharged if the absolute value of the current is above SESSION_CURRENT (A)
+	SESSION_CURRENT float32 = 1
+	// a session ends after SESSION_IDLE_TIME without charge/discharge current
+	SESSION_IDLE_TIME time.Duration = 15 * time.Minute
+	// a session ends if the current direction is reversed for SESSION_SWITCH_TIME
+	SESSION_SWITCH_TIME time.Duration = 1 * time.Minute
+	// sessions with a smaller SOC change (%) are not recorded
+	MIN_SESSION_SOC uint16 = 1
+	// maximal number of sessions in the history (oldest sessions are removed)
+	MAX_SESSIONS int = 1000
+	// longer intervals between two updates are not integrated
+	MAX_INTEGRATION_INTERVAL time.Duration = 30 * time.Second
+	// interval between two saves of the cycle history
+	SAVE_INTERVAL time.Duration = 60 * time.Second
+)
+
+// Session contains a single charge or discharge session.
+type Session struct {
+	Type     string    `json:"type"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	StartSoc uint16    `json:"startSoc"`
+	EndSoc   uint16    `json:"endSoc"`
+	// charged/discharged capacity in Ah
+	Ah float64 `json:"ah"`
+	// charged/discharged energy in Wh
+	Wh float64 `json:"wh"`
+	// SOH reported by the BMS at the end of the session
+	Soh uint16 `json:"soh"`
+}
+
+// History contains the cycle history.
+type History struct {
+	// time of the last update
+	Time             time.Time `json:"time"`
+	ChargedAh        float64   `json:"chargedAh"`
+	DischargedAh     float64   `json:"dischargedAh"`
+	EquivalentCycles float64   `json:"equivalentCycles"`
+	// number of discharge sessions per 10% DoD bucket
+	DodHistogram [10]uint32 `json:"dodHistogram"`
+	// completed sessions (oldest session first)
+	Sessions []Session `json:"sessions"`
+	// active session (nil if the battery is idle)
+	Active *Session `json:"active"`
+}
+
+// CycleCounter tracks the cycle history. CycleCounter can be used concurrently.
+type CycleCounter struct {
+	mu       sync.Mutex
+	fileName string
+	capacity float32
+	history  History
+	// start time, SOC, capacity (Ah) and energy (Wh) of a reversed current direction
+	switchTime time.Time
+	switchSoc  uint16
+	switchAh   float64
+	switchWh   float64
+	// time of the last charge/discharge current of the active session
+	lastActive time.Time
+	current    float32
+	power      float32
+	saved      time.Time
+	// functions called for every completed session
+	sessionHandlers []func(Session)
+}
+
+// NewCycleCounter is the constructor for CycleCounter. fileName is the JSON file used to persist the
+// cycle history (no persistence if fileName is empty), capacity is the nominal battery capacity in Ah.
+func NewCycleCounter(fileName string, capacity float32) *CycleCounter {
+	cc := &CycleCounter{fileName: fileName, capacity: capacity}
+
+	if len(fileName) == 0 {
+		return cc
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		log.Infof("NewCycleCounter: %s does not exist, new cycle history\n", fileName)
+		return cc
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &cc.history)
+	}
+	if err != nil {
+		log.Warnf("NewCycleCounter: could not read %s (%v), new cycle history\n", fileName, err)
+		cc.history = History{}
+	}
+	if cc.history.Active != nil {
+		cc.lastActive = cc.history.Active.End
+	}
+	return cc
+}
+
+// direction returns the session type for current (empty if the battery is idle).
+func direction(current float32) string {
+	switch {
+	case current >= SESSION_CURRENT:
+		return SESSION_CHARGE
+	case current <= -SESSION_CURRENT:
+		return SESSION_DISCHARGE
+	}
+	return ""
+}
+
+// resetSwitch forgets a reversed current direction.
+func (cc *CycleCounter) resetSwitch() {
+	cc.switchTime = time.Time{}
+	cc.switchAh, cc.switchWh = 0, 0
+}
+
+// closeSession adds the active session to the history.
+func (cc *CycleCounter) closeSession() {
+	s := cc.history.Active
+	cc.history.Active = nil
+	cc.resetSwitch()
+
+	dSoc := int(s.EndSoc) - int(s.StartSoc)
+	if dSoc < 0 {
+		dSoc = -dSoc
+	}
+	if dSoc < int(MIN_SESSION_SOC) {
+		log.Debugf("CycleCounter: %s session with SOC change %d %% ignored\n", s.Type, dSoc)
+		return
+	}
+
+	log.Infof("CycleCounter: %s session %d %% -> %d %% (%.1f Ah)\n", s.Type, s.StartSoc, s.EndSoc, s.Ah)
+
+	if s.Type == SESSION_DISCHARGE {
+		bucket := dSoc / 10
+		if bucket > 9 {
+			bucket = 9
+		}
+		cc.history.DodHistogram[bucket]++
+	}
+
+	cc.history.Sessions = append(cc.history.Sessions, *s)
+	if len(cc.history.Sessions) > MAX_SESSIONS {
+		cc.history.Sessions = cc.history.Sessions[len(cc.history.Sessions)-MAX_SESSIONS:]
+	}
+
+	for _, f := range cc.sessionHandlers {
+		f(*s)
+	}
+}
+
+// OnSession registers a function that is called for every completed session. OnSession must be
+// called before the first update, f must not call methods of the CycleCounter.
+func (cc *CycleCounter) OnSession(f func(Session)) {
+	cc.sessionHandlers = append(cc.sessionHandlers, f)
+}
+
+// Update integrates the battery current since the last update and updates the sessions. Only current
+// flowing in the direction of the active session is added to the session (reversed current while the
+// direction switches is added to the next session, idle current is not added).
+func (cc *CycleCounter) Update(t time.Time, lgResu *rs.LgResuStatus) {
+	// SOC has not been received yet (sessions of BMS profiles without SOH have the SOH 0)
+	if !lgResu.HasReceived(rs.UPDATE_SOC) {
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.update(t, lgResu, MAX_INTEGRATION_INTERVAL)
+}
+
+// Replay updates the sessions with a recorded status (for example: a CSV datafile record). Longer
+// intervals than maxInterval between two records are not integrated. Datafile records do not contain
+// the SOH, sessions of records without SOH have the SOH 0.
+func (cc *CycleCounter) Replay(t time.Time, lgResu *rs.LgResuStatus, maxInterval time.Duration) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.update(t, lgResu, maxInterval)
+}
+
+// update integrates the battery current and updates the sessions (cc.mu must be locked).
+func (cc *CycleCounter) update(t time.Time, lgResu *rs.LgResuStatus, maxInterval time.Duration) {
+	h := &cc.history
+
+	// charged/discharged capacity and energy
+	ah, wh := 0.0, 0.0
+	if dt := t.Sub(h.Time); !h.Time.IsZero() && dt > 0 && dt <= maxInterval {
+		ah = math.Abs(float64(cc.current) * dt.Hours())
+		wh = math.Abs(float64(cc.power) * dt.Hours())
+		if cc.current > 0 {
+			h.ChargedAh += ah
+		} else {
+			h.DischargedAh += ah
+		}
+		h.EquivalentCycles = h.DischargedAh / float64(cc.capacity)
+	}
+	h.Time = t
+	// direction of the integrated current (current of the previous update)
+	prevDir := direction(cc.current)
+	cc.current = lgResu.Current
+	cc.power = lgResu.Voltage * lgResu.Current
+
+	dir := direction(lgResu.Current)
+
+	if h.Active != nil {
+		h.Active.Soh = lgResu.Soh
+
+		switch prevDir {
+		case h.Active.Type:
+			h.Active.Ah += ah
+			h.Active.Wh += wh
+		case "":
+		default:
+			cc.switchAh += ah
+			cc.switchWh += wh
+		}
+
+		switch {
+		case dir == h.Active.Type:
+			cc.resetSwitch()
+			cc.lastActive = t
+			h.Active.End, h.Active.EndSoc = t, lgResu.Soc
+		case dir == "":
+			cc.resetSwitch()
+			if t.Sub(cc.lastActive) >= SESSION_IDLE_TIME {
+				cc.closeSession()
+			}
+		default:
+			// current direction is reversed
+			if cc.switchTime.IsZero() {
+				cc.switchTime, cc.switchSoc = t, lgResu.Soc
+			}
+			if t.Sub(cc.switchTime) >= SESSION_SWITCH_TIME {
+				start, startSoc, switchAh, switchWh := cc.switchTime, cc.switchSoc, cc.switchAh, cc.switchWh
+				cc.closeSession()
+				h.Active = &Session{Type: dir, Start: start, End: t, StartSoc: startSoc, EndSoc: lgResu.Soc,
+					Ah: switchAh, Wh: switchWh, Soh: lgResu.Soh}
+				cc.lastActive = t
+			}
+		}
+	} else if dir != "" {
+		h.Active = &Session{Type: dir, Start: t, End: t, StartSoc: lgResu.Soc, EndSoc: lgResu.Soc, Soh: lgResu.Soh}
+		cc.lastActive = t
+	}
+
+	if len(cc.fileName) != 0 && t.Sub(cc.saved) >= SAVE_INTERVAL {
+		if err := cc.save(); err != nil {
+			log.Warnf("CycleCounter: could not save cycle history (%v)\n", err)
+		}
+		cc.saved = t
+	}
+}
+
+// History returns a copy of the cycle history.
+func (cc *CycleCounter) History() History {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	h := cc.history
+	h.Sessions = make([]Session, len(cc.history.Sessions))
+	copy(h.Sessions, cc.history.Sessions)
+	if cc.history.Active != nil {
+		active := *cc.history.Active
+		h.Active = &active
+	}
+	return h
+}
+
+// Save writes the cycle history to the JSON file.
+func (cc *CycleCounter) Save() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.save()
+}
+
+// save writes the cycle history to the JSON file (cc.mu must be locked).
+func (cc *CycleCounter) save() error {
+	return dr.WriteJSONFile(cc.fileName, cc.history)
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cyclecounter
+
+import (
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+// metrics received by the tests (SOC/SOH and volt/amp/temp messages)
+const received = rs.UPDATE_SOC | rs.UPDATE_SOH | rs.UPDATE_VOLTAGE | rs.UPDATE_CURRENT
+
+// step updates cc every 10 seconds for d with a constant current. The SOC changes linearly
+// from startSoc to endSoc.
+func step(cc *CycleCounter, t time.Time, d time.Duration, current float32, startSoc, endSoc uint16) time.Time {
+	n := int(d / (10 * time.Second))
+	for i := 1; i <= n; i++ {
+		soc := int(startSoc) + (int(endSoc)-int(startSoc))*i/n
+		cc.Update(t.Add(time.Duration(i)*10*time.Second),
+			&rs.LgResuStatus{Soc: uint16(soc), Soh: 99, Voltage: 50, Current: current, ReceivedFields: received})
+	}
+	return t.Add(time.Duration(n) * 10 * time.Second)
+}
+
+func TestCycleCounterSessions(t *testing.T) {
+	cc := NewCycleCounter("", rs.NOMINAL_CAPACITY)
+
+	sessions := []Session{}
+	cc.OnSession(func(s Session) { sessions = append(sessions, s) })
+
+	start := time.Date(2018, time.June, 11, 18, 0, 0, 0, time.Local)
+
+	// discharge 100% -> 70% (56.7Ah in 3 hours) with a 30 second charge spike
+	now := step(cc, start, 90*time.Minute, -18.9, 100, 85)
+	now = step(cc, now, 30*time.Second, 10, 85, 85)
+	now = step(cc, now, 90*time.Minute, -18.9, 85, 70)
+	// idle (ends the discharge session)
+	now = step(cc, now, SESSION_IDLE_TIME+10*time.Second, 0, 70, 70)
+	// charge 70% -> 100%
+	now = step(cc, now, 60*time.Minute, 56.7, 70, 100)
+	// discharge (ends the charge session after SESSION_SWITCH_TIME)
+	now = step(cc, now, 5*time.Minute, -10, 100, 99)
+
+	h := cc.History()
+
+	if len(h.Sessions) != 2 {
+		t.Fatalf("cc.History() == %+v, expect 2 sessions", h)
+	}
+
+	d, c := h.Sessions[0], h.Sessions[1]
+	if d.Type != SESSION_DISCHARGE || d.StartSoc != 100 || d.EndSoc != 70 || math.Abs(d.Ah-56.7) > 0.1 ||
+		math.Abs(d.Wh-2835) > 5 || d.Soh != 99 {
+		t.Errorf("discharge session == %+v, expect 100%% -> 70%%, 56.7 Ah, 2835 Wh, SOH 99%%", d)
+	}
+	if c.Type != SESSION_CHARGE || c.StartSoc != 70 || c.EndSoc != 100 {
+		t.Errorf("charge session == %+v, expect 70%% -> 100%%", c)
+	}
+
+	if !cmp.Equal(sessions, h.Sessions) {
+		t.Errorf("OnSession() handler received %+v, expect %+v", sessions, h.Sessions)
+	}
+
+	if h.Active == nil || h.Active.Type != SESSION_DISCHARGE {
+		t.Errorf("active session == %+v, expect discharge session", h.Active)
+	}
+
+	// DoD 30% (bucket 3)
+	if !cmp.Equal(h.DodHistogram, [10]uint32{0, 0, 0, 1, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("h.DodHistogram == %v, expect 1 session with DoD 30-39%%", h.DodHistogram)
+	}
+
+	// 56.7Ah + 5 minutes * 10A discharged
+	if expect := (56.7 + 10.0/12) / 189; math.Abs(h.EquivalentCycles-expect) > 0.001 {
+		t.Errorf("h.EquivalentCycles == %.4f, expect %.4f", h.EquivalentCycles, expect)
+	}
+}
+
+// TestCycleCounterSessionDirection tests that reversed and idle current is not added to a session.
+func TestCycleCounterSessionDirection(t *testing.T) {
+	cc := NewCycleCounter("", rs.NOMINAL_CAPACITY)
+	start := time.Now()
+
+	// discharge 18.9Ah with a 30 second 50A charge spike, 0.5A idle current (ends the discharge session)
+	now := step(cc, start, 30*time.Minute, -18.9, 100, 95)
+	now = step(cc, now, 30*time.Second, 50, 95, 95)
+	now = step(cc, now, 30*time.Minute, -18.9, 95, 90)
+	now = step(cc, now, SESSION_IDLE_TIME+10*time.Second, 0.5, 90, 90)
+
+	h := cc.History()
+	if len(h.Sessions) != 1 || math.Abs(h.Sessions[0].Ah-18.9) > 0.01 || math.Abs(h.Sessions[0].Wh-945) > 0.5 {
+		t.Fatalf("cc.History() == %+v, expect 1 discharge session with 18.9 Ah, 945 Wh", h)
+	}
+
+	// charge, discharge (the reversed current during SESSION_SWITCH_TIME is added to the discharge session,
+	// 290 seconds with 12A after the first discharge update)
+	now = step(cc, now, 30*time.Minute, 20, 90, 95)
+	step(cc, now, 5*time.Minute, -12, 95, 94)
+
+	h = cc.History()
+	if c := h.Sessions[1]; c.Type != SESSION_CHARGE || math.Abs(c.Ah-10) > 0.01 {
+		t.Errorf("charge session == %+v, expect 10 Ah", c)
+	}
+	if d := h.Active; d == nil || d.Type != SESSION_DISCHARGE || math.Abs(d.Ah-12*290.0/3600) > 0.01 {
+		t.Errorf("active session == %+v, expect discharge session with 0.97 Ah", d)
+	}
+}
+
+// TestCycleCounterIgnoresSmallSessions tests that sessions with a SOC change below MIN_SESSION_SOC are not recorded.
+func TestCycleCounterIgnoresSmallSessions(t *testing.T) {
+	cc := NewCycleCounter("", rs.NOMINAL_CAPACITY)
+
+	now := step(cc, time.Now(), 5*time.Minute, -5, 80, 80)
+	step(cc, now, SESSION_IDLE_TIME+10*time.Second, 0, 80, 80)
+
+	if h := cc.History(); len(h.Sessions) != 0 || h.Active != nil {
+		t.Errorf("cc.History() == %+v, expect no sessions", h)
+	}
+}
+
+// TestCycleCounterReceived tests that the current is integrated once the SOC has been received (a BMS
+// profile may not report the SOH).
+func TestCycleCounterReceived(t *testing.T) {
+	cc := NewCycleCounter("", rs.NOMINAL_CAPACITY)
+	now := time.Now()
+
+	for i, fields := range []rs.UpdateField{rs.UPDATE_CURRENT, rs.UPDATE_SOC | rs.UPDATE_CURRENT, rs.UPDATE_SOC | rs.UPDATE_CURRENT} {
+		cc.Update(now.Add(time.Duration(i)*10*time.Second), &rs.LgResuStatus{Soc: 80, Current: -18.9, ReceivedFields: fields})
+	}
+
+	if h := cc.History(); math.Abs(h.DischargedAh-0.0525) > 0.0001 || h.Active == nil || h.Active.Soh != 0 {
+		t.Errorf("cc.History() == %+v, expect 0.0525 Ah discharged, active session with SOH 0", h)
+	}
+}
+
+// TestCycleCounterPersistence tests that the cycle history survives a restart.
+func TestCycleCounterPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cyclecounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, CYCLE_FILE_NAME)
+
+	cc := NewCycleCounter(fileName, rs.NOMINAL_CAPACITY)
+	now := step(cc, time.Now(), 60*time.Minute, -18.9, 90, 80)
+	step(cc, now, SESSION_IDLE_TIME+10*time.Second, 0, 80, 80)
+
+	if err := cc.Save(); err != nil {
+		t.Fatalf("cc.Save() == %v, expect nil", err)
+	}
+
+	restarted := NewCycleCounter(fileName, rs.NOMINAL_CAPACITY)
+	if h := restarted.History(); !cmp.Equal(h.Sessions, cc.History().Sessions) || h.EquivalentCycles == 0 {
+		t.Errorf("restarted.History() == %+v, expect %+v", h, cc.History())
+	}
+}
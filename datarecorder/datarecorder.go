@@ -22,10 +22,15 @@
 //
 // RootPath and Extension are define in the constructor for datafile.
 // The first line of the datafiles contains a header describing the data
-// columns.
+// columns. If the datafile of the current day has been written with a different
+// header (for example: by a previous release with fewer columns), the datafile is
+// moved aside to <RootPath>/YYYY/MM/YYYYMMDD<Extension>.<n> and a new datafile
+// is started, so that a datafile never mixes two record layouts.
 //
 // Files older than RetentionPeriod days are automatically deleted to
-// maintain a constant number of files.
+// maintain a constant number of files. Files with other filenames (for
+// example: <RootPath>/energy.json) are never deleted. State files like
+// energy.json are written with WriteJSONFile.
 //
 // Example:
 //
@@ -52,6 +57,7 @@
 package datarecorder
 
 import (
+	"bufio"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
@@ -91,6 +97,30 @@ func exists(name string) bool {
 	return true
 }
 
+// readHeader returns the first line (including the newline) of the named file.
+func readHeader(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	header, _ := bufio.NewReader(f).ReadString('\n')
+	return header
+}
+
+// moveAside renames the named file to the first unused name <name>.<n> and returns the new name.
+func moveAside(name string) string {
+	for n := 1; ; n++ {
+		newName := name + "." + strconv.Itoa(n)
+		if !exists(newName) {
+			err := os.Rename(name, newName)
+			check(err)
+			return newName
+		}
+	}
+}
+
 // deleteExpiredFiles removes all files that are older than the retentionPeriod days.
 func deleteExpiredFiles(currentTime time.Time, rootDir string, retentionPeriod int) ([]string, error) {
 
@@ -116,10 +146,13 @@ func deleteExpiredFiles(currentTime time.Time, rootDir string, retentionPeriod i
 			// basename without extension
 			basename := str[0]
 
-			// parse date string
+			// parse date string (other files in the directory hierarchy (ie. state files) are not datafiles)
 			layout := "20060102"
 			datafileTime, err := time.Parse(layout, basename)
-			check(err)
+			if err != nil {
+				log.Debugf("deleteExpiredFiles: %s is not a datafile \n", f.Name())
+				return nil
+			}
 
 			if cutoff.After(datafileTime) {
 				// add file to list of files to be deleted
@@ -181,6 +214,14 @@ func (dr *Datarecorder) WriteToDatafile(currentTime time.Time, record string) {
 		// check if file has previously been created
 		fileExists := exists(filePath)
 
+		// never append records to a datafile written with a different header
+		if fileExists && readHeader(filePath) != dr.Header {
+			oldFilePath := moveAside(filePath)
+			log.Warnf("WriteToDatafile: header of %s has changed, moved previous datafile to %s \n",
+				filePath, oldFilePath)
+			fileExists = false
+		}
+
 		// update Datarecorder struct
 		dr.FileName = fileName
 
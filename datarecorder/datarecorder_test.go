@@ -3,6 +3,7 @@ package datarecorder
 import (
 	"bufio"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -147,3 +148,60 @@ func TestDatarecorderWriteToDatafileForRangeOfDays(t *testing.T) {
 	check(err)
 
 }
+
+// TestDeleteExpiredFilesIgnoresOtherFiles verifies that files which are not datafiles are not deleted.
+func TestDeleteExpiredFilesIgnoresOtherFiles(t *testing.T) {
+
+	df := NewDatarecorder(rootDir, extension, retentionPeriod, header)
+	df.WriteToDatafile(recordTime, sampleRecord)
+
+	stateFileName := filepath.Join(rootDir, "state.json")
+	err := ioutil.WriteFile(stateFileName, []byte("{}"), 0644)
+	check(err)
+
+	fileList, _ := deleteExpiredFiles(recordTime.AddDate(0, 0, 2*retentionPeriod), rootDir, retentionPeriod)
+
+	if len(fileList) != 1 || fileList[0] != absFileName || !exists(stateFileName) {
+		t.Errorf("deleteExpiredFiles() deleted %v, expect to delete %s only\n", fileList, absFileName)
+	}
+
+	// cleanup: remove entire directory hierarchy
+	err = os.RemoveAll(rootDir)
+	check(err)
+}
+
+// TestDatarecorderWriteToDatafileHeaderChange verifies that a datafile written with a different header
+// is moved aside and a new datafile is started.
+func TestDatarecorderWriteToDatafileHeaderChange(t *testing.T) {
+
+	oldHeader := "time,soc,soh,current,voltage\n"
+	oldRecord := "2006/01/02 15:04:05,97,99,-3.2,57.43\n"
+
+	df := NewDatarecorder(rootDir, extension, retentionPeriod, oldHeader)
+	df.WriteToDatafile(recordTime, oldRecord)
+	df.FileDesc.Close()
+
+	// restart with the new record layout
+	df = NewDatarecorder(rootDir, extension, retentionPeriod, header)
+	df.WriteToDatafile(recordTime, sampleRecord)
+	df.FileDesc.Close()
+
+	if h := readHeader(absFileName); h != header || countLines(absFileName) != 2 {
+		t.Errorf("readHeader() == %q (%d lines), expect %q (2 lines)\n", h, countLines(absFileName), header)
+	}
+
+	oldFileName := absFileName + ".1"
+	if h := readHeader(oldFileName); h != oldHeader || countLines(oldFileName) != 2 {
+		t.Errorf("readHeader() == %q (%d lines), expect %q (2 lines)\n", h, countLines(oldFileName), oldHeader)
+	}
+
+	// the previous datafile expires with the day it belongs to
+	fileList, _ := deleteExpiredFiles(recordTime.AddDate(0, 0, 2*retentionPeriod), rootDir, retentionPeriod)
+	if len(fileList) != 2 {
+		t.Errorf("deleteExpiredFiles() deleted %v, expect to delete %s and %s\n", fileList, absFileName, oldFileName)
+	}
+
+	// cleanup: remove entire directory hierarchy
+	err = os.RemoveAll(rootDir)
+	check(err)
+}
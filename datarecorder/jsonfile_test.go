@@ -0,0 +1,56 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datarecorder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the directory is created
+	fileName := filepath.Join(dir, "battery", "state.json")
+	for _, soc := range []uint16{42, 43} {
+		if err := WriteJSONFile(fileName, struct {
+			Soc uint16 `json:"soc"`
+		}{soc}); err != nil {
+			t.Fatalf("WriteJSONFile() == %v, expect nil", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	state := struct {
+		Soc uint16 `json:"soc"`
+	}{}
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil || state.Soc != 43 {
+		t.Errorf("WriteJSONFile() wrote Soc = %d (%v), expect Soc = 43", state.Soc, err)
+	}
+
+	if _, err := os.Stat(fileName + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s.tmp) == %v, expect temporary file to be renamed", fileName, err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discoveraes provides routines to decode Discover AES (AEBus)
+// CANBus messages into the common lgresustatus.LgResuStatus.
+//
+// Importing the package registers the BMS profile 'discover':
+//
+//     import _ "github.com/jens18/lgresu/discoveraes"
+//
+// Note:
+//
+// The profile does not create a 'keep alive' message. Limits (0x351),
+// state of charge/health (0x355), volt/amp/temp (0x356) and alarms/warnings
+// (0x35A) are decoded.
+//
+// CANBus BMS Message format specification:
+//
+// Discover AES AEBus Communication Protocol Specification:
+//
+// http://discoveraes.com/wp-content/uploads/2017/12/AEBus-Communication-Protocol-Specification.pdf
+//
+package discoveraes
+
+import (
+	"encoding/binary"
+	"fmt"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DISCOVER_PROFILE is the name of the Discover AES BMS profile.
+const DISCOVER_PROFILE string = "discover"
+
+// Definition of the Discover AES CANBus message id's
+const (
+	AES_LIMITS        uint32 = 0x351
+	AES_SOC_SOH       uint32 = 0x355
+	AES_VOLT_AMP_TEMP uint32 = 0x356
+	AES_FAULTS        uint32 = 0x35a
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+// FaultValue contains the position of a single 2 bit alarm/warning state and its definition.
+type FaultValue struct {
+	Description string
+	Shift       uint8
+}
+
+// Fault states (2 bits per alarm/warning).
+const (
+	FAULT_ACTIVE   uint32 = 0x1
+	FAULT_INACTIVE uint32 = 0x2
+)
+
+// FaultValues defines 13 alarms/warnings.
+//
+// Raw CANBus message format:
+//
+// 0000035A 8 a0 a1 a2 a3 w0 w1 w2 w3
+//
+//  a0 0-1 GENERAL
+//  a0 2-3 BATTERY_HIGH_VOLTAGE
+//  a0 4-5 BATTERY_LOW_VOLTAGE
+//  a0 6-7 BATTERY_HIGH_TEMP
+//  a1 0-1 BATTERY_LOW_TEMP
+//  a1 2-3 BATTERY_HIGH_TEMP_CHARGE
+//  a1 4-5 BATTERY_LOW_TEMP_CHARGE
+//  a1 6-7 BATTERY_HIGH_CURRENT_DISCHARGE
+//  a2 0-1 BATTERY_HIGH_CURRENT_CHARGE
+//  a2 2-3 CONTACTOR
+//  a2 4-5 SHORT_CIRCUIT
+//  a2 6-7 BMS_INTERNAL
+//  a3 0-1 CELL_IMBALANCE
+//
+// w0-w3 contain the warnings in the same layout.
+//
+// Note:
+// Shift values are applied after converting the littleEndian representation
+// of 4 bytes to the bigEndian representation. An alarm/warning is active if
+// its 2 bits are set to 01.
+var FaultValues = []FaultValue{
+	{"GENERAL", 0},
+	{"BATTERY_HIGH_VOLTAGE", 2},
+	{"BATTERY_LOW_VOLTAGE", 4},
+	{"BATTERY_HIGH_TEMP", 6},
+	{"BATTERY_LOW_TEMP", 8},
+	{"BATTERY_HIGH_TEMP_CHARGE", 10},
+	{"BATTERY_LOW_TEMP_CHARGE", 12},
+	{"BATTERY_HIGH_CURRENT_DISCHARGE", 14},
+	{"BATTERY_HIGH_CURRENT_CHARGE", 16},
+	{"CONTACTOR", 18},
+	{"SHORT_CIRCUIT", 20},
+	{"BMS_INTERNAL", 22},
+	{"CELL_IMBALANCE", 24},
+}
+
+// decodeFaults returns the descriptions of all active faults in data.
+func decodeFaults(data uint32) []string {
+	var faults []string
+	for _, fv := range FaultValues {
+		if (data>>fv.Shift)&0x3 == FAULT_ACTIVE {
+			faults = append(faults, fv.Description)
+		}
+	}
+	return faults
+}
+
+// Decode decodes a single message send by the Discover AES BMS. Decode returns an
+// rs.UnknownMessageError for message id's that are not part of the AEBus protocol and
+// an rs.MessageLengthError for messages that are too short.
+func Decode(id uint32, s []byte) (rs.Update, error) {
+
+	u := rs.Update{Id: id}
+	lgResu := &u.Status
+
+	log.Debugf("%-4x % -24X\n", id, s)
+
+	switch id {
+	case AES_VOLT_AMP_TEMP:
+		log.Debugf("AES: volt/amp/temp (%#04x)\n", AES_VOLT_AMP_TEMP)
+
+		if err := rs.CheckLength(id, s, 6); err != nil {
+			return u, err
+		}
+
+		// signed: voltage
+		data := binary.LittleEndian.Uint16(s[0:2])
+		lgResu.Voltage = float32(int16(data)) / 100
+		log.Debugf("voltage = %.2f [VDC]\n", lgResu.Voltage)
+
+		// signed: - battery is discharged, + battery is charged
+		data = binary.LittleEndian.Uint16(s[2:4])
+		lgResu.Current = float32(int16(data)) / 10
+		log.Debugf("current = %.2f [ADC]\n", lgResu.Current)
+
+		// signed: temperature in Celsius
+		data = binary.LittleEndian.Uint16(s[4:6])
+		lgResu.Temp = float32(int16(data)) / 10
+		log.Debugf("temperature = %.1f [Celsius]\n\n", lgResu.Temp)
+
+		u.Fields = rs.UPDATE_VOLTAGE | rs.UPDATE_CURRENT | rs.UPDATE_TEMP
+
+	case AES_SOC_SOH:
+		log.Debugf("AES: state of charge/health (%#04x):\n", AES_SOC_SOH)
+
+		if err := rs.CheckLength(id, s, 4); err != nil {
+			return u, err
+		}
+
+		data := binary.LittleEndian.Uint16(s[0:2])
+		lgResu.Soc = data
+		log.Debugf("soc = %d %%\n", lgResu.Soc)
+
+		data = binary.LittleEndian.Uint16(s[2:4])
+		lgResu.Soh = data
+		log.Debugf("soh = %d %%\n\n", lgResu.Soh)
+
+		if lgResu.Soc > 100 || lgResu.Soh > 100 {
+			return u, &rs.MalformedMessageError{Id: id,
+				Reason: fmt.Sprintf("soc = %d %%, soh = %d %% (expect <= 100 %%)", lgResu.Soc, lgResu.Soh)}
+		}
+
+		u.Fields = rs.UPDATE_SOC | rs.UPDATE_SOH
+
+	case AES_LIMITS:
+		log.Debugf("AES: charge/discharge limits (%#04x):\n", AES_LIMITS)
+
+		if err := rs.CheckLength(id, s, 8); err != nil {
+			return u, err
+		}
+
+		// unsigned: charge voltage
+		data := binary.LittleEndian.Uint16(s[0:2])
+		lgResu.MaxVoltage = float32(data) / 10
+		log.Debugf("max voltage = %.2f [VDC]\n", lgResu.MaxVoltage)
+
+		// signed: ADC
+		data = binary.LittleEndian.Uint16(s[2:4])
+		lgResu.MaxChargeCurrent = float32(int16(data)) / 10
+		log.Debugf("max charge current = %.2f [ADC]\n", lgResu.MaxChargeCurrent)
+
+		// signed: ADC
+		data = binary.LittleEndian.Uint16(s[4:6])
+		lgResu.MaxDischargeCurrent = float32(int16(data)) / 10
+		log.Debugf("max discharge current = %.2f [ADC]\n", lgResu.MaxDischargeCurrent)
+
+		// unsigned: discharge voltage (not part of LgResuStatus)
+		data = binary.LittleEndian.Uint16(s[6:8])
+		log.Debugf("min voltage = %.2f [VDC]\n\n", float32(data)/10)
+
+		u.Fields = rs.UPDATE_MAX_VOLTAGE | rs.UPDATE_MAX_CHARGE_CURRENT | rs.UPDATE_MAX_DISCHARGE_CURRENT
+
+	case AES_FAULTS:
+		log.Debugf("AES: alarms/warnings (%#04x):\n\n", AES_FAULTS)
+
+		if err := rs.CheckLength(id, s, 8); err != nil {
+			return u, err
+		}
+
+		lgResu.Alarms = decodeFaults(binary.LittleEndian.Uint32(s[0:4]))
+		lgResu.Warnings = decodeFaults(binary.LittleEndian.Uint32(s[4:8]))
+
+		u.Fields = rs.UPDATE_WARNINGS | rs.UPDATE_ALARMS
+
+	default:
+		return u, &rs.UnknownMessageError{Id: id}
+	}
+
+	return u, nil
+}
+
+// profile is the BmsProfile of the Discover AES.
+type profile struct{}
+
+func (profile) Name() string {
+	return DISCOVER_PROFILE
+}
+
+func (profile) Decode(id uint32, s []byte) (rs.Update, error) {
+	return Decode(id, s)
+}
+
+func (profile) CreateKeepAliveMessage() (id uint32, s []byte) {
+	return 0, nil
+}
+
+func init() {
+	rs.RegisterProfile(profile{})
+}
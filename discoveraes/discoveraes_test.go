@@ -0,0 +1,120 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discoveraes
+
+import (
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"testing"
+)
+
+// Discover AES CANBus test messages
+var CanbusTestMessages = []struct {
+	Identifier uint32
+	Data       [8]byte
+	Expect     rs.LgResuStatus
+}{
+	// volt/amp/temp (Discover AES -> Inverter):
+	{
+		Identifier: AES_VOLT_AMP_TEMP,
+		Data:       [8]byte{0x6e, 0x14, 0x83, 0xff, 0xd7, 0x00, 0x00, 0x00},
+		Expect:     rs.LgResuStatus{Voltage: 52.30, Current: -12.5, Temp: 21.5},
+	},
+	// charge/discharge limits (Discover AES -> Inverter):
+	{
+		Identifier: AES_LIMITS,
+		Data:       [8]byte{0x30, 0x02, 0xe8, 0x03, 0xe8, 0x03, 0xa4, 0x01},
+		Expect: rs.LgResuStatus{Voltage: 52.30, Current: -12.5, Temp: 21.5,
+			MaxVoltage: 56.0, MaxChargeCurrent: 100.0, MaxDischargeCurrent: 100.0},
+	},
+	// state of charge/health (Discover AES -> Inverter):
+	{
+		Identifier: AES_SOC_SOH,
+		Data:       [8]byte{0x55, 0x00, 0x64, 0x00, 0x48, 0x21, 0x00, 0x00},
+		Expect: rs.LgResuStatus{Voltage: 52.30, Current: -12.5, Temp: 21.5,
+			MaxVoltage: 56.0, MaxChargeCurrent: 100.0, MaxDischargeCurrent: 100.0,
+			Soc: 85, Soh: 100},
+	},
+	// alarms/warnings (Discover AES -> Inverter): all alarms inactive, high temperature warning
+	{
+		Identifier: AES_FAULTS,
+		Data:       [8]byte{0xaa, 0xaa, 0xaa, 0xaa, 0x6a, 0xaa, 0xaa, 0xaa},
+		Expect: rs.LgResuStatus{Voltage: 52.30, Current: -12.5, Temp: 21.5,
+			MaxVoltage: 56.0, MaxChargeCurrent: 100.0, MaxDischargeCurrent: 100.0,
+			Soc: 85, Soh: 100,
+			Warnings: []string{"BATTERY_HIGH_TEMP"}},
+	},
+	// alarms/warnings (Discover AES -> Inverter): warning cleared, contactor and short circuit alarm
+	{
+		Identifier: AES_FAULTS,
+		Data:       [8]byte{0xaa, 0xaa, 0x96, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa},
+		Expect: rs.LgResuStatus{Voltage: 52.30, Current: -12.5, Temp: 21.5,
+			MaxVoltage: 56.0, MaxChargeCurrent: 100.0, MaxDischargeCurrent: 100.0,
+			Soc: 85, Soh: 100,
+			Alarms: []string{"CONTACTOR", "SHORT_CIRCUIT"}},
+	},
+}
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+func TestDecodeDiscoverCanbusMessageToUpdateLgResuStatus(t *testing.T) {
+
+	p, err := rs.LookupProfile(DISCOVER_PROFILE)
+	if err != nil {
+		t.Fatalf("rs.LookupProfile(%q) returned error %v", DISCOVER_PROFILE, err)
+	}
+
+	lgResu := &rs.LgResuStatus{}
+
+	// process all test messages (the alarms remain nil until a fault message reports an active alarm)
+	for _, tm := range CanbusTestMessages {
+		u, err := p.Decode(tm.Identifier, tm.Data[:])
+		if err != nil {
+			t.Fatalf("p.Decode(%x, %+v) returned error %v", tm.Identifier, tm.Data, err)
+		}
+		u.Apply(lgResu)
+		if !cmp.Equal(*lgResu, tm.Expect) {
+			t.Errorf("p.Decode(%x, %+v) == %+v, expect %+v", tm.Identifier, tm.Data, *lgResu, tm.Expect)
+		}
+	}
+}
+
+func TestLookupDiscoverProfile(t *testing.T) {
+	p, err := rs.LookupProfile(DISCOVER_PROFILE)
+	if err != nil {
+		t.Fatalf("rs.LookupProfile(%q) returned error %v", DISCOVER_PROFILE, err)
+	}
+
+	lgResu := &rs.LgResuStatus{}
+	tm := CanbusTestMessages[0]
+
+	u, err := p.Decode(tm.Identifier, tm.Data[:])
+	if err != nil {
+		t.Fatalf("p.Decode(%x, %+v) returned error %v", tm.Identifier, tm.Data, err)
+	}
+	u.Apply(lgResu)
+	if !cmp.Equal(*lgResu, tm.Expect) {
+		t.Errorf("p.Decode(%x, %+v) == %+v, expect %+v", tm.Identifier, tm.Data, *lgResu, tm.Expect)
+	}
+
+	// Discover AES does not use a keep alive message
+	if _, data := p.CreateKeepAliveMessage(); data != nil {
+		t.Errorf("p.CreateKeepAliveMessage() returned data = % X, expect nil", data)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package energymeter computes the battery power (Voltage * Current) and
+// integrates the power over time into energy counters (Wh charged and
+// discharged today, this month and lifetime).
+//
+// The energy counters are persisted in a JSON file and survive restarts
+// of lgresu_mon. Intervals without updates longer than MAX_INTEGRATION_INTERVAL
+// (for example: lgresu_mon was not running or the BMS was offline) are not
+// integrated.
+//
+// Example JSON file:
+//
+//     {"time":"2018-06-11T18:01:53-07:00","counters":{"chargedToday":5320.4,"dischargedToday":2210.9,
+//     "chargedMonth":48731.2,"dischargedMonth":45213.7,"chargedLifetime":482731.2,"dischargedLifetime":451201.6}}
+//
+package energymeter
+
+import (
+	"encoding/json"
+	dr "github.com/jens18/lgresu/datarecorder"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// filename of the persisted energy counters (in the datafile root directory)
+	ENERGY_FILE_NAME string = "energy.json"
+	// longer intervals between two updates are not integrated
+	MAX_INTEGRATION_INTERVAL time.Duration = 30 * time.Second
+	// interval between two saves of the energy counters
+	SAVE_INTERVAL time.Duration = 60 * time.Second
+)
+
+// energyState contains the persisted state of the EnergyMeter.
+type energyState struct {
+	Time     time.Time         `json:"time"`
+	Counters rs.EnergyCounters `json:"counters"`
+}
+
+// EnergyMeter integrates the battery power into energy counters. EnergyMeter can be used concurrently.
+type EnergyMeter struct {
+	mu       sync.Mutex
+	fileName string
+	state    energyState
+	// power at the time of the last update
+	power float32
+	saved time.Time
+}
+
+// NewEnergyMeter is the constructor for EnergyMeter. fileName is the JSON file used to persist the
+// energy counters (no persistence if fileName is empty). Existing energy counters are read from fileName.
+func NewEnergyMeter(fileName string) *EnergyMeter {
+	em := &EnergyMeter{fileName: fileName}
+
+	if len(fileName) == 0 {
+		return em
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		log.Infof("NewEnergyMeter: %s does not exist, energy counters start at 0 Wh\n", fileName)
+		return em
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &em.state)
+	}
+	if err != nil {
+		log.Warnf("NewEnergyMeter: could not read %s (%v), energy counters start at 0 Wh\n", fileName, err)
+		em.state = energyState{}
+	}
+	return em
+}
+
+// rollover resets the daily and monthly energy counters if t is in a new day or month.
+func (em *EnergyMeter) rollover(t time.Time) {
+	last := em.state.Time.Local()
+	t = t.Local()
+
+	if t.Year() != last.Year() || t.Month() != last.Month() {
+		em.state.Counters.ChargedMonth = 0
+		em.state.Counters.DischargedMonth = 0
+	}
+	if t.Year() != last.Year() || t.YearDay() != last.YearDay() {
+		em.state.Counters.ChargedToday = 0
+		em.state.Counters.DischargedToday = 0
+	}
+}
+
+// Update computes the power of lgResu and integrates the power since the previous update into the energy
+// counters. The power and energy counters of lgResu are updated.
+func (em *EnergyMeter) Update(t time.Time, lgResu *rs.LgResuStatus) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	lgResu.Power = lgResu.Voltage * lgResu.Current
+
+	if !em.state.Time.IsZero() {
+		em.rollover(t)
+
+		dt := t.Sub(em.state.Time)
+		if dt > 0 && dt <= MAX_INTEGRATION_INTERVAL {
+			wh := float64(em.power) * dt.Hours()
+
+			c := &em.state.Counters
+			if wh > 0 {
+				c.ChargedToday += wh
+				c.ChargedMonth += wh
+				c.ChargedLifetime += wh
+			} else {
+				c.DischargedToday -= wh
+				c.DischargedMonth -= wh
+				c.DischargedLifetime -= wh
+			}
+		}
+	}
+
+	em.state.Time = t
+	em.power = lgResu.Power
+	lgResu.Energy = em.state.Counters
+
+	if len(em.fileName) != 0 && t.Sub(em.saved) >= SAVE_INTERVAL {
+		if err := em.save(); err != nil {
+			log.Warnf("EnergyMeter: could not save energy counters (%v)\n", err)
+		}
+		em.saved = t
+	}
+}
+
+// Counters returns the current energy counters.
+func (em *EnergyMeter) Counters() rs.EnergyCounters {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	return em.state.Counters
+}
+
+// Save writes the energy counters to the JSON file.
+func (em *EnergyMeter) Save() error {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	return em.save()
+}
+
+// save writes the energy counters to the JSON file (em.mu must be locked).
+func (em *EnergyMeter) save() error {
+	return dr.WriteJSONFile(em.fileName, em.state)
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package energymeter
+
+import (
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+// equal compares energy counters with a tolerance of 0.001 Wh.
+var equal = cmp.Comparer(func(x, y float64) bool { return math.Abs(x-y) < 0.001 })
+
+// TestEnergyMeterUpdate integrates 1 hour of charging and 30 minutes of discharging.
+func TestEnergyMeterUpdate(t *testing.T) {
+	em := NewEnergyMeter("")
+
+	start := time.Date(2018, time.June, 11, 10, 0, 0, 0, time.Local)
+	lgResu := &rs.LgResuStatus{Voltage: 50, Current: 20}
+
+	// 1000 W charging for 1 hour (updates every second)
+	for i := 0; i <= 3600; i++ {
+		em.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+
+	// 500 W discharging for 30 minutes
+	lgResu.Current = -10
+	for i := 3601; i <= 5400; i++ {
+		em.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+
+	expect := rs.EnergyCounters{ChargedToday: 1000, DischargedToday: 250, ChargedMonth: 1000, DischargedMonth: 250,
+		ChargedLifetime: 1000, DischargedLifetime: 250}
+
+	// the first discharging interval is integrated with the charging power
+	expect.ChargedToday += 1000.0 / 3600
+	expect.ChargedMonth, expect.ChargedLifetime = expect.ChargedToday, expect.ChargedToday
+	expect.DischargedToday -= 500.0 / 3600
+	expect.DischargedMonth, expect.DischargedLifetime = expect.DischargedToday, expect.DischargedToday
+
+	if lgResu.Power != -500 || !cmp.Equal(lgResu.Energy, expect, equal) {
+		t.Errorf("em.Update() produce Power = %.1f, Energy = %+v, expect -500.0, %+v", lgResu.Power, lgResu.Energy, expect)
+	}
+}
+
+// TestEnergyMeterRollover tests that the daily/monthly counters are reset and gaps are not integrated.
+func TestEnergyMeterRollover(t *testing.T) {
+	em := NewEnergyMeter("")
+
+	lgResu := &rs.LgResuStatus{Voltage: 50, Current: 72}
+
+	// 3600 W for 10 seconds before and after midnight (end of month)
+	start := time.Date(2018, time.June, 30, 23, 59, 50, 0, time.Local)
+	for i := 0; i <= 20; i++ {
+		em.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+
+	// intervals are counted for the day of the end of the interval (00:00:00 is part of the new day)
+	c := em.Counters()
+	if math.Abs(c.ChargedToday-11) > 0.001 || math.Abs(c.ChargedMonth-11) > 0.001 || math.Abs(c.ChargedLifetime-20) > 0.001 {
+		t.Errorf("em.Counters() == %+v after midnight, expect 11 Wh today/month, 20 Wh lifetime", c)
+	}
+
+	// gap (lgresu_mon not running)
+	em.Update(start.Add(time.Hour), lgResu)
+
+	if c := em.Counters(); math.Abs(c.ChargedLifetime-20) > 0.001 {
+		t.Errorf("em.Counters() == %+v after gap, expect 20 Wh lifetime", c)
+	}
+}
+
+// TestEnergyMeterPersistence tests that the energy counters survive a restart.
+func TestEnergyMeterPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "energymeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "data", ENERGY_FILE_NAME)
+
+	em := NewEnergyMeter(fileName)
+	lgResu := &rs.LgResuStatus{Voltage: 50, Current: -72}
+
+	start := time.Now()
+	for i := 0; i <= 10; i++ {
+		em.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+	if err := em.Save(); err != nil {
+		t.Fatalf("em.Save() == %v, expect nil", err)
+	}
+
+	restarted := NewEnergyMeter(fileName)
+	if !cmp.Equal(restarted.Counters(), em.Counters()) || math.Abs(restarted.Counters().DischargedLifetime-10) > 0.001 {
+		t.Errorf("restarted.Counters() == %+v, expect %+v", restarted.Counters(), em.Counters())
+	}
+
+	// invalid JSON file
+	ioutil.WriteFile(fileName, []byte("{"), 0644)
+	if c := NewEnergyMeter(fileName).Counters(); c.DischargedLifetime != 0 {
+		t.Errorf("NewEnergyMeter() of invalid file produce %+v, expect 0 Wh", c)
+	}
+}
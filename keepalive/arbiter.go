@@ -0,0 +1,99 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keepalive
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// keep-alive roles
+	ROLE_ACTIVE  string = "active"
+	ROLE_PASSIVE string = "passive"
+	// default time without foreign keep-alive message before the role changes from passive to active
+	FOREIGN_TIMEOUT time.Duration = 60 * time.Second
+)
+
+// ArbiterStatus contains the keep-alive role and the foreign keep-alive messages received.
+type ArbiterStatus struct {
+	// active: keep-alive messages are send, passive: another device sends keep-alive messages
+	Role  string    `json:"role"`
+	Since time.Time `json:"since"`
+	// time of the last keep-alive message send by another device (zero: never received)
+	LastForeign     time.Time `json:"lastForeign"`
+	ForeignMessages uint64    `json:"foreignMessages"`
+}
+
+// Arbiter detects keep-alive messages send by other devices (ie. inverter, CANBus bridge). The role
+// changes to passive (no keep-alive messages are send) when a foreign keep-alive message is received
+// and back to active if no foreign keep-alive message has been received for timeout. Arbiter can be
+// used concurrently.
+type Arbiter struct {
+	mu      sync.Mutex
+	id      uint32
+	timeout time.Duration
+	status  ArbiterStatus
+}
+
+// NewArbiter is the constructor for Arbiter. id is the message id of the keep-alive message.
+func NewArbiter(id uint32, timeout time.Duration) *Arbiter {
+	return &Arbiter{id: id, timeout: timeout, status: ArbiterStatus{Role: ROLE_ACTIVE, Since: time.Now()}}
+}
+
+// Observe processes a CANBus message received from another device.
+func (a *Arbiter) Observe(t time.Time, id uint32) {
+	if id != a.id {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.status.LastForeign = t
+	a.status.ForeignMessages++
+
+	if a.status.Role != ROLE_PASSIVE {
+		log.Infof("Arbiter: foreign keep-alive message %#03x received, switching to passive\n", id)
+		a.status.Role, a.status.Since = ROLE_PASSIVE, t
+	}
+}
+
+// update changes the role to active after timeout without foreign keep-alive message (a.mu must be locked).
+func (a *Arbiter) update(t time.Time) {
+	if a.status.Role == ROLE_PASSIVE && t.Sub(a.status.LastForeign) >= a.timeout {
+		log.Infof("Arbiter: no foreign keep-alive message within %v, switching to active\n", a.timeout)
+		a.status.Role, a.status.Since = ROLE_ACTIVE, t
+	}
+}
+
+// Allow returns true if the role is active (keep-alive message should be send).
+func (a *Arbiter) Allow(t time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.update(t)
+	return a.status.Role == ROLE_ACTIVE
+}
+
+// Status returns the keep-alive role.
+func (a *Arbiter) Status(t time.Time) ArbiterStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.update(t)
+	return a.status
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keepalive
+
+import (
+	rs "github.com/jens18/lgresu/lgresustatus"
+	"testing"
+	"time"
+)
+
+func TestArbiter(t *testing.T) {
+	a := NewArbiter(rs.INV_KEEP_ALIVE, 30*time.Second)
+	start := time.Now()
+
+	if !a.Allow(start) {
+		t.Errorf("a.Allow() == false without foreign keep-alive message, expect true")
+	}
+
+	// other messages do not change the role
+	a.Observe(start, rs.BMS_VOLT_AMP_TEMP)
+	if s := a.Status(start); s.Role != ROLE_ACTIVE || s.ForeignMessages != 0 {
+		t.Errorf("a.Status() == %+v, expect active", s)
+	}
+
+	// foreign keep-alive messages every 20 seconds
+	for i := 0; i < 3; i++ {
+		a.Observe(start.Add(time.Duration(i)*20*time.Second), rs.INV_KEEP_ALIVE)
+	}
+	now := start.Add(40 * time.Second)
+
+	if s := a.Status(now.Add(29 * time.Second)); s.Role != ROLE_PASSIVE || !s.Since.Equal(start) || s.ForeignMessages != 3 {
+		t.Errorf("a.Status() == %+v, expect passive since %v, 3 foreign messages", s, start)
+	}
+	if a.Allow(now.Add(29 * time.Second)) {
+		t.Errorf("a.Allow() == true while foreign keep-alive messages are received, expect false")
+	}
+
+	// foreign keep-alive messages stopped
+	if !a.Allow(now.Add(30 * time.Second)) {
+		t.Errorf("a.Allow() == false after timeout, expect true")
+	}
+	if s := a.Status(now.Add(31 * time.Second)); s.Role != ROLE_ACTIVE || !s.Since.Equal(now.Add(30*time.Second)) {
+		t.Errorf("a.Status() == %+v, expect active since %v", s, now.Add(30*time.Second))
+	}
+}
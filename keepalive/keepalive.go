@@ -0,0 +1,172 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keepalive contains the 'keep alive' messages send to the BMS.
+//
+// The LG Resu 10 LV only enables its relay if it receives a 'keep alive'
+// message from an inverter (or from lgresu_mon). Inverters and CANBus bridges
+// send different payloads in different intervals. A Profile describes the
+// message id, payload and interval of one device:
+//
+//	p := keepalive.FromBmsProfile(bmsProfile, 20*time.Second)
+//	p.Data, err = keepalive.ParsePayload("01 00 00 00 00 00 00 00")
+//
+// There are no built-in inverter profiles (Conext Bridge, SMA Sunny Island,
+// Victron): their keep-alive messages are not documented and have not been
+// captured from a real installation. The keep-alive message of an existing
+// device can be learned from the CANBus with a Learner and replayed later:
+//
+//	l := keepalive.NewLearner(rs.INV_KEEP_ALIVE, keepalive.LEARN_FRAMES)
+//	// for every received CANBus frame:
+//	if p, ok := l.Observe(time.Now(), frm.ID, frm.Data[:frm.Length]); ok {
+//	    keepalive.SaveProfile(fileName, p)
+//	}
+package keepalive
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// name of a learned profile
+	LEARNED_PROFILE string = "learned"
+	// filename of the learned keep-alive message (in the datafile directory)
+	KEEP_ALIVE_FILE_NAME string = "keepalive.json"
+	// number of identical messages required to learn a keep-alive message
+	LEARN_FRAMES int = 4
+	// learned intervals are rounded to LEARN_RESOLUTION
+	LEARN_RESOLUTION time.Duration = 10 * time.Millisecond
+)
+
+// Profile contains the keep-alive message of one device (inverter, CANBus bridge).
+type Profile struct {
+	Name string `json:"name"`
+	ID   uint32 `json:"id"`
+	// payload (nil: no keep-alive message is send)
+	Data     []byte        `json:"data"`
+	Interval time.Duration `json:"interval"`
+}
+
+// FromBmsProfile returns the keep-alive message created by a BMS profile, send every interval.
+func FromBmsProfile(bmsProfile rs.BmsProfile, interval time.Duration) Profile {
+	id, data := bmsProfile.CreateKeepAliveMessage()
+	return Profile{bmsProfile.Name(), id, data, interval}
+}
+
+// ParsePayload parses a hex encoded payload (for example: "00 00 00 00 00 00 00 00" or "0000000000000000").
+// The payload contains up to 8 bytes.
+func ParsePayload(s string) ([]byte, error) {
+	data, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		return nil, fmt.Errorf("keepalive: invalid payload %q (%v)", s, err)
+	}
+	if len(data) > 8 {
+		return nil, fmt.Errorf("keepalive: payload %q has %d bytes, expect at most 8 bytes", s, len(data))
+	}
+	return data, nil
+}
+
+// String returns the profile in candump format (for example: lgresu 0x305#0000000000000000 every 20s).
+func (p Profile) String() string {
+	if p.Data == nil {
+		return p.Name + " (no keep-alive message)"
+	}
+	return fmt.Sprintf("%s %#03x#%X every %v", p.Name, p.ID, p.Data, p.Interval)
+}
+
+// Learner learns the keep-alive message of another device from the CANBus: LEARN_FRAMES consecutive
+// messages with the same id and payload. The interval is the average interval between these messages.
+type Learner struct {
+	id     uint32
+	frames int
+	data   []byte
+	first  time.Time
+	last   time.Time
+	count  int
+}
+
+// NewLearner is the constructor for Learner. id is the message id of the keep-alive message,
+// frames is the number of identical messages required.
+func NewLearner(id uint32, frames int) *Learner {
+	return &Learner{id: id, frames: frames}
+}
+
+// Observe processes a received CANBus message. Observe returns the learned profile (and true) once
+// frames identical messages have been received.
+func (l *Learner) Observe(t time.Time, id uint32, data []byte) (Profile, bool) {
+	if id != l.id {
+		return Profile{}, false
+	}
+
+	if l.count == 0 || string(data) != string(l.data) {
+		log.Debugf("Learner: new keep-alive payload % X\n", data)
+		l.data = append([]byte{}, data...)
+		l.first, l.count = t, 0
+	}
+	l.last = t
+	l.count++
+
+	if l.count < l.frames {
+		return Profile{}, false
+	}
+
+	interval := (l.last.Sub(l.first) / time.Duration(l.count-1)).Round(LEARN_RESOLUTION)
+	p := Profile{LEARNED_PROFILE, l.id, append([]byte{}, l.data...), interval}
+	l.count = 0
+
+	log.Infof("Learner: learned keep-alive message %v\n", p)
+	return p, true
+}
+
+// LoadProfile reads a (learned) keep-alive profile from a JSON file.
+func LoadProfile(fileName string) (Profile, error) {
+	var p Profile
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("keepalive: %s: %v", fileName, err)
+	}
+	if p.Interval <= 0 {
+		return p, fmt.Errorf("keepalive: %s: invalid interval %v", fileName, p.Interval)
+	}
+	return p, nil
+}
+
+// SaveProfile writes a (learned) keep-alive profile to a JSON file.
+func SaveProfile(fileName string, p Profile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
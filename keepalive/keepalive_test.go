@@ -0,0 +1,104 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keepalive
+
+import (
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+func TestFromBmsProfile(t *testing.T) {
+	bms, _ := rs.LookupProfile(rs.LG_RESU_PROFILE)
+	if p := FromBmsProfile(bms, 20*time.Second); p.String() != "lgresu 0x305#0000000000000000 every 20s" {
+		t.Errorf("FromBmsProfile() == %v", p)
+	}
+}
+
+func TestParsePayload(t *testing.T) {
+	data, err := ParsePayload("01 02 0a FF")
+	if err != nil || !cmp.Equal(data, []byte{0x01, 0x02, 0x0a, 0xff}) {
+		t.Errorf("ParsePayload() == % X, %v, expect 01 02 0A FF", data, err)
+	}
+
+	for _, s := range []string{"0x01", "012", "000000000000000000"} {
+		if _, err := ParsePayload(s); err == nil {
+			t.Errorf("ParsePayload(%q) == nil, expect error", s)
+		}
+	}
+}
+
+func TestLearner(t *testing.T) {
+	l := NewLearner(rs.INV_KEEP_ALIVE, 3)
+	start := time.Date(2018, time.June, 11, 18, 0, 0, 0, time.Local)
+	payload := []byte{0x01, 0x02}
+
+	frames := []struct {
+		offset time.Duration
+		id     uint32
+		data   []byte
+	}{
+		{0, rs.INV_KEEP_ALIVE, []byte{0x00}},
+		// other messages are ignored
+		{1 * time.Second, rs.BMS_VOLT_AMP_TEMP, payload},
+		// payload changed: restart
+		{2 * time.Second, rs.INV_KEEP_ALIVE, payload},
+		{4*time.Second + 3*time.Millisecond, rs.INV_KEEP_ALIVE, payload},
+	}
+
+	for _, f := range frames {
+		if p, ok := l.Observe(start.Add(f.offset), f.id, f.data); ok {
+			t.Fatalf("l.Observe() learned %v after %v, expect no profile", p, f.offset)
+		}
+	}
+
+	p, ok := l.Observe(start.Add(6*time.Second), rs.INV_KEEP_ALIVE, payload)
+	expect := Profile{LEARNED_PROFILE, rs.INV_KEEP_ALIVE, payload, 2 * time.Second}
+	if !ok || !cmp.Equal(p, expect) {
+		t.Errorf("l.Observe() == %v, %v, expect %v", p, ok, expect)
+	}
+}
+
+func TestSaveLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keepalive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, KEEP_ALIVE_FILE_NAME)
+	expect := Profile{LEARNED_PROFILE, rs.INV_KEEP_ALIVE, []byte{0x01, 0x02}, 2 * time.Second}
+
+	if err := SaveProfile(fileName, expect); err != nil {
+		t.Fatalf("SaveProfile() == %v, expect nil", err)
+	}
+	if p, err := LoadProfile(fileName); err != nil || !cmp.Equal(p, expect) {
+		t.Errorf("LoadProfile() == %v, %v, expect %v", p, err, expect)
+	}
+
+	if _, err := LoadProfile(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("LoadProfile() == %v for a missing file, expect os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keepalive
+
+import (
+	"fmt"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rule actions: hold (no keep-alive while the condition is met), stop (no keep-alive until manual override)
+	ACTION_HOLD string = "hold"
+	ACTION_STOP string = "stop"
+	// rule metrics
+	METRIC_ALARM   string = "alarm"
+	METRIC_WARNING string = "warning"
+	METRIC_TEMP    string = "temp"
+	METRIC_SOC     string = "soc"
+	METRIC_VOLTAGE string = "voltage"
+	// alarm/warning rule matching every alarm/warning bit
+	ANY_ALARM string = "*"
+	// release margins (hysteresis): a matched rule is released if the value is beyond its threshold by the margin
+	TEMP_HYSTERESIS    float32 = 2
+	SOC_HYSTERESIS     float32 = 2
+	VOLTAGE_HYSTERESIS float32 = 0.5
+	// policy states
+	STATE_SENDING string = "sending"
+	STATE_HELD    string = "held"
+	STATE_STOPPED string = "stopped"
+	// manual overrides: auto (policy decides), on (always send), off (never send)
+	OVERRIDE_AUTO string = "auto"
+	OVERRIDE_ON   string = "on"
+	OVERRIDE_OFF  string = "off"
+	// maximal number of decisions kept
+	MAX_DECISIONS int = 100
+)
+
+// Rule contains a single condition of the keep-alive policy. Examples (ParsePolicy format):
+//
+//	soc<10:hold                    SOC below 10 % (released at 12 %)
+//	voltage<47.5:hold              voltage below 47.5 V (released at 48 V)
+//	temp>50:stop                   temperature above 50 C (released at 48 C)
+//	warning=BATTERY_HIGH_TEMP:stop warning bit BATTERY_HIGH_TEMP is set
+//	alarm=*:stop                   any alarm bit is set
+type Rule struct {
+	Metric string
+	// <, > (temp, soc, voltage) or = (alarm, warning)
+	Op    string
+	Value float32
+	// release margin (temp, soc, voltage)
+	Hysteresis float32
+	// warning/alarm bit description (alarm, warning)
+	Name   string
+	Action string
+}
+
+// String returns the rule in ParsePolicy format.
+func (r Rule) String() string {
+	if r.Metric == METRIC_ALARM || r.Metric == METRIC_WARNING {
+		return r.Metric + "=" + r.Name + ":" + r.Action
+	}
+	return r.Metric + r.Op + strconv.FormatFloat(float64(r.Value), 'f', -1, 32) + ":" + r.Action
+}
+
+// matchBits returns true if the bit name (or any bit: ANY_ALARM) is set.
+func matchBits(bits []string, name string) bool {
+	if name == ANY_ALARM {
+		return len(bits) != 0
+	}
+	for _, d := range bits {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the value of the metric of the rule. ok is false if the value has not been received yet.
+func (r Rule) value(lgResu *rs.LgResuStatus) (v float32, ok bool) {
+	switch r.Metric {
+	case METRIC_TEMP:
+		return lgResu.Temp, lgResu.HasReceived(rs.UPDATE_TEMP)
+	case METRIC_SOC:
+		return float32(lgResu.Soc), lgResu.HasReceived(rs.UPDATE_SOC)
+	case METRIC_VOLTAGE:
+		return lgResu.Voltage, lgResu.HasReceived(rs.UPDATE_VOLTAGE)
+	}
+	return 0, false
+}
+
+// Match returns true if the condition of the rule is met.
+func (r Rule) Match(lgResu *rs.LgResuStatus) bool {
+	switch r.Metric {
+	case METRIC_ALARM:
+		return matchBits(lgResu.Alarms, r.Name)
+	case METRIC_WARNING:
+		return matchBits(lgResu.Warnings, r.Name)
+	}
+
+	v, ok := r.value(lgResu)
+	if !ok {
+		return false
+	}
+	if r.Op == "<" {
+		return v < r.Value
+	}
+	return v > r.Value
+}
+
+// Release returns true if a matched rule is no longer met: the value is beyond the threshold by the release
+// margin (for example: soc<10 is released at SOC 12 %) or the warning/alarm bit is cleared.
+func (r Rule) Release(lgResu *rs.LgResuStatus) bool {
+	if r.Metric == METRIC_ALARM || r.Metric == METRIC_WARNING {
+		return !r.Match(lgResu)
+	}
+
+	v, ok := r.value(lgResu)
+	if !ok {
+		return false
+	}
+	if r.Op == "<" {
+		return v >= r.Value+r.Hysteresis
+	}
+	return v <= r.Value-r.Hysteresis
+}
+
+// ParsePolicy parses a comma separated list of rules (for example: soc<10:hold,temp>50:stop).
+func ParsePolicy(s string) ([]Rule, error) {
+	rules := []Rule{}
+	if len(strings.TrimSpace(s)) == 0 {
+		return rules, nil
+	}
+
+	for _, text := range strings.Split(s, ",") {
+		text = strings.TrimSpace(text)
+
+		i := strings.LastIndex(text, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("keepalive: rule %q has no action (%s or %s)", text, ACTION_HOLD, ACTION_STOP)
+		}
+		r := Rule{Action: text[i+1:]}
+		if r.Action != ACTION_HOLD && r.Action != ACTION_STOP {
+			return nil, fmt.Errorf("keepalive: rule %q has invalid action %q", text, r.Action)
+		}
+
+		cond := text[:i]
+		if strings.HasPrefix(cond, METRIC_ALARM+"=") || strings.HasPrefix(cond, METRIC_WARNING+"=") {
+			j := strings.Index(cond, "=")
+			r.Metric, r.Op, r.Name = cond[:j], "=", cond[j+1:]
+			if len(r.Name) == 0 {
+				return nil, fmt.Errorf("keepalive: rule %q has no %s name", text, r.Metric)
+			}
+			rules = append(rules, r)
+			continue
+		}
+
+		j := strings.IndexAny(cond, "<>")
+		if j < 0 {
+			return nil, fmt.Errorf("keepalive: rule %q has invalid condition", text)
+		}
+		r.Metric, r.Op = cond[:j], cond[j:j+1]
+		switch r.Metric {
+		case METRIC_TEMP:
+			r.Hysteresis = TEMP_HYSTERESIS
+		case METRIC_SOC:
+			r.Hysteresis = SOC_HYSTERESIS
+		case METRIC_VOLTAGE:
+			r.Hysteresis = VOLTAGE_HYSTERESIS
+		default:
+			return nil, fmt.Errorf("keepalive: rule %q has unknown metric %q", text, r.Metric)
+		}
+		v, err := strconv.ParseFloat(cond[j+1:], 32)
+		if err != nil {
+			return nil, fmt.Errorf("keepalive: rule %q has invalid value (%v)", text, err)
+		}
+		r.Value = float32(v)
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Decision contains a single decision of the keep-alive policy.
+type Decision struct {
+	Time     time.Time `json:"time"`
+	State    string    `json:"state"`
+	Override string    `json:"override"`
+	Reason   string    `json:"reason"`
+}
+
+// PolicyStatus contains the state of the keep-alive policy and the most recent decisions.
+type PolicyStatus struct {
+	Rules    []string `json:"rules"`
+	Override string   `json:"override"`
+	State    string   `json:"state"`
+	// keep-alive messages are send (the policy and the manual override allow keep-alive messages)
+	Allowed   bool       `json:"allowed"`
+	Decisions []Decision `json:"decisions"`
+}
+
+// Policy decides if keep-alive messages are send based on the battery state (rules) and a manual
+// override. Policy can be used concurrently.
+type Policy struct {
+	mu    sync.Mutex
+	rules []Rule
+	// matched rules (not released yet)
+	matched   []bool
+	override  string
+	state     string
+	decisions []Decision
+}
+
+// NewPolicy is the constructor for Policy.
+func NewPolicy(rules []Rule) *Policy {
+	return &Policy{rules: rules, matched: make([]bool, len(rules)), override: OVERRIDE_AUTO, state: STATE_SENDING}
+}
+
+// decide records a decision (p.mu must be locked).
+func (p *Policy) decide(t time.Time, reason string) {
+	d := Decision{t, p.state, p.override, reason}
+
+	if !p.allow() {
+		log.Warnf("Policy: keep-alive %s (override %s): %s\n", p.state, p.override, reason)
+	} else {
+		log.Infof("Policy: keep-alive %s (override %s): %s\n", p.state, p.override, reason)
+	}
+
+	p.decisions = append(p.decisions, d)
+	if len(p.decisions) > MAX_DECISIONS {
+		p.decisions = p.decisions[len(p.decisions)-MAX_DECISIONS:]
+	}
+}
+
+// Update evaluates the rules after every status update. A matched rule remains matched until it is
+// released (Rule.Release). A stopped keep-alive remains stopped until the manual override is set. Every
+// evaluation is logged (debug level), state changes are recorded as decisions.
+func (p *Policy) Update(t time.Time, lgResu *rs.LgResuStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == STATE_STOPPED {
+		log.Debugf("Policy: keep-alive %s (override %s): waiting for manual override\n", p.state, p.override)
+		return
+	}
+
+	state, reason := STATE_SENDING, "no rule matches"
+	for i, r := range p.rules {
+		if p.matched[i] {
+			p.matched[i] = !r.Release(lgResu)
+		} else {
+			p.matched[i] = r.Match(lgResu)
+		}
+		if !p.matched[i] {
+			continue
+		}
+		if r.Action == ACTION_STOP {
+			state, reason = STATE_STOPPED, "rule "+r.String()
+			break
+		}
+		if state == STATE_SENDING {
+			state, reason = STATE_HELD, "rule "+r.String()
+		}
+	}
+
+	log.Debugf("Policy: keep-alive %s (override %s): %s (soc %d %%, voltage %.2f V, temp %.1f C)\n",
+		state, p.override, reason, lgResu.Soc, lgResu.Voltage, lgResu.Temp)
+
+	if state != p.state {
+		p.state = state
+		p.decide(t, reason)
+	}
+}
+
+// Allow returns true if keep-alive messages should be send.
+func (p *Policy) Allow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.allow()
+}
+
+// allow returns true if keep-alive messages should be send (p.mu must be locked).
+func (p *Policy) allow() bool {
+	switch p.override {
+	case OVERRIDE_ON:
+		return true
+	case OVERRIDE_OFF:
+		return false
+	}
+	return p.state == STATE_SENDING
+}
+
+// SetOverride sets the manual override (auto, on or off). Every override resets a stopped keep-alive
+// (the rules are evaluated again with the next status update).
+func (p *Policy) SetOverride(t time.Time, override string) error {
+	if override != OVERRIDE_AUTO && override != OVERRIDE_ON && override != OVERRIDE_OFF {
+		return fmt.Errorf("keepalive: invalid override %q (expect %s, %s or %s)", override, OVERRIDE_AUTO, OVERRIDE_ON, OVERRIDE_OFF)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.override = override
+	if p.state == STATE_STOPPED {
+		p.state = STATE_SENDING
+		// the rules are matched again with the next status update
+		for i := range p.matched {
+			p.matched[i] = false
+		}
+	}
+	p.decide(t, "manual override "+override)
+	return nil
+}
+
+// Status returns the state of the policy and copies of the rules and decisions.
+func (p *Policy) Status() PolicyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := PolicyStatus{Rules: []string{}, Override: p.override, State: p.state, Allowed: p.allow()}
+	for _, r := range p.rules {
+		s.Rules = append(s.Rules, r.String())
+	}
+	s.Decisions = append([]Decision{}, p.decisions...)
+	return s
+}
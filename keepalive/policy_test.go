@@ -0,0 +1,172 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keepalive
+
+import (
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	"github.com/jens18/lgresu/lithiumate"
+	"testing"
+	"time"
+)
+
+var TestPolicyRules = "soc<10:hold, voltage<47.5:hold,temp>50:stop,warning=BATTERY_HIGH_TEMP:stop,alarm=*:stop"
+
+// metrics received by the tests of the SOC and voltage rules
+const socVoltage = rs.UPDATE_SOC | rs.UPDATE_SOH | rs.UPDATE_VOLTAGE
+
+func TestParsePolicy(t *testing.T) {
+	rules, err := ParsePolicy(TestPolicyRules)
+	expect := []Rule{
+		{METRIC_SOC, "<", 10, SOC_HYSTERESIS, "", ACTION_HOLD},
+		{METRIC_VOLTAGE, "<", 47.5, VOLTAGE_HYSTERESIS, "", ACTION_HOLD},
+		{METRIC_TEMP, ">", 50, TEMP_HYSTERESIS, "", ACTION_STOP},
+		{METRIC_WARNING, "=", 0, 0, "BATTERY_HIGH_TEMP", ACTION_STOP},
+		{METRIC_ALARM, "=", 0, 0, "*", ACTION_STOP},
+	}
+	if err != nil || !cmp.Equal(rules, expect) {
+		t.Errorf("ParsePolicy() == %+v, %v, expect %+v", rules, err, expect)
+	}
+	if rules[1].String() != "voltage<47.5:hold" {
+		t.Errorf("rules[1].String() == %q, expect \"voltage<47.5:hold\"", rules[1].String())
+	}
+
+	for _, s := range []string{"soc<10", "soc<10:pause", "current<5:hold", "soc=10:hold", "soc<x:hold", "alarm=:stop", "warning=:stop"} {
+		if _, err := ParsePolicy(s); err == nil {
+			t.Errorf("ParsePolicy(%q) == nil, expect error", s)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	rules, _ := ParsePolicy(TestPolicyRules)
+	p := NewPolicy(rules)
+	now := time.Now()
+
+	// SOC/SOH and voltage have not been received yet
+	p.Update(now, &rs.LgResuStatus{Temp: 20})
+	if !p.Allow() {
+		t.Errorf("p.Allow() == false before SOC/voltage are received, expect true")
+	}
+
+	// hold below the SOC floor, resume above the SOC floor + SOC_HYSTERESIS
+	p.Update(now, &rs.LgResuStatus{Soc: 9, Soh: 99, Voltage: 49, ReceivedFields: socVoltage})
+	if p.Allow() || p.Status().State != STATE_HELD {
+		t.Errorf("p.Status() == %+v below SOC floor, expect held", p.Status())
+	}
+	for _, soc := range []uint16{10, 11, 9, 10} {
+		p.Update(now, &rs.LgResuStatus{Soc: soc, Soh: 99, Voltage: 49, ReceivedFields: socVoltage})
+		if p.Allow() {
+			t.Errorf("p.Allow() == true at SOC %d %% (within release margin), expect false", soc)
+		}
+	}
+	p.Update(now, &rs.LgResuStatus{Soc: 12, Soh: 99, Voltage: 49, ReceivedFields: socVoltage})
+	if !p.Allow() {
+		t.Errorf("p.Allow() == false above SOC floor + release margin, expect true")
+	}
+	p.Update(now, &rs.LgResuStatus{Soc: 10, Soh: 99, Voltage: 49, ReceivedFields: socVoltage})
+	if !p.Allow() {
+		t.Errorf("p.Allow() == false at SOC floor after release, expect true")
+	}
+
+	// alarm rules do not match warning bits
+	p.Update(now, &rs.LgResuStatus{Soc: 50, Soh: 99, Voltage: 52, ReceivedFields: socVoltage, Warnings: []string{"BATTERY_LOW_TEMP"}})
+	if !p.Allow() {
+		t.Errorf("p.Allow() == false with warning bit and alarm=* rule, expect true")
+	}
+
+	// stop on warning, remains stopped after the warning is cleared
+	p.Update(now, &rs.LgResuStatus{Soc: 50, Soh: 99, Voltage: 52, ReceivedFields: socVoltage, Warnings: []string{"BATTERY_HIGH_TEMP"}})
+	p.Update(now, &rs.LgResuStatus{Soc: 50, Soh: 99, Voltage: 52, ReceivedFields: socVoltage})
+	if p.Allow() || p.Status().State != STATE_STOPPED {
+		t.Errorf("p.Status() == %+v after warning, expect stopped", p.Status())
+	}
+
+	// manual override
+	if err := p.SetOverride(now, OVERRIDE_OFF); err != nil || p.Allow() {
+		t.Errorf("p.SetOverride(off) == %v, p.Allow() == %v, expect nil, false", err, p.Allow())
+	}
+	if err := p.SetOverride(now, OVERRIDE_AUTO); err != nil || !p.Allow() {
+		t.Errorf("p.SetOverride(auto) == %v, p.Allow() == %v, expect nil, true", err, p.Allow())
+	}
+	if err := p.SetOverride(now, "maybe"); err == nil {
+		t.Errorf("p.SetOverride(\"maybe\") == nil, expect error")
+	}
+
+	// every decision is recorded
+	states := []string{}
+	for _, d := range p.Status().Decisions {
+		states = append(states, d.State+"/"+d.Override)
+	}
+	expect := []string{"held/auto", "sending/auto", "stopped/auto", "sending/off", "sending/auto"}
+	if !cmp.Equal(states, expect) {
+		t.Errorf("p.Status().Decisions == %v, expect %v", states, expect)
+	}
+}
+
+// TestPolicyTemp tests that temperature rules are not matched before the temperature has been received.
+func TestPolicyTemp(t *testing.T) {
+	rules, _ := ParsePolicy("temp<5:hold")
+	p := NewPolicy(rules)
+	now := time.Now()
+
+	// Temp is 0 C before the first message with the temperature
+	p.Update(now, &rs.LgResuStatus{Soc: 50, Soh: 99, Voltage: 52, ReceivedFields: socVoltage})
+	if !p.Allow() {
+		t.Errorf("p.Allow() == false before the temperature is received, expect true")
+	}
+
+	p.Update(now, &rs.LgResuStatus{Soc: 50, Soh: 99, Voltage: 52, Temp: 0, ReceivedFields: socVoltage | rs.UPDATE_TEMP})
+	if p.Allow() {
+		t.Errorf("p.Allow() == true at 0 C with rule temp<5:hold, expect false")
+	}
+}
+
+// TestPolicyLithiumate tests the temperature and SOC rules with the metrics decoded by the Lithiumate
+// profile (temperature 0x627, no SOH).
+func TestPolicyLithiumate(t *testing.T) {
+	rules, _ := ParsePolicy("temp>50:stop,soc<10:hold")
+	p := NewPolicy(rules)
+	now := time.Now()
+
+	profile, err := rs.LookupProfile(lithiumate.LITHIUMATE_PROFILE)
+	if err != nil {
+		t.Fatalf("rs.LookupProfile(%q) returned error %v", lithiumate.LITHIUMATE_PROFILE, err)
+	}
+
+	lgResu := &rs.LgResuStatus{}
+	for _, tm := range []struct {
+		id    uint32
+		data  []byte
+		state string
+	}{
+		// SOC 5 %, SOH 0 %
+		{lithiumate.LTM_SOC, []byte{0x05, 0x00, 0x24, 0x00, 0x64, 0x00, 0x00}, STATE_HELD},
+		// average temperature 55 C
+		{lithiumate.LTM_TEMPERATURE, []byte{0x37, 0x00, 0x30, 0x02, 0x3a, 0x09}, STATE_STOPPED},
+	} {
+		u, err := profile.Decode(tm.id, tm.data)
+		if err != nil {
+			t.Fatalf("profile.Decode(%x, % X) returned error %v", tm.id, tm.data, err)
+		}
+		u.Apply(lgResu)
+		lgResu.ReceivedFields |= u.Fields
+
+		p.Update(now, lgResu)
+		if p.Status().State != tm.state {
+			t.Errorf("p.Status() == %+v after message %x, expect %s", p.Status(), tm.id, tm.state)
+		}
+	}
+}
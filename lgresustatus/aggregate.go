@@ -0,0 +1,114 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"sort"
+)
+
+// Aggregate combines the status of several batteries connected in parallel into the status of a single
+// (virtual) battery:
+//
+//   - Current, Power, MaxChargeCurrent, MaxDischargeCurrent and the energy counters are summed.
+//   - Soc and EstimatedSoc are weighted by the capacity of the batteries (NOMINAL_CAPACITY * Soh), Soh is
+//     the average SOH.
+//   - Voltage is the average voltage, Temp the highest temperature and MaxVoltage the lowest voltage limit.
+//   - Warnings, alarms and the received metrics (ReceivedFields) of all batteries are combined.
+//
+// Offline batteries only contribute their energy counters, the aggregate is offline if all batteries
+// are offline. Battery specific fields (model, serial number, message statistics, time remaining) are
+// not aggregated. The status of a single battery is returned unchanged.
+func Aggregate(batteries []LgResuStatus) LgResuStatus {
+	if len(batteries) == 1 {
+		return batteries[0]
+	}
+
+	a := LgResuStatus{Offline: true}
+	var capacity, soc, soh, estimatedSoc float64
+	warnings, alarms := map[string]bool{}, map[string]bool{}
+	online := 0
+
+	for _, b := range batteries {
+		a.Energy.ChargedToday += b.Energy.ChargedToday
+		a.Energy.DischargedToday += b.Energy.DischargedToday
+		a.Energy.ChargedMonth += b.Energy.ChargedMonth
+		a.Energy.DischargedMonth += b.Energy.DischargedMonth
+		a.Energy.ChargedLifetime += b.Energy.ChargedLifetime
+		a.Energy.DischargedLifetime += b.Energy.DischargedLifetime
+		a.DecodeErrors += b.DecodeErrors
+		a.UnknownMessages += b.UnknownMessages
+
+		if b.Offline {
+			continue
+		}
+		online++
+		a.Offline = false
+		a.ReceivedFields |= b.ReceivedFields
+
+		a.Current += b.Current
+		a.Power += b.Power
+		a.MaxChargeCurrent += b.MaxChargeCurrent
+		a.MaxDischargeCurrent += b.MaxDischargeCurrent
+		a.Voltage += b.Voltage
+		if online == 1 || b.Temp > a.Temp {
+			a.Temp = b.Temp
+		}
+		if online == 1 || b.MaxVoltage < a.MaxVoltage {
+			a.MaxVoltage = b.MaxVoltage
+		}
+
+		c := float64(NOMINAL_CAPACITY) * float64(b.Soh) / 100
+		capacity += c
+		soc += float64(b.Soc) * c
+		estimatedSoc += float64(b.EstimatedSoc) * c
+		soh += float64(b.Soh)
+
+		for _, w := range b.Warnings {
+			warnings[w] = true
+		}
+		for _, al := range b.Alarms {
+			alarms[al] = true
+		}
+	}
+
+	if online == 0 {
+		return a
+	}
+
+	a.Voltage /= float32(online)
+	a.Soh = uint16(soh/float64(online) + 0.5)
+	if capacity > 0 {
+		a.Soc = uint16(soc/capacity + 0.5)
+		a.EstimatedSoc = float32(estimatedSoc / capacity)
+		a.SocDivergence = a.EstimatedSoc - float32(a.Soc)
+	}
+	a.Warnings = sortedKeys(warnings)
+	a.Alarms = sortedKeys(alarms)
+
+	return a
+}
+
+// sortedKeys returns the keys of m in alphabetical order (nil if m is empty).
+func sortedKeys(m map[string]bool) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
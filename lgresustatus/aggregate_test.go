@@ -0,0 +1,55 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+var AggregateTestBatteries = []LgResuStatus{
+	{Soc: 80, Soh: 100, Voltage: 54.0, Current: -10, Power: -540, Temp: 21.5, MaxVoltage: 57.7,
+		MaxChargeCurrent: 90, MaxDischargeCurrent: 90, EstimatedSoc: 80,
+		Energy: EnergyCounters{ChargedToday: 1000, DischargedToday: 500}, Warnings: []string{"BATTERY_HIGH_TEMP"}},
+	// the capacity of the second battery is 50 % (SOC has half the weight)
+	{Soc: 50, Soh: 50, Voltage: 53.8, Current: -5, Power: -269, Temp: 23.0, MaxVoltage: 57.5,
+		MaxChargeCurrent: 45, MaxDischargeCurrent: 45, EstimatedSoc: 53,
+		Energy: EnergyCounters{ChargedToday: 800, DischargedToday: 400}, Warnings: []string{"CELL_IMBALANCE", "BATTERY_HIGH_TEMP"}},
+	// offline battery only contributes energy counters
+	{Soc: 10, Soh: 100, Voltage: 48, Current: 20, Offline: true, Energy: EnergyCounters{ChargedToday: 200}},
+}
+
+func TestAggregate(t *testing.T) {
+	a := Aggregate(AggregateTestBatteries)
+
+	expect := LgResuStatus{Soc: 70, Soh: 75, Voltage: 53.9, Current: -15, Power: -809, Temp: 23.0, MaxVoltage: 57.5,
+		MaxChargeCurrent: 135, MaxDischargeCurrent: 135, EstimatedSoc: 71, SocDivergence: 1,
+		Energy:   EnergyCounters{ChargedToday: 2000, DischargedToday: 900},
+		Warnings: []string{"BATTERY_HIGH_TEMP", "CELL_IMBALANCE"}}
+
+	if !cmp.Equal(a, expect) {
+		t.Errorf("Aggregate() == %+v, expect %+v", a, expect)
+	}
+
+	// single battery is unchanged
+	if a := Aggregate(AggregateTestBatteries[2:]); !cmp.Equal(a, AggregateTestBatteries[2]) {
+		t.Errorf("Aggregate() == %+v for a single battery, expect %+v", a, AggregateTestBatteries[2])
+	}
+
+	// all batteries offline
+	if a := Aggregate([]LgResuStatus{AggregateTestBatteries[2], AggregateTestBatteries[2]}); !a.Offline || a.Energy.ChargedToday != 400 {
+		t.Errorf("Aggregate() == %+v for offline batteries, expect offline", a)
+	}
+}
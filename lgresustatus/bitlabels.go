@@ -0,0 +1,143 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bitsMu guards WarningBitValues and AlarmBitValues. The definitions are never modified in place,
+// RelabelBits replaces them with relabeled copies.
+var bitsMu sync.RWMutex
+
+// bitDefinitions returns the current warning and alarm bit definitions.
+func bitDefinitions() (warnings []BitValue, alarms []BitValue) {
+	bitsMu.RLock()
+	defer bitsMu.RUnlock()
+
+	return WarningBitValues, AlarmBitValues
+}
+
+// bitValues returns the warning or alarm bit definitions for eventType.
+func bitValues(eventType string) ([]BitValue, error) {
+	warnings, alarms := bitDefinitions()
+
+	switch eventType {
+	case EVENT_WARNING:
+		return warnings, nil
+	case EVENT_ALARM:
+		return alarms, nil
+	}
+	return nil, fmt.Errorf("unknown bit type %q (expect %s or %s)", eventType, EVENT_WARNING, EVENT_ALARM)
+}
+
+// BitMask returns the bitmask of the warning (eventType EVENT_WARNING) or alarm (eventType EVENT_ALARM)
+// bit with the given description. ok is false if no bit with this description exists.
+func BitMask(eventType string, description string) (mask uint16, ok bool) {
+	bvs, err := bitValues(eventType)
+	if err != nil {
+		return 0, false
+	}
+	for _, bv := range bvs {
+		if bv.Description == description {
+			return bv.Value, true
+		}
+	}
+	return 0, false
+}
+
+// RelabelBits replaces the descriptions of warning/alarm bits with the labels read from r.
+//
+// Every line contains the bit type (warning or alarm), the bitmask and the new description as CSV values.
+// Lines starting with '#' are ignored. Example:
+//
+//  # type,mask,description
+//  warning,0x0020,BATTERY_LOW_SOC
+//  alarm,0x0001,BATTERY_OVER_VOLTAGE
+//
+// The labels are applied only if the whole file is valid (an invalid line leaves all descriptions
+// unchanged). RelabelBits can be called while messages are decoded.
+func RelabelBits(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	// relabel copies of the current definitions
+	bitsMu.Lock()
+	defer bitsMu.Unlock()
+
+	warnings := append([]BitValue{}, WarningBitValues...)
+	alarms := append([]BitValue{}, AlarmBitValues...)
+
+	for _, record := range records {
+		var bvs []BitValue
+		switch record[0] {
+		case EVENT_WARNING:
+			bvs = warnings
+		case EVENT_ALARM:
+			bvs = alarms
+		default:
+			return fmt.Errorf("unknown bit type %q (expect %s or %s)", record[0], EVENT_WARNING, EVENT_ALARM)
+		}
+
+		mask, err := strconv.ParseUint(record[1], 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid %s bitmask %q: %v", record[0], record[1], err)
+		}
+
+		description := strings.TrimSpace(record[2])
+		if description == "" {
+			return fmt.Errorf("empty description for %s bitmask %#04x", record[0], mask)
+		}
+
+		found := false
+		for i := range bvs {
+			if bvs[i].Value == uint16(mask) {
+				bvs[i].Description = description
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no %s bit with bitmask %#04x", record[0], mask)
+		}
+	}
+
+	WarningBitValues, AlarmBitValues = warnings, alarms
+	return nil
+}
+
+// LoadBitLabels replaces the descriptions of warning/alarm bits with the labels defined in
+// the file fileName (see RelabelBits).
+func LoadBitLabels(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return RelabelBits(f)
+}
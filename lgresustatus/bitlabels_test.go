@@ -0,0 +1,101 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// restoreBitValues restores the original warning/alarm bit definitions after a relabel test.
+func restoreBitValues() func() {
+	warnings, alarms := bitDefinitions()
+
+	return func() {
+		bitsMu.Lock()
+		defer bitsMu.Unlock()
+		WarningBitValues, AlarmBitValues = warnings, alarms
+	}
+}
+
+func TestRelabelBits(t *testing.T) {
+	defer restoreBitValues()()
+
+	labels := `# type,mask,description
+warning,0x0020,BATTERY_LOW_SOC
+alarm, 0x0400, BATTERY_OVER_VOLTAGE
+`
+	if err := RelabelBits(strings.NewReader(labels)); err != nil {
+		t.Fatalf("RelabelBits() == %v, expect nil", err)
+	}
+
+	u, _ := Decode(BMS_WARN_ALARM, []byte{0x21, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00})
+
+	if !cmp.Equal(u.Status.Warnings, []string{"WRN_ONLY_SUB_RELAY_COMMAND", "BATTERY_LOW_SOC"}) ||
+		!cmp.Equal(u.Status.Alarms, []string{"BATTERY_OVER_VOLTAGE"}) {
+		t.Errorf("Decode() == %v, %v after RelabelBits()", u.Status.Warnings, u.Status.Alarms)
+	}
+
+	if mask, ok := BitMask(EVENT_ALARM, "BATTERY_OVER_VOLTAGE"); !ok || mask != 0x0400 {
+		t.Errorf("BitMask() == %#04x, %v, expect 0x0400, true", mask, ok)
+	}
+}
+
+func TestRelabelBitsErrors(t *testing.T) {
+	defer restoreBitValues()()
+
+	for _, labels := range []string{
+		"fault,0x0001,A\n",
+		"warning,0x0003,A\n",
+		"warning,0x10000,A\n",
+		"alarm,0x0001,\n",
+		"alarm,0x0001\n",
+	} {
+		if err := RelabelBits(strings.NewReader(labels)); err == nil {
+			t.Errorf("RelabelBits(%q) == nil, expect error", labels)
+		}
+	}
+
+	// a bad line leaves all labels unchanged
+	if err := RelabelBits(strings.NewReader("warning,0x0020,BATTERY_LOW_SOC\nwarning,0x0003,A\n")); err == nil {
+		t.Errorf("RelabelBits() == nil for a bad second line, expect error")
+	}
+	if _, ok := BitMask(EVENT_WARNING, "BATTERY_LOW_SOC"); ok {
+		t.Errorf("RelabelBits() applied the first line of an invalid file")
+	}
+}
+
+// TestRelabelBitsConcurrent tests relabeling while messages are decoded (go test -race).
+func TestRelabelBitsConcurrent(t *testing.T) {
+	defer restoreBitValues()()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			Decode(BMS_WARN_ALARM, []byte{0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if err := RelabelBits(strings.NewReader("warning,0x0020,BATTERY_LOW_SOC\n")); err != nil {
+			t.Fatalf("RelabelBits() == %v, expect nil", err)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,315 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DBC (Vector CANdb++) message definitions.
+//
+// Only the message (BO_) and signal (SG_) definitions are used, all other
+// sections (comments, attributes, value tables) are ignored. Multiplexed
+// signals are not supported.
+//
+// Example:
+//
+//  BO_ 854 BMS_VOLT_AMP_TEMP: 8 BMS
+//   SG_ Voltage : 0|16@1+ (0.01,0) [0|655.35] "V" INV
+//   SG_ Current : 16|16@1- (0.1,0) [-3276.8|3276.7] "A" INV
+//
+// Message id's with bit 31 set are extended (29 bit) message id's.
+const DBC_EXTENDED_ID uint32 = 0x80000000
+
+// Maximal length of a line of a DBC file (value tables and comments of vendor DBC files
+// are longer than the default bufio.Scanner limit of 64 KiB).
+const DBC_MAX_LINE_LENGTH int = 16 * 1024 * 1024
+
+// DbcSignal contains the definition of a single signal.
+type DbcSignal struct {
+	Name string
+	// start bit (Intel: least significant bit, Motorola: most significant bit)
+	StartBit uint
+	Length   uint
+	// Intel (@1) or Motorola (@0) byte order
+	LittleEndian bool
+	Signed       bool
+	Factor       float64
+	Offset       float64
+	Min          float64
+	Max          float64
+	Unit         string
+	Receivers    []string
+}
+
+// DbcMessage contains the definition of a single message and its signals.
+type DbcMessage struct {
+	Id      uint32
+	Name    string
+	Length  uint8
+	Sender  string
+	Signals []DbcSignal
+}
+
+// Dbc contains all message definitions of a DBC file.
+type Dbc struct {
+	Version  string
+	Nodes    []string
+	Messages []DbcMessage
+}
+
+var (
+	dbcVersion = regexp.MustCompile(`^VERSION\s+"([^"]*)"`)
+	dbcNodes   = regexp.MustCompile(`^BU_\s*:(.*)$`)
+	dbcMessage = regexp.MustCompile(`^BO_\s+(\d+)\s+(\w+)\s*:\s*(\d+)\s+(\w+)`)
+	dbcSignal  = regexp.MustCompile(`^SG_\s+(\w+)\s*(\w*)\s*:\s*(\d+)\|(\d+)@([01])([+-])\s*` +
+		`\(([^,]+),([^)]+)\)\s*\[([^|]+)\|([^\]]+)\]\s*"([^"]*)"\s*(.*)$`)
+)
+
+// ParseDbc reads the message definitions of a DBC file from r.
+func ParseDbc(r io.Reader) (*Dbc, error) {
+	dbc := &Dbc{}
+	var msg *DbcMessage
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), DBC_MAX_LINE_LENGTH)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case dbcVersion.MatchString(line):
+			dbc.Version = dbcVersion.FindStringSubmatch(line)[1]
+
+		case dbcNodes.MatchString(line):
+			dbc.Nodes = strings.Fields(dbcNodes.FindStringSubmatch(line)[1])
+
+		case strings.HasPrefix(line, "BO_ "):
+			m := dbcMessage.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("dbc line %d: invalid message definition %q", n, line)
+			}
+			id, _ := strconv.ParseUint(m[1], 10, 32)
+			length, err := strconv.ParseUint(m[3], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("dbc line %d: invalid message length %q", n, m[3])
+			}
+			dbc.Messages = append(dbc.Messages, DbcMessage{Id: uint32(id), Name: m[2], Length: uint8(length), Sender: m[4]})
+			msg = &dbc.Messages[len(dbc.Messages)-1]
+
+		case strings.HasPrefix(line, "SG_ "):
+			if msg == nil {
+				return nil, fmt.Errorf("dbc line %d: signal definition without message definition", n)
+			}
+			sig, err := parseDbcSignal(line)
+			if err != nil {
+				return nil, fmt.Errorf("dbc line %d: %v", n, err)
+			}
+			msg.Signals = append(msg.Signals, sig)
+
+		case line == "":
+			// an empty line terminates the signal definitions of a message
+			msg = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return dbc, nil
+}
+
+// parseDbcSignal parses a single signal (SG_) definition.
+func parseDbcSignal(line string) (sig DbcSignal, err error) {
+	m := dbcSignal.FindStringSubmatch(line)
+	if m == nil {
+		return sig, fmt.Errorf("invalid signal definition %q", line)
+	}
+	if m[2] != "" {
+		return sig, fmt.Errorf("multiplexed signal %s is not supported", m[1])
+	}
+
+	sig.Name = m[1]
+	startBit, _ := strconv.ParseUint(m[3], 10, 16)
+	length, _ := strconv.ParseUint(m[4], 10, 16)
+	sig.StartBit, sig.Length = uint(startBit), uint(length)
+	sig.LittleEndian = m[5] == "1"
+	sig.Signed = m[6] == "-"
+
+	if sig.Length == 0 || sig.Length > 64 {
+		return sig, fmt.Errorf("invalid length %d of signal %s", sig.Length, sig.Name)
+	}
+
+	for i, f := range []*float64{&sig.Factor, &sig.Offset, &sig.Min, &sig.Max} {
+		if *f, err = strconv.ParseFloat(strings.TrimSpace(m[7+i]), 64); err != nil {
+			return sig, fmt.Errorf("invalid value %q of signal %s", m[7+i], sig.Name)
+		}
+	}
+
+	sig.Unit = m[11]
+	for _, r := range strings.Split(m[12], ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			sig.Receivers = append(sig.Receivers, r)
+		}
+	}
+	return sig, nil
+}
+
+// LoadDbc reads the message definitions of the DBC file fileName.
+func LoadDbc(fileName string) (*Dbc, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseDbc(f)
+}
+
+// Message returns the definition of message id. id may carry the extended message id flag
+// (bit 31, SocketCAN CAN_EFF_FLAG).
+func (dbc *Dbc) Message(id uint32) (*DbcMessage, bool) {
+	for i := range dbc.Messages {
+		if dbc.Messages[i].Id&^DBC_EXTENDED_ID == id&^DBC_EXTENDED_ID {
+			return &dbc.Messages[i], true
+		}
+	}
+	return nil, false
+}
+
+// Decode decodes all signals of message id into named values. Decode returns an
+// UnknownMessageError for message id's without definition and a MessageLengthError
+// if s is shorter than the signals of the message.
+func (dbc *Dbc) Decode(id uint32, s []byte) (map[string]float64, error) {
+	msg, ok := dbc.Message(id)
+	if !ok {
+		return nil, &UnknownMessageError{Id: id}
+	}
+	return msg.Decode(s)
+}
+
+// Decode decodes all signals of the message into named values.
+func (msg *DbcMessage) Decode(s []byte) (map[string]float64, error) {
+	values := make(map[string]float64, len(msg.Signals))
+
+	for _, sig := range msg.Signals {
+		if err := CheckLength(msg.Id&^DBC_EXTENDED_ID, s, sig.minLength()); err != nil {
+			return nil, err
+		}
+		values[sig.Name] = sig.Decode(s)
+	}
+	return values, nil
+}
+
+// bit returns bit n of s (bit 0 is the least significant bit of s[0]).
+func bit(s []byte, n uint) uint64 {
+	return uint64(s[n/8]>>(n%8)) & 1
+}
+
+// minLength returns the number of bytes required to decode the signal.
+func (sig *DbcSignal) minLength() int {
+	if sig.LittleEndian {
+		return int((sig.StartBit+sig.Length-1)/8) + 1
+	}
+	// Motorola: the signal continues with the following bytes
+	return int(sig.StartBit/8+(sig.Length+7-sig.StartBit%8-1)/8) + 1
+}
+
+// Raw returns the raw (unscaled) value of the signal in s.
+func (sig *DbcSignal) Raw(s []byte) (raw uint64) {
+	if sig.LittleEndian {
+		for i := uint(0); i < sig.Length; i++ {
+			raw |= bit(s, sig.StartBit+i) << i
+		}
+		return raw
+	}
+
+	// Motorola: start with the most significant bit, continue with the most
+	// significant bit of the next byte at the end of a byte
+	n := sig.StartBit
+	for i := uint(0); i < sig.Length; i++ {
+		raw = raw<<1 | bit(s, n)
+		if n%8 == 0 {
+			n += 15
+		} else {
+			n--
+		}
+	}
+	return raw
+}
+
+// Decode returns the scaled value of the signal in s.
+func (sig *DbcSignal) Decode(s []byte) float64 {
+	raw := sig.Raw(s)
+
+	if sig.Signed && sig.Length < 64 && raw&(1<<(sig.Length-1)) != 0 {
+		// sign extension
+		return float64(int64(raw|^uint64(0)<<sig.Length))*sig.Factor + sig.Offset
+	}
+	if sig.Signed {
+		return float64(int64(raw))*sig.Factor + sig.Offset
+	}
+	return float64(raw)*sig.Factor + sig.Offset
+}
+
+// formatFloat formats f with the minimal number of digits.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteDbc writes the message definitions in DBC format to w (messages sorted by message id).
+func (dbc *Dbc) WriteDbc(w io.Writer) error {
+	msgs := make([]DbcMessage, len(dbc.Messages))
+	copy(msgs, dbc.Messages)
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Id < msgs[j].Id })
+
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "VERSION \"%s\"\n\n", dbc.Version)
+	fmt.Fprintf(b, "NS_ :\n\n")
+	fmt.Fprintf(b, "BS_:\n\n")
+	fmt.Fprintf(b, "BU_: %s\n\n", strings.Join(dbc.Nodes, " "))
+
+	for _, msg := range msgs {
+		fmt.Fprintf(b, "BO_ %d %s: %d %s\n", msg.Id, msg.Name, msg.Length, msg.Sender)
+
+		for _, sig := range msg.Signals {
+			byteOrder, sign := "0", "+"
+			if sig.LittleEndian {
+				byteOrder = "1"
+			}
+			if sig.Signed {
+				sign = "-"
+			}
+			receivers := strings.Join(sig.Receivers, ",")
+			if receivers == "" {
+				receivers = "Vector__XXX"
+			}
+			fmt.Fprintf(b, " SG_ %s : %d|%d@%s%s (%s,%s) [%s|%s] \"%s\" %s\n",
+				sig.Name, sig.StartBit, sig.Length, byteOrder, sign,
+				formatFloat(sig.Factor), formatFloat(sig.Offset), formatFloat(sig.Min), formatFloat(sig.Max),
+				sig.Unit, receivers)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
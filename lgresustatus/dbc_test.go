@@ -0,0 +1,131 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+)
+
+var DbcTestFile string = `VERSION "1.0"
+
+BU_: BMS INV
+
+BO_ 2147485234 BMS_EXT: 4 BMS
+ SG_ Temp : 7|16@0- (0.1,0) [-3276.8|3276.7] "degC" INV
+ SG_ Nibbles : 19|12@0+ (1,0) [0|4095] "" INV,LOG
+
+CM_ SG_ 2147485234 Temp "Motorola byte order";
+`
+
+// TestParseDbcLongLine tests a DBC file with a comment longer than 64 KiB.
+func TestParseDbcLongLine(t *testing.T) {
+	dbcFile := DbcTestFile + `CM_ BO_ 2147485234 "` + strings.Repeat("x", 100*1024) + `";
+BO_ 854 BMS_VOLT_AMP_TEMP: 8 BMS
+ SG_ Voltage : 0|16@1+ (0.01,0) [0|655.35] "V" INV
+`
+	dbc, err := ParseDbc(strings.NewReader(dbcFile))
+	if err != nil || len(dbc.Messages) != 2 {
+		t.Fatalf("ParseDbc() == %+v, %v, expect 2 messages", dbc, err)
+	}
+}
+
+// TestParseDbc tests decoding of Motorola (big endian) signals and extended message id's.
+func TestParseDbc(t *testing.T) {
+	dbc, err := ParseDbc(strings.NewReader(DbcTestFile))
+	if err != nil {
+		t.Fatalf("ParseDbc() == %v, expect nil", err)
+	}
+
+	if dbc.Version != "1.0" || !cmp.Equal(dbc.Nodes, []string{"BMS", "INV"}) || len(dbc.Messages) != 1 {
+		t.Fatalf("ParseDbc() == %+v", dbc)
+	}
+
+	values, err := dbc.Decode(0x632, []byte{0xff, 0x38, 0x0a, 0xbc})
+	if err != nil || !cmp.Equal(values, map[string]float64{"Temp": -20, "Nibbles": 0xabc}) {
+		t.Errorf("dbc.Decode() == %v, %v, expect map[Nibbles:2748 Temp:-20], nil", values, err)
+	}
+
+	// extended message id flag set (SocketCAN CAN_EFF_FLAG)
+	values, err = dbc.Decode(0x632|DBC_EXTENDED_ID, []byte{0xff, 0x38, 0x0a, 0xbc})
+	if err != nil || !cmp.Equal(values, map[string]float64{"Temp": -20, "Nibbles": 0xabc}) {
+		t.Errorf("dbc.Decode(%x) == %v, %v, expect map[Nibbles:2748 Temp:-20], nil", 0x632|DBC_EXTENDED_ID, values, err)
+	}
+
+	if _, err := dbc.Decode(0x632, []byte{0xff, 0x38, 0x0a}); err == nil {
+		t.Errorf("dbc.Decode() of a truncated message == nil, expect MessageLengthError")
+	}
+	if _, err := dbc.Decode(0x633, []byte{}); err == nil {
+		t.Errorf("dbc.Decode() of an unknown message == nil, expect UnknownMessageError")
+	}
+}
+
+func TestParseDbcErrors(t *testing.T) {
+	for _, f := range []string{
+		" SG_ Soc : 0|16@1+ (1,0) [0|100] \"%\" INV\n",
+		"BO_ 853 BMS_SOC_SOH: 8 BMS\n SG_ Soc m1 : 0|16@1+ (1,0) [0|100] \"%\" INV\n",
+		"BO_ 853 BMS_SOC_SOH: 8 BMS\n SG_ Soc : 0|0@1+ (1,0) [0|100] \"%\" INV\n",
+		"BO_ 853 BMS_SOC_SOH: 8 BMS\n SG_ Soc : 0|16@1+ (x,0) [0|100] \"%\" INV\n",
+	} {
+		if _, err := ParseDbc(strings.NewReader(f)); err == nil {
+			t.Errorf("ParseDbc(%q) == nil, expect error", f)
+		}
+	}
+}
+
+// TestLgResuDbcRoundTrip tests that the exported LG Resu 10 LV message definitions can be parsed again.
+func TestLgResuDbcRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := LgResuDbc().WriteDbc(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dbc, err := ParseDbc(buf)
+	if err != nil {
+		t.Fatalf("ParseDbc(LgResuDbc()) == %v, expect nil", err)
+	}
+
+	if !cmp.Equal(dbc, LgResuDbc()) {
+		t.Errorf("ParseDbc(LgResuDbc()) == %+v, expect %+v", dbc, LgResuDbc())
+	}
+}
+
+// TestDbcDecoder tests that the LG Resu 10 LV message definitions produce the same result as Decode().
+func TestDbcDecoder(t *testing.T) {
+	decoder := NewDbcDecoder(LgResuDbc())
+	lgResu := &LgResuStatus{}
+
+	for _, tm := range CanbusTestMessages {
+		u, err := decoder.Decode(tm.Identifier, tm.Data[:])
+		if err != nil {
+			t.Fatalf("decoder.Decode(%#04x) == %v, expect nil", tm.Identifier, err)
+		}
+		u.Apply(lgResu)
+	}
+
+	// identity, model, serial number, firmware/hardware version are not mapped
+	expect := CanbusTestMessages[len(CanbusTestMessages)-1].Expect
+	expect.Identity, expect.Model, expect.SerialNum, expect.FirmwareVersion, expect.HardwareVersion = "", "", 0, "", 0
+
+	if !cmp.Equal(*lgResu, expect) {
+		t.Errorf("decoder.Decode() == %+v, expect %+v", *lgResu, expect)
+	}
+
+	if _, err := decoder.Decode(0x123, []byte{}); err == nil {
+		t.Errorf("decoder.Decode(0x123) == nil, expect UnknownMessageError")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"strings"
+)
+
+// Prefix of single bit signals that are decoded as warnings/alarms.
+const (
+	DBC_WARNING_PREFIX string = "WARN_"
+	DBC_ALARM_PREFIX   string = "ALARM_"
+	DBC_REQUEST_PREFIX string = "REQ_"
+)
+
+// bitSignals returns a single bit signal (starting at offset) for every bit definition.
+func bitSignals(prefix string, bitValues []BitValue, offset uint) []DbcSignal {
+	signals := []DbcSignal{}
+	for _, bv := range bitValues {
+		for n := uint(0); n < 16; n++ {
+			if bv.Value == 1<<n {
+				signals = append(signals, DbcSignal{Name: prefix + bv.Description, StartBit: offset + n, Length: 1,
+					LittleEndian: true, Factor: 1, Max: 1, Receivers: []string{"INV"}})
+			}
+		}
+	}
+	return signals
+}
+
+// LgResuDbc returns the built-in LG Resu 10 LV message definitions (see Decode()). Warning/alarm bits
+// and charge/discharge request bits are single bit signals with the prefix WARN_/ALARM_/REQ_. Model and
+// firmware version (0x354) are the raw codes, the manufacturer name (0x35E) has no signals.
+func LgResuDbc() *Dbc {
+	inv := []string{"INV"}
+	warnings, alarms := bitDefinitions()
+
+	return &Dbc{
+		Nodes: []string{"BMS", "INV"},
+		Messages: []DbcMessage{
+			{Id: INV_KEEP_ALIVE, Name: "INV_KEEP_ALIVE", Length: 8, Sender: "INV"},
+			{Id: BMS_LIMITS, Name: "BMS_LIMITS", Length: 8, Sender: "BMS", Signals: []DbcSignal{
+				{"MaxVoltage", 0, 16, true, false, 0.1, 0, 0, 6553.5, "V", inv},
+				{"MaxChargeCurrent", 16, 16, true, false, 0.1, 0, 0, 6553.5, "A", inv},
+				{"MaxDischargeCurrent", 32, 16, true, false, 0.1, 0, 0, 6553.5, "A", inv},
+			}},
+			{Id: BMS_SERIAL_NUM, Name: "BMS_SERIAL_NUM", Length: 8, Sender: "BMS", Signals: []DbcSignal{
+				{"Model", 0, 8, true, false, 1, 0, 0, 255, "", inv},
+				{"SerialNum", 8, 16, true, false, 1, 0, 0, 65535, "", inv},
+				{"FirmwareVersion", 24, 8, true, false, 1, 0, 0, 255, "", inv},
+				{"HardwareVersion", 32, 8, true, false, 1, 0, 0, 255, "", inv},
+			}},
+			{Id: BMS_SOC_SOH, Name: "BMS_SOC_SOH", Length: 8, Sender: "BMS", Signals: []DbcSignal{
+				{"Soc", 0, 16, true, false, 1, 0, 0, 100, "%", inv},
+				{"Soh", 16, 16, true, false, 1, 0, 0, 100, "%", inv},
+			}},
+			{Id: BMS_VOLT_AMP_TEMP, Name: "BMS_VOLT_AMP_TEMP", Length: 8, Sender: "BMS", Signals: []DbcSignal{
+				{"Voltage", 0, 16, true, false, 0.01, 0, 0, 655.35, "V", inv},
+				{"Current", 16, 16, true, true, 0.1, 0, -3276.8, 3276.7, "A", inv},
+				{"Temp", 32, 16, true, true, 0.1, 0, -3276.8, 3276.7, "degC", inv},
+			}},
+			{Id: BMS_WARN_ALARM, Name: "BMS_WARN_ALARM", Length: 8, Sender: "BMS",
+				Signals: append(bitSignals(DBC_WARNING_PREFIX, warnings, 0),
+					bitSignals(DBC_ALARM_PREFIX, alarms, 16)...)},
+			{Id: BMS_REQUEST, Name: "BMS_REQUEST", Length: 8, Sender: "BMS",
+				Signals: bitSignals(DBC_REQUEST_PREFIX, RequestBitValues, 0)},
+			{Id: BMS_MANUFACTURER, Name: "BMS_MANUFACTURER", Length: 8, Sender: "BMS"},
+		},
+	}
+}
+
+// DbcDecoder decodes messages with the message definitions of a DBC file. Signals are
+// mapped to LgResuStatus by name: Soc, Soh, Voltage, Current, Temp, MaxVoltage,
+// MaxChargeCurrent and MaxDischargeCurrent. Signals with the prefix WARN_/ALARM_/REQ_ are
+// warnings/alarms/charge requests (active if the signal value is not 0). All other signals are ignored.
+//
+// DbcDecoder does not decode the identity (Identity, Model, SerialNum, FirmwareVersion,
+// HardwareVersion) and the manufacturer name, these fields remain empty.
+type DbcDecoder struct {
+	dbc *Dbc
+}
+
+// NewDbcDecoder is the constructor for DbcDecoder.
+func NewDbcDecoder(dbc *Dbc) *DbcDecoder {
+	return &DbcDecoder{dbc}
+}
+
+// Decode decodes a single message with the DBC message definitions. Decode returns an
+// UnknownMessageError for message id's without definition and a MessageLengthError
+// for messages that are too short.
+func (d *DbcDecoder) Decode(id uint32, s []byte) (Update, error) {
+	u := Update{Id: id}
+	lgResu := &u.Status
+
+	msg, ok := d.dbc.Message(id)
+	if !ok {
+		return u, &UnknownMessageError{Id: id}
+	}
+
+	values, err := msg.Decode(s)
+	if err != nil {
+		return u, err
+	}
+
+	for _, sig := range msg.Signals {
+		v := values[sig.Name]
+
+		switch sig.Name {
+		case "Soc":
+			lgResu.Soc, u.Fields = uint16(v), u.Fields|UPDATE_SOC
+		case "Soh":
+			lgResu.Soh, u.Fields = uint16(v), u.Fields|UPDATE_SOH
+		case "Voltage":
+			lgResu.Voltage, u.Fields = float32(v), u.Fields|UPDATE_VOLTAGE
+		case "Current":
+			lgResu.Current, u.Fields = float32(v), u.Fields|UPDATE_CURRENT
+		case "Temp":
+			lgResu.Temp, u.Fields = float32(v), u.Fields|UPDATE_TEMP
+		case "MaxVoltage":
+			lgResu.MaxVoltage, u.Fields = float32(v), u.Fields|UPDATE_MAX_VOLTAGE
+		case "MaxChargeCurrent":
+			lgResu.MaxChargeCurrent, u.Fields = float32(v), u.Fields|UPDATE_MAX_CHARGE_CURRENT
+		case "MaxDischargeCurrent":
+			lgResu.MaxDischargeCurrent, u.Fields = float32(v), u.Fields|UPDATE_MAX_DISCHARGE_CURRENT
+		default:
+			if strings.HasPrefix(sig.Name, DBC_WARNING_PREFIX) {
+				u.Fields |= UPDATE_WARNINGS
+				if v != 0 {
+					lgResu.Warnings = append(lgResu.Warnings, strings.TrimPrefix(sig.Name, DBC_WARNING_PREFIX))
+				}
+			}
+			if strings.HasPrefix(sig.Name, DBC_ALARM_PREFIX) {
+				u.Fields |= UPDATE_ALARMS
+				if v != 0 {
+					lgResu.Alarms = append(lgResu.Alarms, strings.TrimPrefix(sig.Name, DBC_ALARM_PREFIX))
+				}
+			}
+			if strings.HasPrefix(sig.Name, DBC_REQUEST_PREFIX) {
+				u.Fields |= UPDATE_REQUESTS
+				if v != 0 {
+					lgResu.Requests = append(lgResu.Requests, strings.TrimPrefix(sig.Name, DBC_REQUEST_PREFIX))
+				}
+			}
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LgResuMessageIds contains the id's of all messages send by the LG Resu 10 LV.
+var LgResuMessageIds = []uint32{
+	BMS_VOLT_AMP_TEMP,
+	BMS_SERIAL_NUM,
+	BMS_LIMITS,
+	BMS_SOC_SOH,
+	BMS_WARN_ALARM,
+}
+
+// BatteryMessageIds contains the id's of all messages send by a battery using the 0x35x battery protocol
+// (the LG Resu 10 LV messages and the charge/discharge request and manufacturer messages).
+var BatteryMessageIds = append(append([]uint32{}, LgResuMessageIds...),
+	BMS_REQUEST,
+	BMS_MANUFACTURER,
+)
+
+// scale converts the signed value v to the integer representation with the resolution 1/factor.
+func scale(v float32, factor float64) uint16 {
+	return uint16(int16(math.Round(float64(v) * factor)))
+}
+
+// EncodeVoltAmpTemp creates one volt/amp/temp message (0x356).
+func (lgResu *LgResuStatus) EncodeVoltAmpTemp() (id uint32, s []byte) {
+	s = make([]byte, 8)
+	binary.LittleEndian.PutUint16(s[0:2], uint16(math.Round(float64(lgResu.Voltage)*100)))
+	binary.LittleEndian.PutUint16(s[2:4], scale(lgResu.Current, 10))
+	binary.LittleEndian.PutUint16(s[4:6], scale(lgResu.Temp, 10))
+	return BMS_VOLT_AMP_TEMP, s
+}
+
+// EncodeSerialNum creates one serial number message (0x354). The raw message (Identity)
+// is send unchanged, otherwise the message is encoded from the model, serial number and
+// firmware/hardware revision. Unknown models (UNKNOWN_MODEL_XX) are encoded with the model code XX.
+func (lgResu *LgResuStatus) EncodeSerialNum() (id uint32, s []byte) {
+	s = make([]byte, 8)
+
+	if raw, err := hex.DecodeString(lgResu.Identity); err == nil && len(raw) > 0 && len(raw) <= 8 {
+		copy(s, raw)
+		return BMS_SERIAL_NUM, s
+	}
+
+	for _, mv := range ModelValues {
+		if lgResu.Model == mv.Description {
+			s[0] = mv.Value
+		}
+	}
+	if strings.HasPrefix(lgResu.Model, "UNKNOWN_MODEL_") {
+		code, _ := strconv.ParseUint(strings.TrimPrefix(lgResu.Model, "UNKNOWN_MODEL_"), 16, 8)
+		s[0] = uint8(code)
+	}
+
+	binary.LittleEndian.PutUint16(s[1:3], lgResu.SerialNum)
+
+	var major, minor uint8
+	fmt.Sscanf(lgResu.FirmwareVersion, "%d.%d", &major, &minor)
+	s[3] = major*10 + minor
+
+	s[4] = lgResu.HardwareVersion
+	return BMS_SERIAL_NUM, s
+}
+
+// EncodeLimits creates one configuration parameters message (0x351).
+func (lgResu *LgResuStatus) EncodeLimits() (id uint32, s []byte) {
+	s = make([]byte, 8)
+	binary.LittleEndian.PutUint16(s[0:2], uint16(math.Round(float64(lgResu.MaxVoltage)*10)))
+	binary.LittleEndian.PutUint16(s[2:4], uint16(math.Round(float64(lgResu.MaxChargeCurrent)*10)))
+	binary.LittleEndian.PutUint16(s[4:6], uint16(math.Round(float64(lgResu.MaxDischargeCurrent)*10)))
+	return BMS_LIMITS, s
+}
+
+// EncodeSocSoh creates one state of charge/health message (0x355).
+func (lgResu *LgResuStatus) EncodeSocSoh() (id uint32, s []byte) {
+	s = make([]byte, 8)
+	binary.LittleEndian.PutUint16(s[0:2], lgResu.Soc)
+	binary.LittleEndian.PutUint16(s[2:4], lgResu.Soh)
+	return BMS_SOC_SOH, s
+}
+
+// encodeBits returns the bitmask of all descriptions in names.
+func encodeBits(names []string, bitValues []BitValue) (data uint16) {
+	for _, name := range names {
+		for _, bv := range bitValues {
+			if name == bv.Description {
+				data |= bv.Value
+			}
+		}
+	}
+	return data
+}
+
+// EncodeWarnAlarm creates one warnings/alarms message (0x359).
+func (lgResu *LgResuStatus) EncodeWarnAlarm() (id uint32, s []byte) {
+	warnings, alarms := bitDefinitions()
+
+	s = make([]byte, 8)
+	binary.LittleEndian.PutUint16(s[0:2], encodeBits(lgResu.Warnings, warnings))
+	binary.LittleEndian.PutUint16(s[2:4], encodeBits(lgResu.Alarms, alarms))
+	return BMS_WARN_ALARM, s
+}
+
+// EncodeRequests creates one charge/discharge request message (0x35C).
+func (lgResu *LgResuStatus) EncodeRequests() (id uint32, s []byte) {
+	s = make([]byte, 8)
+	s[0] = uint8(encodeBits(lgResu.Requests, RequestBitValues))
+	return BMS_REQUEST, s
+}
+
+// EncodeManufacturer creates one manufacturer message (0x35E). The name is truncated to 8
+// characters and padded with spaces.
+func (lgResu *LgResuStatus) EncodeManufacturer() (id uint32, s []byte) {
+	s = []byte("        ")
+	copy(s, lgResu.Manufacturer)
+	return BMS_MANUFACTURER, s
+}
+
+// EncodeLgResuCanbusMessage creates the message with the given id. s is nil if
+// id is not a message of BatteryMessageIds.
+func (lgResu *LgResuStatus) EncodeLgResuCanbusMessage(id uint32) (s []byte) {
+	switch id {
+	case BMS_VOLT_AMP_TEMP:
+		_, s = lgResu.EncodeVoltAmpTemp()
+	case BMS_SERIAL_NUM:
+		_, s = lgResu.EncodeSerialNum()
+	case BMS_LIMITS:
+		_, s = lgResu.EncodeLimits()
+	case BMS_SOC_SOH:
+		_, s = lgResu.EncodeSocSoh()
+	case BMS_WARN_ALARM:
+		_, s = lgResu.EncodeWarnAlarm()
+	case BMS_REQUEST:
+		_, s = lgResu.EncodeRequests()
+	case BMS_MANUFACTURER:
+		_, s = lgResu.EncodeManufacturer()
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+// statuses that are encoded and decoded again
+var RoundTripTestStatuses = []LgResuStatus{
+	// last state of CanbusTestMessages
+	CanbusTestMessages[len(CanbusTestMessages)-1].Expect,
+	// charging, no warnings
+	{Soc: 100, Soh: 98, Voltage: 57.43, Current: 45.3, Temp: 31.2,
+		MaxVoltage: 57.7, MaxChargeCurrent: 93.6, MaxDischargeCurrent: 93.6,
+		Identity: "07FFFFFFFF000000", Model: "UNKNOWN_MODEL_07", SerialNum: 65535, FirmwareVersion: "25.5", HardwareVersion: 255},
+	// discharging below freezing, single warning
+	{Soc: 5, Soh: 80, Voltage: 46.01, Current: -91.8, Temp: -12.5,
+		MaxVoltage: 57.7, MaxChargeCurrent: 0, MaxDischargeCurrent: 91.8,
+		Identity: "0401000000000000", Model: "RESU_10_LV", SerialNum: 1, FirmwareVersion: "0.0", HardwareVersion: 0,
+		Warnings: []string{"BATTERY_LOW_VOLTAGE"}},
+	// complete battery (charge/discharge requests, manufacturer)
+	{Soc: 98, Soh: 99, Voltage: 56.2, Current: 2.1, Temp: 22.4,
+		MaxVoltage: 57.7, MaxChargeCurrent: 5, MaxDischargeCurrent: 91.8,
+		Identity: "04C0001F03000000", Model: "RESU_10_LV", SerialNum: 192, FirmwareVersion: "3.1", HardwareVersion: 3,
+		Requests: []string{"CHARGE_ENABLE", "DISCHARGE_ENABLE", "FULL_CHARGE"}, Manufacturer: "LG"},
+	// limits above the signed range (unsigned fields)
+	{Soc: 50, Soh: 99, Voltage: 52.1, Current: 0, Temp: 20,
+		MaxVoltage: 3300, MaxChargeCurrent: 4000.5, MaxDischargeCurrent: 6553.5,
+		Identity: "04C0001F03000000", Model: "RESU_10_LV", SerialNum: 192, FirmwareVersion: "3.1", HardwareVersion: 3},
+}
+
+// TestEncodeLgResuCanbusMessage tests encoding against the LG Resu 10 LV test messages.
+func TestEncodeLgResuCanbusMessage(t *testing.T) {
+	lgResu := CanbusTestMessages[len(CanbusTestMessages)-1].Expect
+
+	for _, tm := range CanbusTestMessages {
+		data := lgResu.EncodeLgResuCanbusMessage(tm.Identifier)
+		if !bytes.Equal(data, tm.Data[:]) {
+			t.Errorf("lgResu.EncodeLgResuCanbusMessage(%x) == % X, expect % X", tm.Identifier, data, tm.Data)
+		}
+	}
+
+	if data := lgResu.EncodeLgResuCanbusMessage(INV_KEEP_ALIVE); data != nil {
+		t.Errorf("lgResu.EncodeLgResuCanbusMessage(%x) == % X, expect nil", INV_KEEP_ALIVE, data)
+	}
+}
+
+// TestEncodeIdentity tests that the raw serial number message is send unchanged.
+func TestEncodeIdentity(t *testing.T) {
+	// bytes 5-7 are not decoded into a field
+	lgResu := &LgResuStatus{Identity: "04C0001F03A1B2C3", Model: "RESU_10_LV", SerialNum: 192,
+		FirmwareVersion: "3.1", HardwareVersion: 3}
+
+	expect := []byte{0x04, 0xc0, 0x00, 0x1f, 0x03, 0xa1, 0xb2, 0xc3}
+	if _, data := lgResu.EncodeSerialNum(); !bytes.Equal(data, expect) {
+		t.Errorf("lgResu.EncodeSerialNum() == % X, expect % X", data, expect)
+	}
+}
+
+// TestEncodeDecodeRoundTrip tests that decoding an encoded LgResuStatus returns the original LgResuStatus.
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	for _, expect := range RoundTripTestStatuses {
+		lgResu := &LgResuStatus{}
+
+		for _, id := range BatteryMessageIds {
+			lgResu.DecodeLgResuCanbusMessage(id, expect.EncodeLgResuCanbusMessage(id))
+		}
+
+		if !cmp.Equal(*lgResu, expect) {
+			t.Errorf("decode(encode(%+v)) == %+v", expect, *lgResu)
+		}
+	}
+}
+
+// TestEncodeMessageIds tests that every encode function returns its own message id.
+func TestEncodeMessageIds(t *testing.T) {
+	lgResu := &LgResuStatus{}
+
+	for _, enc := range []struct {
+		f      func() (uint32, []byte)
+		expect uint32
+	}{
+		{lgResu.EncodeVoltAmpTemp, BMS_VOLT_AMP_TEMP},
+		{lgResu.EncodeSerialNum, BMS_SERIAL_NUM},
+		{lgResu.EncodeLimits, BMS_LIMITS},
+		{lgResu.EncodeSocSoh, BMS_SOC_SOH},
+		{lgResu.EncodeWarnAlarm, BMS_WARN_ALARM},
+		{lgResu.EncodeRequests, BMS_REQUEST},
+		{lgResu.EncodeManufacturer, BMS_MANUFACTURER},
+	} {
+		if id, data := enc.f(); id != enc.expect || len(data) != 8 {
+			t.Errorf("encode returned id = %#04x, len(data) = %d, expect id = %#04x, len(data) = 8", id, len(data), enc.expect)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Definition of the event types and states
+const (
+	EVENT_WARNING string = "warning"
+	EVENT_ALARM   string = "alarm"
+	EVENT_RAISED  string = "raised"
+	EVENT_CLEARED string = "cleared"
+)
+
+// Event contains a single warning/alarm transition.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	State       string    `json:"state"`
+}
+
+// contains reports whether s is an element of list.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// transitions returns the events for all descriptions that have been added to (raised)
+// or removed from (cleared) the list of descriptions.
+func transitions(t time.Time, eventType string, previous []string, current []string) []Event {
+	events := []Event{}
+
+	for _, d := range current {
+		if !contains(previous, d) {
+			events = append(events, Event{t, eventType, d, EVENT_RAISED})
+		}
+	}
+	for _, d := range previous {
+		if !contains(current, d) {
+			events = append(events, Event{t, eventType, d, EVENT_CLEARED})
+		}
+	}
+	return events
+}
+
+// Transitions returns the warnings and alarms that have been raised or cleared between
+// the previous and the current LgResuStatus. t is the time of the transition.
+func Transitions(t time.Time, previous *LgResuStatus, current *LgResuStatus) []Event {
+	return append(transitions(t, EVENT_WARNING, previous.Warnings, current.Warnings),
+		transitions(t, EVENT_ALARM, previous.Alarms, current.Alarms)...)
+}
+
+// CsvRecord return a string containing the event (Timestamp, Type, Description, State) as CSV values.
+func (e Event) CsvRecord() (csvRecord string) {
+	return e.Time.Format("2006/01/02 15:04:05") + "," + e.Type + "," + e.Description + "," + e.State + "\n"
+}
+
+// EventCsvRecordHeader return a string containing the header for the CSV data record created with Event.CsvRecord().
+func EventCsvRecordHeader() (csvRecordHeader string) {
+	return "Time,Type,Description,State\n"
+}
+
+// EventLog contains the most recent events. EventLog can be used concurrently.
+type EventLog struct {
+	mu     sync.Mutex
+	size   int
+	events []Event
+}
+
+// NewEventLog is the constructor for EventLog. size is the maximal number of events kept.
+func NewEventLog(size int) *EventLog {
+	return &EventLog{size: size, events: []Event{}}
+}
+
+// Add adds events to the log (and removes the oldest events if the log is full).
+func (el *EventLog) Add(events ...Event) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	el.events = append(el.events, events...)
+	if len(el.events) > el.size {
+		el.events = el.events[len(el.events)-el.size:]
+	}
+}
+
+// Events returns a copy of all events in the log (oldest event first).
+func (el *EventLog) Events() []Event {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	events := make([]Event, len(el.events))
+	copy(events, el.events)
+	return events
+}
+
+// DiscoveryCsvRecord return a string containing the event (Timestamp, Type, Bitmask, Description, State)
+// and the key metrics at the time of the event (SOC, Voltage, Current, Temperature) as CSV values.
+func (e Event) DiscoveryCsvRecord(lgResu *LgResuStatus) (csvRecord string) {
+	mask := ""
+	if m, ok := BitMask(e.Type, e.Description); ok {
+		mask = fmt.Sprintf("%#04x", m)
+	}
+
+	return e.Time.Format("2006/01/02 15:04:05") + "," + e.Type + "," + mask + "," + e.Description + "," + e.State + "," +
+		strconv.Itoa(int(lgResu.Soc)) + "," +
+		strconv.FormatFloat(float64(lgResu.Voltage), 'f', 2, 32) + "," +
+		strconv.FormatFloat(float64(lgResu.Current), 'f', 2, 32) + "," +
+		strconv.FormatFloat(float64(lgResu.Temp), 'f', 1, 32) + "\n"
+}
+
+// DiscoveryCsvRecordHeader return a string containing the header for the CSV data record created with
+// Event.DiscoveryCsvRecord().
+func DiscoveryCsvRecordHeader() (csvRecordHeader string) {
+	return "Time,Type,Bitmask,Description,State,Soc,Voltage,Current,Temp\n"
+}
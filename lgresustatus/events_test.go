@@ -0,0 +1,90 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+// TestWarningsAreLevelTriggered tests that warnings reflect the current bitmask of 0x359.
+func TestWarningsAreLevelTriggered(t *testing.T) {
+	lgResu := &LgResuStatus{}
+
+	for i := 0; i < 3; i++ {
+		lgResu.DecodeLgResuCanbusMessage(BMS_WARN_ALARM, []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	}
+	if !cmp.Equal(lgResu.Warnings, []string{"BATTERY_HIGH_TEMP"}) {
+		t.Errorf("lgResu.Warnings == %v, expect [BATTERY_HIGH_TEMP]", lgResu.Warnings)
+	}
+
+	// warning cleared
+	lgResu.DecodeLgResuCanbusMessage(BMS_WARN_ALARM, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if len(lgResu.Warnings) != 0 {
+		t.Errorf("lgResu.Warnings == %v, expect []", lgResu.Warnings)
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	now, _ := time.Parse("2006-Jan-02", "2018-Jun-11")
+
+	previous := &LgResuStatus{Warnings: []string{"BATTERY_HIGH_TEMP", "CELL_IMBALANCE"}}
+	current := &LgResuStatus{Warnings: []string{"CELL_IMBALANCE", "BATTERY_LOW_VOLTAGE"}, Alarms: []string{"UNKNOWN_aa0"}}
+
+	expect := []Event{
+		{now, EVENT_WARNING, "BATTERY_LOW_VOLTAGE", EVENT_RAISED},
+		{now, EVENT_WARNING, "BATTERY_HIGH_TEMP", EVENT_CLEARED},
+		{now, EVENT_ALARM, "UNKNOWN_aa0", EVENT_RAISED},
+	}
+
+	events := Transitions(now, previous, current)
+	if !cmp.Equal(events, expect) {
+		t.Errorf("Transitions() == %+v, expect %+v", events, expect)
+	}
+
+	// no transitions without changes
+	if events := Transitions(now, current, current); len(events) != 0 {
+		t.Errorf("Transitions() == %+v, expect []", events)
+	}
+
+	csvRecord := events[0].CsvRecord()
+	if csvRecord != "2018/06/11 00:00:00,warning,BATTERY_LOW_VOLTAGE,raised\n" {
+		t.Errorf("CsvRecord() == %q", csvRecord)
+	}
+}
+
+func TestDiscoveryCsvRecord(t *testing.T) {
+	now, _ := time.Parse("2006-Jan-02", "2018-Jun-11")
+
+	lgResu := &LgResuStatus{Soc: 77, Voltage: 54.51, Current: -1.9, Temp: 18.6, Alarms: []string{"UNKNOWN_AA2"}}
+
+	csvRecord := Event{now, EVENT_ALARM, "UNKNOWN_AA2", EVENT_RAISED}.DiscoveryCsvRecord(lgResu)
+	if csvRecord != "2018/06/11 00:00:00,alarm,0x0400,UNKNOWN_AA2,raised,77,54.51,-1.90,18.6\n" {
+		t.Errorf("DiscoveryCsvRecord() == %q", csvRecord)
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	el := NewEventLog(2)
+
+	el.Add(Event{Description: "A"})
+	el.Add(Event{Description: "B"}, Event{Description: "C"})
+
+	events := el.Events()
+	if len(events) != 2 || events[0].Description != "B" || events[1].Description != "C" {
+		t.Errorf("el.Events() == %+v, expect events B and C", events)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"fmt"
+	"time"
+)
+
+// BMS states reported in the CSV data record
+const (
+	BMS_ONLINE  string = "online"
+	BMS_OFFLINE string = "offline"
+)
+
+// MessageStats contains the receive statistics of a single CANBus message id.
+type MessageStats struct {
+	// Time the message has been received last
+	LastReceived time.Time `json:"lastReceived"`
+	// Number of messages received
+	Count uint64 `json:"count"`
+	// Metrics decoded from the message are older than the stale timeout
+	Stale bool `json:"stale"`
+}
+
+// MessageKey returns the key of message id in LgResuStatus.Messages (for example: 0x356).
+func MessageKey(id uint32) string {
+	return fmt.Sprintf("%#03x", id)
+}
+
+// copyMessages returns a copy of lgResu.Messages. LgResuStatus values are passed between
+// goroutines, the Messages map is therefore never modified in place.
+func (lgResu *LgResuStatus) copyMessages() map[string]MessageStats {
+	messages := make(map[string]MessageStats, len(lgResu.Messages)+1)
+	for key, stats := range lgResu.Messages {
+		messages[key] = stats
+	}
+	return messages
+}
+
+// Received updates the receive statistics of message id received at time t.
+func (lgResu *LgResuStatus) Received(id uint32, t time.Time) {
+	messages := lgResu.copyMessages()
+
+	stats := messages[MessageKey(id)]
+	stats.LastReceived = t
+	stats.Count++
+	stats.Stale = false
+	messages[MessageKey(id)] = stats
+
+	lgResu.Messages = messages
+	lgResu.Offline = false
+}
+
+// UpdateFreshness marks all messages that have not been received within timeout before t as stale.
+// The BMS is offline if no message has been received within timeout (or no message has been received at all).
+func (lgResu *LgResuStatus) UpdateFreshness(t time.Time, timeout time.Duration) {
+	messages := lgResu.copyMessages()
+
+	lgResu.Offline = true
+	for key, stats := range messages {
+		stats.Stale = t.Sub(stats.LastReceived) > timeout
+		if !stats.Stale {
+			lgResu.Offline = false
+		}
+		messages[key] = stats
+	}
+
+	lgResu.Messages = messages
+}
+
+// OfflineStatus returns lgResu with the metrics that are empty in the CSV data record (SOC, voltage, current,
+// power, estimated SOC, SOC divergence, temperature) and the time remaining set to 0 if the BMS is offline.
+// The last known values of an offline BMS are therefore not published. The BMS is offline until the first
+// message has been received.
+func (lgResu *LgResuStatus) OfflineStatus() LgResuStatus {
+	status := *lgResu
+	if len(status.Messages) == 0 {
+		status.Offline = true
+	}
+	if !status.Offline {
+		return status
+	}
+
+	status.Soc, status.Voltage, status.Current, status.Power, status.Temp = 0, 0, 0, 0, 0
+	status.EstimatedSoc, status.SocDivergence = 0, 0
+	status.TimeToEmpty, status.TimeToFull, status.TimeRemaining = 0, 0, ""
+	return status
+}
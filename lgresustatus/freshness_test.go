@@ -0,0 +1,85 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReceivedAndUpdateFreshness(t *testing.T) {
+	start, _ := time.Parse("2006-Jan-02", "2018-Jun-11")
+
+	lgResu := &LgResuStatus{}
+
+	// no message received yet (and no freshness check yet)
+	lgResu.Soc = 77
+	if status := lgResu.OfflineStatus(); !status.Offline || status.Soc != 0 {
+		t.Errorf("lgResu.OfflineStatus() == %+v without any message, expect offline, no metrics", status)
+	}
+	lgResu.Soc = 0
+
+	lgResu.UpdateFreshness(start, 10*time.Second)
+	if !lgResu.Offline {
+		t.Errorf("lgResu.Offline == false without any message, expect true")
+	}
+
+	lgResu.Received(BMS_VOLT_AMP_TEMP, start)
+	lgResu.Received(BMS_VOLT_AMP_TEMP, start.Add(1*time.Second))
+	lgResu.Received(BMS_SERIAL_NUM, start)
+
+	// copies of lgResu are not affected by later updates
+	previous := *lgResu
+
+	lgResu.UpdateFreshness(start.Add(5*time.Second), 4*time.Second)
+
+	vat := lgResu.Messages["0x356"]
+	sn := lgResu.Messages["0x354"]
+	if vat.Count != 2 || vat.Stale || !sn.Stale || lgResu.Offline {
+		t.Errorf("lgResu.Messages == %+v, Offline == %v, expect 0x356 (2 messages, fresh), 0x354 (stale), online",
+			lgResu.Messages, lgResu.Offline)
+	}
+	if previous.Messages["0x354"].Stale {
+		t.Errorf("UpdateFreshness() modified a copy of lgResu")
+	}
+
+	// all messages are stale
+	lgResu.UpdateFreshness(start.Add(60*time.Second), 4*time.Second)
+	if !lgResu.Offline {
+		t.Errorf("lgResu.Offline == false, expect true")
+	}
+
+	lgResu.Soc, lgResu.Voltage, lgResu.TimeRemaining = 77, 54.51, "3h20m"
+	if status := lgResu.OfflineStatus(); status.Soc != 0 || status.Voltage != 0 || status.TimeRemaining != "" {
+		t.Errorf("lgResu.OfflineStatus() == %+v for an offline BMS, expect no metrics", status)
+	}
+	if lgResu.Soc != 77 {
+		t.Errorf("lgResu.OfflineStatus() modified lgResu")
+	}
+
+	csvRecord := lgResu.CsvRecord(start)
+	if csvRecord != "2018/06/11 00:00:00,,,,,,,,0.0,0.0,0.0,0.0,0.0,0.0,offline\n" {
+		t.Errorf("lgResu.CsvRecord() == %q for an offline BMS", csvRecord)
+	}
+
+	// BMS is back online
+	lgResu.Received(BMS_SOC_SOH, start.Add(61*time.Second))
+	if lgResu.Offline || lgResu.Messages["0x355"].Count != 1 {
+		t.Errorf("lgResu.Offline == %v, Messages == %+v, expect online", lgResu.Offline, lgResu.Messages)
+	}
+	if status := lgResu.OfflineStatus(); status.Soc != 77 {
+		t.Errorf("lgResu.OfflineStatus() == %+v for an online BMS, expect Soc 77", status)
+	}
+}
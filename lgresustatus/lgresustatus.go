@@ -21,11 +21,11 @@
 // Note:
 //
 // Not all messages can currently be decoded. Support for
-// alarm bits (message id 0x359) and CANBus message id 0x354 is missing.
+// alarm bits (message id 0x359) is missing.
 //
 // CANBus BMS Message format specifications:
 //
-// 1) Lithiumate BMS CANBus message format specification:
+// 1) Lithiumate BMS CANBus message format specification (see package lithiumate):
 //
 // http://lithiumate.elithion.com/php/controller_can_specs.php
 //
@@ -37,8 +37,10 @@ package lgresustatus
 
 import (
 	"encoding/binary"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -50,8 +52,14 @@ const (
 	BMS_SOC_SOH       uint32 = 0x355
 	BMS_VOLT_AMP_TEMP uint32 = 0x356
 	BMS_WARN_ALARM    uint32 = 0x359
+	// not send by the LG Resu 10 LV, expected by inverters using the 0x35x battery protocol (Pylontech)
+	BMS_REQUEST      uint32 = 0x35C
+	BMS_MANUFACTURER uint32 = 0x35E
 )
 
+// Nominal capacity of the LG Resu 10 LV in Ah
+const NOMINAL_CAPACITY float32 = 189
+
 // Github triggers update of godoc documentation.
 type Github int
 
@@ -115,8 +123,72 @@ var WarningBitValues = []BitValue{
 //  aa0-7 UNKNOWN
 //  AA0-7 UNKNOWN
 //
+// Note:
+// Unknown warning/alarm bits can be relabeled with LoadBitLabels(). The
+// definitions must not be modified in place (see RelabelBits).
 var AlarmBitValues = []BitValue{
-	{"UNKNOWN_ALARM", 0xffff},
+	{"UNKNOWN_aa0", 0x0001},
+	{"UNKNOWN_aa1", 0x0002},
+	{"UNKNOWN_aa2", 0x0004},
+	{"UNKNOWN_aa3", 0x0008},
+	{"UNKNOWN_aa4", 0x0010},
+	{"UNKNOWN_aa5", 0x0020},
+	{"UNKNOWN_aa6", 0x0040},
+	{"UNKNOWN_aa7", 0x0080},
+	{"UNKNOWN_AA0", 0x0100},
+	{"UNKNOWN_AA1", 0x0200},
+	{"UNKNOWN_AA2", 0x0400},
+	{"UNKNOWN_AA3", 0x0800},
+	{"UNKNOWN_AA4", 0x1000},
+	{"UNKNOWN_AA5", 0x2000},
+	{"UNKNOWN_AA6", 0x4000},
+	{"UNKNOWN_AA7", 0x8000},
+}
+
+// RequestBitValues defines the charge/discharge request bits.
+//
+// Raw CANBus message format:
+//
+// 0000035C 8 rr 00 00 00 00 00 00 00
+//
+//  rr7 CHARGE_ENABLE
+//  rr6 DISCHARGE_ENABLE
+//  rr5 FORCE_CHARGE_1
+//  rr4 FORCE_CHARGE_2
+//  rr3 FULL_CHARGE
+//  rr0-2 UNKNOWN
+var RequestBitValues = []BitValue{
+	{"CHARGE_ENABLE", 0x0080},
+	{"DISCHARGE_ENABLE", 0x0040},
+	{"FORCE_CHARGE_1", 0x0020},
+	{"FORCE_CHARGE_2", 0x0010},
+	{"FULL_CHARGE", 0x0008},
+}
+
+// ModelValue contains a single model code and definition.
+type ModelValue struct {
+	Description string
+	Value       uint8
+}
+
+// ModelValues defines the known model codes.
+//
+// Raw CANBus message format:
+//
+// 00000354 8 mm ss SS ff hh 00 00 00
+//
+//  mm    model code
+//  ss SS serial number
+//  ff    firmware revision (major * 10 + minor)
+//  hh    hardware revision
+//
+// Note:
+// The message is constant for a given battery. The layout is unverified:
+// it is guessed from the message of a single LG Resu 10 LV
+// (04 C0 00 1F 03 00 00 00) and has to be confirmed with the messages of
+// other units. The raw message (LgResuStatus.Identity) identifies a battery.
+var ModelValues = []ModelValue{
+	{"RESU_10_LV", 0x04},
 }
 
 // LgResuStatus contains metrics send by the LG Resu 10 LV.
@@ -138,13 +210,67 @@ type LgResuStatus struct {
 	// Maximal battery charge current (LG Resu 10 LV is a C = 189Ah battery, C/2 is approx. 90A)
 	MaxChargeCurrent float32 `json:"maxChargeCurrent"`
 	// Maximal battery discharge current (LG Resu 10 is a C = 189Ah battery, C/2 is approx. 90A)
-	MaxDischargeCurrent float32  `json:"maxDischargeCurrent"`
-	Warnings            []string `json:"warnings"`
-	Alarms              []string `json:"alarms"`
+	MaxDischargeCurrent float32 `json:"maxDischargeCurrent"`
+	// Raw serial number message (0x354) in hex (for example: 04C0001F03000000), identifies the battery
+	Identity string `json:"identity"`
+	// Battery model (for example: RESU_10_LV, unverified)
+	Model string `json:"model"`
+	// Battery serial number (unverified)
+	SerialNum uint16 `json:"serialNum"`
+	// BMS firmware revision (for example: 3.1, unverified)
+	FirmwareVersion string `json:"firmwareVersion"`
+	// BMS hardware revision (unverified)
+	HardwareVersion uint8    `json:"hardwareVersion"`
+	Warnings        []string `json:"warnings"`
+	Alarms          []string `json:"alarms"`
+	// Charge/discharge requests (0x35C, for example: CHARGE_ENABLE, DISCHARGE_ENABLE, FORCE_CHARGE_1)
+	Requests []string `json:"requests"`
+	// Manufacturer name (0x35E, for example: LG)
+	Manufacturer string `json:"manufacturer"`
+	// Number of invalid messages (too short or malformed) received from the BMS
+	DecodeErrors uint32 `json:"decodeErrors"`
+	// Number of messages with a message id unknown to the BMS profile
+	UnknownMessages uint32 `json:"unknownMessages"`
+	// Power in W (+ battery is charged, - battery is discharged)
+	Power float32 `json:"power"`
+	// Energy charged/discharged in Wh
+	Energy EnergyCounters `json:"energy"`
+	// SOC estimated by coulomb counting (%)
+	EstimatedSoc float32 `json:"estimatedSoc"`
+	// Divergence between estimated SOC and SOC reported by the BMS (EstimatedSoc - Soc)
+	SocDivergence float32 `json:"socDivergence"`
+	// Estimated time in seconds until the battery is empty (0 if the battery is not discharged)
+	TimeToEmpty uint32 `json:"timeToEmpty"`
+	// Estimated time in seconds until the battery is full (0 if the battery is not charged)
+	TimeToFull uint32 `json:"timeToFull"`
+	// Estimated time until the battery is empty or full (for example: 3h20m, empty if the battery is idle)
+	TimeRemaining string `json:"timeRemaining"`
+	// Receive statistics per message id (for example: 0x356)
+	Messages map[string]MessageStats `json:"messages"`
+	// No message has been received within the stale timeout (see OfflineStatus)
+	Offline bool `json:"offline"`
+	// Metrics that have been received from the BMS at least once (see HasReceived)
+	ReceivedFields UpdateField `json:"-"`
 }
 
-// DecodeLgResuCanbusMessage decodes messages send by the LG Resu 10 LV BMS and updates lgResu with new metric values.
-func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
+// EnergyCounters contains the energy charged and discharged today, this month and since the
+// first start of lgresu_mon (lifetime) in Wh.
+type EnergyCounters struct {
+	ChargedToday       float64 `json:"chargedToday"`
+	DischargedToday    float64 `json:"dischargedToday"`
+	ChargedMonth       float64 `json:"chargedMonth"`
+	DischargedMonth    float64 `json:"dischargedMonth"`
+	ChargedLifetime    float64 `json:"chargedLifetime"`
+	DischargedLifetime float64 `json:"dischargedLifetime"`
+}
+
+// Decode decodes a single message send by the LG Resu 10 LV BMS. Decode returns an
+// UnknownMessageError for message id's that are not part of the LG Resu 10 LV protocol and
+// a MessageLengthError or MalformedMessageError for invalid messages.
+func Decode(id uint32, s []byte) (Update, error) {
+
+	u := Update{Id: id}
+	lgResu := &u.Status
 
 	log.Debugf("%-4x % -24X\n", id, s)
 
@@ -152,6 +278,10 @@ func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
 	case BMS_VOLT_AMP_TEMP:
 		log.Debugf("BMS: volt/amp/temp (%#04x)\n", BMS_VOLT_AMP_TEMP)
 
+		if err := CheckLength(id, s, 6); err != nil {
+			return u, err
+		}
+
 		// unsigned: voltage is always positive
 		data := binary.LittleEndian.Uint16(s[0:2])
 		lgResu.Voltage = float32(data) / 100
@@ -162,14 +292,20 @@ func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
 		lgResu.Current = float32(int16(data)) / 10
 		log.Debugf("current = %.2f [ADC]\n", lgResu.Current)
 
-		// signed: temperature in Celsius
+		// signed: temperature in Celsius (negative below freezing)
 		data = binary.LittleEndian.Uint16(s[4:6])
-		lgResu.Temp = float32(data) / 10
-		log.Debugf("temperature = %.1f [Celsius]\n\n", float32(data)/10)
+		lgResu.Temp = float32(int16(data)) / 10
+		log.Debugf("temperature = %.1f [Celsius]\n\n", lgResu.Temp)
+
+		u.Fields = UPDATE_VOLTAGE | UPDATE_CURRENT | UPDATE_TEMP
 
 	case BMS_SOC_SOH:
 		log.Debugf("BMS: state of charge/health (%#04x):\n", BMS_SOC_SOH)
 
+		if err := CheckLength(id, s, 4); err != nil {
+			return u, err
+		}
+
 		data := binary.LittleEndian.Uint16(s[0:2])
 		lgResu.Soc = data
 		log.Debugf("soc = %d %%\n", lgResu.Soc)
@@ -178,9 +314,20 @@ func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
 		lgResu.Soh = data
 		log.Debugf("soh = %d %%\n\n", lgResu.Soh)
 
+		if lgResu.Soc > 100 || lgResu.Soh > 100 {
+			return u, &MalformedMessageError{Id: id,
+				Reason: fmt.Sprintf("soc = %d %%, soh = %d %% (expect <= 100 %%)", lgResu.Soc, lgResu.Soh)}
+		}
+
+		u.Fields = UPDATE_SOC | UPDATE_SOH
+
 	case BMS_LIMITS:
 		log.Debugf("BMS: configuration parameters (%#04x):\n", BMS_LIMITS)
 
+		if err := CheckLength(id, s, 6); err != nil {
+			return u, err
+		}
+
 		// unsigned: voltage is always positive
 		data := binary.LittleEndian.Uint16(s[0:2])
 		lgResu.MaxVoltage = float32(data) / 10
@@ -196,8 +343,38 @@ func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
 		lgResu.MaxDischargeCurrent = float32(data) / 10
 		log.Debugf("max discharge current = %.2f [ADC]\n\n", lgResu.MaxDischargeCurrent)
 
+		u.Fields = UPDATE_MAX_VOLTAGE | UPDATE_MAX_CHARGE_CURRENT | UPDATE_MAX_DISCHARGE_CURRENT
+
 	case BMS_SERIAL_NUM:
-		log.Debugf("BMS: serial number (?) (%#04x):\n\n", BMS_SERIAL_NUM)
+		log.Debugf("BMS: serial number (%#04x):\n", BMS_SERIAL_NUM)
+
+		if err := CheckLength(id, s, 5); err != nil {
+			return u, err
+		}
+
+		// the raw message is the identity of the battery, the fields below are unverified
+		lgResu.Identity = fmt.Sprintf("%X", s)
+		log.Debugf("identity = %s\n", lgResu.Identity)
+
+		lgResu.Model = fmt.Sprintf("UNKNOWN_MODEL_%02X", s[0])
+		for _, mv := range ModelValues {
+			if s[0] == mv.Value {
+				lgResu.Model = mv.Description
+			}
+		}
+		log.Debugf("model = %s\n", lgResu.Model)
+
+		data := binary.LittleEndian.Uint16(s[1:3])
+		lgResu.SerialNum = data
+		log.Debugf("serial number = %d\n", lgResu.SerialNum)
+
+		lgResu.FirmwareVersion = fmt.Sprintf("%d.%d", s[3]/10, s[3]%10)
+		log.Debugf("firmware version = %s\n", lgResu.FirmwareVersion)
+
+		lgResu.HardwareVersion = s[4]
+		log.Debugf("hardware version = %d\n\n", lgResu.HardwareVersion)
+
+		u.Fields = UPDATE_IDENTITY
 
 	case INV_KEEP_ALIVE:
 		log.Debugf("INV: keep alive (%#04x):\n\n", INV_KEEP_ALIVE)
@@ -205,9 +382,15 @@ func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
 	case BMS_WARN_ALARM:
 		log.Debugf("BMS: warnings/alarms (%#04x):\n\n", BMS_WARN_ALARM)
 
+		if err := CheckLength(id, s, 4); err != nil {
+			return u, err
+		}
+
+		warnings, alarms := bitDefinitions()
+
 		// decode warnings
 		data := binary.LittleEndian.Uint16(s[0:2])
-		for _, bv := range WarningBitValues {
+		for _, bv := range warnings {
 			if data&bv.Value != 0 {
 				lgResu.Warnings = append(lgResu.Warnings, bv.Description)
 			}
@@ -215,13 +398,67 @@ func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
 
 		// decode alarms
 		data = binary.LittleEndian.Uint16(s[2:4])
-		for _, bv := range AlarmBitValues {
+		for _, bv := range alarms {
 			if data&bv.Value != 0 {
 				lgResu.Alarms = append(lgResu.Alarms, bv.Description)
 			}
 		}
 
+		u.Fields = UPDATE_WARNINGS | UPDATE_ALARMS
+
+	case BMS_REQUEST:
+		log.Debugf("BMS: charge/discharge requests (%#04x):\n", BMS_REQUEST)
+
+		if err := CheckLength(id, s, 1); err != nil {
+			return u, err
+		}
+
+		for _, bv := range RequestBitValues {
+			if uint16(s[0])&bv.Value != 0 {
+				lgResu.Requests = append(lgResu.Requests, bv.Description)
+			}
+		}
+		log.Debugf("requests = %v\n\n", lgResu.Requests)
+
+		u.Fields = UPDATE_REQUESTS
+
+	case BMS_MANUFACTURER:
+		log.Debugf("BMS: manufacturer (%#04x):\n", BMS_MANUFACTURER)
+
+		if err := CheckLength(id, s, 1); err != nil {
+			return u, err
+		}
+
+		// ASCII, padded with spaces or 0 bytes
+		name := strings.TrimRight(string(s), " \x00")
+		for _, c := range name {
+			if c < 0x20 || c > 0x7e {
+				return u, &MalformedMessageError{Id: id, Reason: fmt.Sprintf("manufacturer %q (expect ASCII)", name)}
+			}
+		}
+		lgResu.Manufacturer = name
+		log.Debugf("manufacturer = %s\n\n", lgResu.Manufacturer)
+
+		u.Fields = UPDATE_MANUFACTURER
+
+	default:
+		return u, &UnknownMessageError{Id: id}
+	}
+
+	return u, nil
+}
+
+// DecodeLgResuCanbusMessage decodes messages send by the LG Resu 10 LV BMS and updates lgResu with new metric values.
+// Invalid messages are logged and ignored.
+func (lgResu *LgResuStatus) DecodeLgResuCanbusMessage(id uint32, s []byte) {
+
+	u, err := Decode(id, s)
+	if err != nil {
+		log.Debugf("DecodeLgResuCanbusMessage: %v\n", err)
+		return
 	}
+
+	u.Apply(lgResu)
 }
 
 // CreateKeepAliveMessage creates one 'keep alive' message (to be send to the LG Resu 10 LV).
@@ -231,16 +468,37 @@ func (lgResu *LgResuStatus) CreateKeepAliveMessage() (id uint32, s []byte) {
 	return id, s
 }
 
-// CsvRecord return a string containing the key metrics (Timestamp, SOC, Voltage, Current) as CSV values.
+// CsvRecord return a string containing the CSV values for the columns of CsvRecordHeader():
+// Time, Soc, Voltage, Current, Power, EstimatedSoc, SocDivergence, Temp, the energy counters
+// (Wh charged/discharged today, this month, lifetime) and the BMS State.
+// Soc to Temp are empty if the BMS is offline.
 func (lgResu *LgResuStatus) CsvRecord(t time.Time) (csvRecord string) {
 
-	return t.Format("2006/01/02 15:04:05") + "," +
-		strconv.Itoa(int(lgResu.Soc)) + "," +
-		strconv.FormatFloat(float64(lgResu.Voltage), 'f', 2, 32) + "," +
-		strconv.FormatFloat(float64(lgResu.Current), 'f', 2, 32) + "\n"
+	metrics := ",,,,,,"
+	state := BMS_OFFLINE
+	if !lgResu.Offline {
+		metrics = strconv.Itoa(int(lgResu.Soc)) + "," +
+			strconv.FormatFloat(float64(lgResu.Voltage), 'f', 2, 32) + "," +
+			strconv.FormatFloat(float64(lgResu.Current), 'f', 2, 32) + "," +
+			strconv.FormatFloat(float64(lgResu.Power), 'f', 1, 32) + "," +
+			strconv.FormatFloat(float64(lgResu.EstimatedSoc), 'f', 1, 32) + "," +
+			strconv.FormatFloat(float64(lgResu.SocDivergence), 'f', 1, 32) + "," +
+			strconv.FormatFloat(float64(lgResu.Temp), 'f', 1, 32)
+		state = BMS_ONLINE
+	}
+
+	e := lgResu.Energy
+	return t.Format("2006/01/02 15:04:05") + "," + metrics + "," +
+		strconv.FormatFloat(e.ChargedToday, 'f', 1, 64) + "," +
+		strconv.FormatFloat(e.DischargedToday, 'f', 1, 64) + "," +
+		strconv.FormatFloat(e.ChargedMonth, 'f', 1, 64) + "," +
+		strconv.FormatFloat(e.DischargedMonth, 'f', 1, 64) + "," +
+		strconv.FormatFloat(e.ChargedLifetime, 'f', 1, 64) + "," +
+		strconv.FormatFloat(e.DischargedLifetime, 'f', 1, 64) + "," + state + "\n"
 }
 
 // CsvRecordHeader return a string containing the header for the CSV data record created with CsvRecord().
 func CsvRecordHeader() (csvRecordHeader string) {
-	return "Time,Soc,Voltage,Current\n"
+	return "Time,Soc,Voltage,Current,Power,EstimatedSoc,SocDivergence,Temp," +
+		"ChargedToday,DischargedToday,ChargedMonth,DischargedMonth,ChargedLifetime,DischargedLifetime,State\n"
 }
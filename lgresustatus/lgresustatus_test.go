@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"github.com/google/go-cmp/cmp"
 	log "github.com/sirupsen/logrus"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -36,18 +37,20 @@ var CanbusTestMessages = []struct {
 		Data:       [8]byte{0x4b, 0x15, 0xed, 0xff, 0xba, 0x00, 0x00, 0x00},
 		Expect:     LgResuStatus{Voltage: 54.51, Current: -1.9, Temp: 18.6},
 	},
-	// ? (LG Resu -> Inverter): unknown message type (appears to be a constant)
+	// serial number (LG Resu -> Inverter): model, serial number, firmware/hardware revision (constant)
 	{
 		Identifier: BMS_SERIAL_NUM,
 		Data:       [8]byte{0x04, 0xc0, 0x00, 0x1f, 0x03, 0x00, 0x00, 0x00},
-		Expect:     LgResuStatus{Voltage: 54.51, Current: -1.9, Temp: 18.6},
+		Expect: LgResuStatus{Voltage: 54.51, Current: -1.9, Temp: 18.6,
+			Identity: "04C0001F03000000", Model: "RESU_10_LV", SerialNum: 192, FirmwareVersion: "3.1", HardwareVersion: 3},
 	},
 	// configuration parameters (LG Resu -> Inverter):
 	{
 		Identifier: BMS_LIMITS,
 		Data:       [8]byte{0x41, 0x02, 0x96, 0x03, 0x96, 0x03, 0x00, 0x00},
 		Expect: LgResuStatus{Voltage: 54.51, Current: -1.9, Temp: 18.6,
-			MaxVoltage: 57.70, MaxChargeCurrent: 91.80, MaxDischargeCurrent: 91.80},
+			MaxVoltage: 57.70, MaxChargeCurrent: 91.80, MaxDischargeCurrent: 91.80,
+			Identity: "04C0001F03000000", Model: "RESU_10_LV", SerialNum: 192, FirmwareVersion: "3.1", HardwareVersion: 3},
 	},
 	// state of charge/health (LG Resu -> Inverter):
 	{
@@ -55,7 +58,8 @@ var CanbusTestMessages = []struct {
 		Data:       [8]byte{0x4d, 0x00, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00},
 		Expect: LgResuStatus{Voltage: 54.51, Current: -1.9, Temp: 18.6,
 			MaxVoltage: 57.70, MaxChargeCurrent: 91.80, MaxDischargeCurrent: 91.80,
-			Soc: 77, Soh: 99},
+			Soc: 77, Soh: 99,
+			Identity: "04C0001F03000000", Model: "RESU_10_LV", SerialNum: 192, FirmwareVersion: "3.1", HardwareVersion: 3},
 	},
 	// warnings/alarms (LG Resu -> Inverter):
 	{
@@ -64,20 +68,24 @@ var CanbusTestMessages = []struct {
 		Expect: LgResuStatus{Voltage: 54.51, Current: -1.9, Temp: 18.6,
 			MaxVoltage: 57.70, MaxChargeCurrent: 91.80, MaxDischargeCurrent: 91.80,
 			Soc: 77, Soh: 99,
+			Identity: "04C0001F03000000", Model: "RESU_10_LV", SerialNum: 192, FirmwareVersion: "3.1", HardwareVersion: 3,
 			Warnings: []string{"WRN_ONLY_SUB_RELAY_COMMAND", "BATTERY_HIGH_VOLTAGE", "BATTERY_LOW_VOLTAGE",
 				"BATTERY_HIGH_TEMP", "BATTERY_LOW_TEMP", "UNKNOWN_ww5", "UNKNOWN_ww6", "BATTERY_HIGH_CURRENT_DISCHARGE",
 				"BATTERY_HIGH_CURRENT_CHARGE", "UNKNOWN_WW1", "UNKNOWN_WW2", "BMS_INTERNAL", "CELL_IMBALANCE",
 				"ALARM_SUB_PACK2_ERROR", "ALARM_SUB_PACK1_ERROR", "UNKNOWN_WW7"},
-			Alarms: []string{"UNKNOWN_ALARM"},
+			Alarms: []string{"UNKNOWN_aa0", "UNKNOWN_aa1", "UNKNOWN_aa2", "UNKNOWN_aa3",
+				"UNKNOWN_aa4", "UNKNOWN_aa5", "UNKNOWN_aa6", "UNKNOWN_aa7",
+				"UNKNOWN_AA0", "UNKNOWN_AA1", "UNKNOWN_AA2", "UNKNOWN_AA3",
+				"UNKNOWN_AA4", "UNKNOWN_AA5", "UNKNOWN_AA6", "UNKNOWN_AA7"},
 		},
 	},
 }
 
-var JsonExpectMessage string = `{"soc":77,"soh":99,"voltage":54.51,"current":-1.9,"temp":18.6,"maxVoltage":57.7,"maxChargeCurrent":91.8,"maxDischargeCurrent":91.8,"warnings":["WRN_ONLY_SUB_RELAY_COMMAND","BATTERY_HIGH_VOLTAGE","BATTERY_LOW_VOLTAGE","BATTERY_HIGH_TEMP","BATTERY_LOW_TEMP","UNKNOWN_ww5","UNKNOWN_ww6","BATTERY_HIGH_CURRENT_DISCHARGE","BATTERY_HIGH_CURRENT_CHARGE","UNKNOWN_WW1","UNKNOWN_WW2","BMS_INTERNAL","CELL_IMBALANCE","ALARM_SUB_PACK2_ERROR","ALARM_SUB_PACK1_ERROR","UNKNOWN_WW7"],"alarms":["UNKNOWN_ALARM"]}`
+var JsonExpectMessage string = `{"soc":77,"soh":99,"voltage":54.51,"current":-1.9,"temp":18.6,"maxVoltage":57.7,"maxChargeCurrent":91.8,"maxDischargeCurrent":91.8,"identity":"04C0001F03000000","model":"RESU_10_LV","serialNum":192,"firmwareVersion":"3.1","hardwareVersion":3,"warnings":["WRN_ONLY_SUB_RELAY_COMMAND","BATTERY_HIGH_VOLTAGE","BATTERY_LOW_VOLTAGE","BATTERY_HIGH_TEMP","BATTERY_LOW_TEMP","UNKNOWN_ww5","UNKNOWN_ww6","BATTERY_HIGH_CURRENT_DISCHARGE","BATTERY_HIGH_CURRENT_CHARGE","UNKNOWN_WW1","UNKNOWN_WW2","BMS_INTERNAL","CELL_IMBALANCE","ALARM_SUB_PACK2_ERROR","ALARM_SUB_PACK1_ERROR","UNKNOWN_WW7"],"alarms":["UNKNOWN_aa0","UNKNOWN_aa1","UNKNOWN_aa2","UNKNOWN_aa3","UNKNOWN_aa4","UNKNOWN_aa5","UNKNOWN_aa6","UNKNOWN_aa7","UNKNOWN_AA0","UNKNOWN_AA1","UNKNOWN_AA2","UNKNOWN_AA3","UNKNOWN_AA4","UNKNOWN_AA5","UNKNOWN_AA6","UNKNOWN_AA7"],"requests":null,"manufacturer":"","decodeErrors":0,"unknownMessages":0,"power":0,"energy":{"chargedToday":0,"dischargedToday":0,"chargedMonth":0,"dischargedMonth":0,"chargedLifetime":0,"dischargedLifetime":0},"estimatedSoc":0,"socDivergence":0,"timeToEmpty":0,"timeToFull":0,"timeRemaining":"","messages":null,"offline":false}`
 
-var CsvRecordExpect string = "2018/06/11 00:00:00,77,54.51,-1.90\n"
+var CsvRecordExpect string = "2018/06/11 00:00:00,77,54.51,-1.90,0.0,0.0,0.0,18.6,0.0,0.0,0.0,0.0,0.0,0.0,online\n"
 
-var CsvRecordHeaderExpect string = "Time,Soc,Voltage,Current\n"
+var CsvRecordHeaderExpect string = "Time,Soc,Voltage,Current,Power,EstimatedSoc,SocDivergence,Temp,ChargedToday,DischargedToday,ChargedMonth,DischargedMonth,ChargedLifetime,DischargedLifetime,State\n"
 
 func init() {
 	// only log warning severity or above.
@@ -116,6 +124,76 @@ func TestLgResuStatusConversionToJson(t *testing.T) {
 	}
 }
 
+func TestDecodeUnknownModel(t *testing.T) {
+	lgResu := &LgResuStatus{}
+
+	lgResu.DecodeLgResuCanbusMessage(BMS_SERIAL_NUM, []byte{0x07, 0x01, 0x02, 0x0c, 0x01, 0x00, 0x00, 0x00})
+
+	expect := LgResuStatus{Identity: "0701020C01000000", Model: "UNKNOWN_MODEL_07", SerialNum: 513, FirmwareVersion: "1.2", HardwareVersion: 1}
+	if !cmp.Equal(*lgResu, expect) {
+		t.Errorf("lgResu.DecodeLgResuCanbusMessage(%x, ...) == %+v, expect %+v", BMS_SERIAL_NUM, *lgResu, expect)
+	}
+}
+
+func TestDecodeNegativeTemperature(t *testing.T) {
+	// -12.5 Celsius in an unheated garage
+	u, err := Decode(BMS_VOLT_AMP_TEMP, []byte{0x4b, 0x15, 0xed, 0xff, 0x83, 0xff, 0x00, 0x00})
+	if err != nil {
+		t.Fatalf("Decode(%x, ...) returned error %v", BMS_VOLT_AMP_TEMP, err)
+	}
+
+	if u.Status.Temp != -12.5 {
+		t.Errorf("Decode(%x, ...) produce Temp = %.1f, expect Temp = -12.5", BMS_VOLT_AMP_TEMP, u.Status.Temp)
+	}
+}
+
+func TestDecodeRequestsManufacturer(t *testing.T) {
+	lgResu := &LgResuStatus{}
+
+	// Pylontech: charge/discharge enabled, force charge I
+	lgResu.DecodeLgResuCanbusMessage(BMS_REQUEST, []byte{0xe0, 0x00})
+	lgResu.DecodeLgResuCanbusMessage(BMS_MANUFACTURER, []byte("PYLON   "))
+
+	expect := LgResuStatus{Requests: []string{"CHARGE_ENABLE", "DISCHARGE_ENABLE", "FORCE_CHARGE_1"}, Manufacturer: "PYLON"}
+	if !cmp.Equal(*lgResu, expect) {
+		t.Errorf("lgResu.DecodeLgResuCanbusMessage(%x/%x, ...) == %+v, expect %+v", BMS_REQUEST, BMS_MANUFACTURER, *lgResu, expect)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tm := range []struct {
+		Identifier uint32
+		Data       []byte
+		Expect     error
+	}{
+		{0x123, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, &UnknownMessageError{}},
+		{BMS_VOLT_AMP_TEMP, []byte{0x4b, 0x15, 0xed, 0xff}, &MessageLengthError{}},
+		{BMS_LIMITS, []byte{}, &MessageLengthError{}},
+		{BMS_SERIAL_NUM, []byte{0x04, 0xc0}, &MessageLengthError{}},
+		{BMS_SOC_SOH, []byte{0x4d, 0x00, 0x63}, &MessageLengthError{}},
+		{BMS_WARN_ALARM, []byte{0xff}, &MessageLengthError{}},
+		{BMS_REQUEST, []byte{}, &MessageLengthError{}},
+		{BMS_MANUFACTURER, []byte{}, &MessageLengthError{}},
+		{BMS_MANUFACTURER, []byte{0x4c, 0x47, 0x0a, 0x20, 0x20, 0x20, 0x20, 0x20}, &MalformedMessageError{}},
+		{BMS_SOC_SOH, []byte{0x4d, 0x01, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00}, &MalformedMessageError{}},
+	} {
+		u, err := Decode(tm.Identifier, tm.Data)
+		if reflect.TypeOf(err) != reflect.TypeOf(tm.Expect) {
+			t.Errorf("Decode(%x, % X) returned error %v (%T), expect %T", tm.Identifier, tm.Data, err, err, tm.Expect)
+		}
+		if u.Fields != 0 {
+			t.Errorf("Decode(%x, % X) returned Fields = %#x, expect 0", tm.Identifier, tm.Data, u.Fields)
+		}
+	}
+
+	// invalid messages do not update LgResuStatus
+	lgResu := &LgResuStatus{Soc: 50}
+	lgResu.DecodeLgResuCanbusMessage(BMS_SOC_SOH, []byte{0x4d})
+	if lgResu.Soc != 50 {
+		t.Errorf("lgResu.DecodeLgResuCanbusMessage(%x, 4D) produce Soc = %d, expect Soc = 50", BMS_SOC_SOH, lgResu.Soc)
+	}
+}
+
 func TestCreateKeepAliveMessage(t *testing.T) {
 	lgResu := &LgResuStatus{}
 
@@ -147,7 +225,7 @@ func TestCsvRecord(t *testing.T) {
 			csvRecord, CsvRecordExpect)
 	}
 
-	// number of CSV values should match number of CSV header values (4)
+	// number of CSV values should match number of CSV header values (15)
 	reader := csv.NewReader(strings.NewReader(csvRecord))
 	record, _ := reader.Read()
 
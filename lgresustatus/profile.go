@@ -0,0 +1,110 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// LG_RESU_PROFILE is the name of the LG Resu 10 LV BMS profile.
+const LG_RESU_PROFILE string = "lgresu"
+
+// Decoder decodes a single CANBus message of a BMS into an Update. Decode returns an
+// UnknownMessageError, MessageLengthError or MalformedMessageError for invalid messages.
+// Warnings and Alarms of the Update are nil if no warning/alarm is active.
+type Decoder interface {
+	Decode(id uint32, s []byte) (Update, error)
+}
+
+// BmsProfile describes the CANBus protocol of one type of BMS. Metrics of every
+// BMS type are decoded into the common LgResuStatus.
+type BmsProfile interface {
+	Decoder
+	// Name returns the name used to select the profile (for example: lgresu).
+	Name() string
+	// CreateKeepAliveMessage creates one 'keep alive' message (to be send to the BMS).
+	// s is nil if the BMS does not expect a 'keep alive' message.
+	CreateKeepAliveMessage() (id uint32, s []byte)
+}
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = make(map[string]BmsProfile)
+)
+
+// RegisterProfile makes a BMS profile available by its name. RegisterProfile
+// panics if it is called twice with the same name or if p is nil.
+func RegisterProfile(p BmsProfile) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	if p == nil {
+		panic("lgresustatus: RegisterProfile profile is nil")
+	}
+	if _, dup := profiles[p.Name()]; dup {
+		panic("lgresustatus: RegisterProfile called twice for profile " + p.Name())
+	}
+	profiles[p.Name()] = p
+}
+
+// LookupProfile returns the BMS profile registered as name.
+func LookupProfile(name string) (BmsProfile, error) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("lgresustatus: unknown BMS profile %q (registered: %v)", name, profileNames())
+	}
+	return p, nil
+}
+
+// Profiles returns a sorted list of the names of the registered BMS profiles.
+func Profiles() []string {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+
+	return profileNames()
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lgResuProfile is the BmsProfile of the LG Resu 10 LV.
+type lgResuProfile struct{}
+
+func (lgResuProfile) Name() string {
+	return LG_RESU_PROFILE
+}
+
+func (lgResuProfile) Decode(id uint32, s []byte) (Update, error) {
+	return Decode(id, s)
+}
+
+func (lgResuProfile) CreateKeepAliveMessage() (id uint32, s []byte) {
+	return (&LgResuStatus{}).CreateKeepAliveMessage()
+}
+
+func init() {
+	RegisterProfile(lgResuProfile{})
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+type testProfile struct {
+	name string
+}
+
+func (p testProfile) Name() string {
+	return p.name
+}
+
+func (p testProfile) Decode(id uint32, s []byte) (Update, error) {
+	return Update{Id: id, Fields: UPDATE_SOC, Status: LgResuStatus{Soc: uint16(s[0])}}, nil
+}
+
+func (p testProfile) CreateKeepAliveMessage() (id uint32, s []byte) {
+	return 0, nil
+}
+
+func TestLookupLgResuProfile(t *testing.T) {
+	p, err := LookupProfile(LG_RESU_PROFILE)
+	if err != nil {
+		t.Fatalf("LookupProfile(%q) returned error %v", LG_RESU_PROFILE, err)
+	}
+
+	lgResu := &LgResuStatus{}
+
+	// the LG Resu profile decodes exactly like DecodeLgResuCanbusMessage
+	for _, tm := range CanbusTestMessages {
+		u, err := p.Decode(tm.Identifier, tm.Data[:])
+		if err != nil {
+			t.Fatalf("p.Decode(%x, %+v) returned error %v", tm.Identifier, tm.Data, err)
+		}
+		u.Apply(lgResu)
+		if !cmp.Equal(*lgResu, tm.Expect) {
+			t.Errorf("p.Decode(%x, %+v) == %+v, expect %+v", tm.Identifier, tm.Data, *lgResu, tm.Expect)
+		}
+	}
+
+	id, data := p.CreateKeepAliveMessage()
+	if (id != INV_KEEP_ALIVE) || (len(data) != 8) {
+		t.Errorf("p.CreateKeepAliveMessage() returned id = %#04x, len(data) = %d, expect id = %#04x, len(data) = 8 \n",
+			id, len(data), INV_KEEP_ALIVE)
+	}
+}
+
+func TestLookupUnknownProfile(t *testing.T) {
+	if _, err := LookupProfile("unknown"); err == nil {
+		t.Errorf("LookupProfile(%q) returned no error, expect error", "unknown")
+	}
+}
+
+// unregisterProfile removes the profile name from the registry (allows repeated test runs).
+func unregisterProfile(name string) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	delete(profiles, name)
+}
+
+func TestRegisterProfile(t *testing.T) {
+	RegisterProfile(testProfile{"test"})
+	defer unregisterProfile("test")
+
+	p, err := LookupProfile("test")
+	if err != nil {
+		t.Fatalf("LookupProfile(%q) returned error %v", "test", err)
+	}
+
+	lgResu := &LgResuStatus{}
+	u, _ := p.Decode(0x100, []byte{42})
+	u.Apply(lgResu)
+	if lgResu.Soc != 42 {
+		t.Errorf("p.Decode() produce Soc = %d, expect Soc = 42", lgResu.Soc)
+	}
+
+	found := false
+	for _, name := range Profiles() {
+		if name == "test" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Profiles() == %v, expect list containing %q", Profiles(), "test")
+	}
+
+	// registering the same name twice panics
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterProfile() called twice for %q did not panic", "test")
+		}
+	}()
+	RegisterProfile(testProfile{"test"})
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"fmt"
+)
+
+// UpdateField identifies the LgResuStatus metrics contained in an Update.
+type UpdateField uint16
+
+// Definition of the LgResuStatus metrics that can be updated by a single CANBus message
+const (
+	UPDATE_SOC UpdateField = 1 << iota
+	UPDATE_SOH
+	UPDATE_VOLTAGE
+	UPDATE_CURRENT
+	UPDATE_TEMP
+	UPDATE_MAX_VOLTAGE
+	UPDATE_MAX_CHARGE_CURRENT
+	UPDATE_MAX_DISCHARGE_CURRENT
+	UPDATE_IDENTITY
+	UPDATE_WARNINGS
+	UPDATE_ALARMS
+	UPDATE_REQUESTS
+	UPDATE_MANUFACTURER
+)
+
+// UPDATE_OTHER_NODES contains the metrics of messages that are not send by the LG Resu 10 LV (charge/discharge
+// requests (0x35C) and manufacturer name (0x35E) of other CANBus nodes, for example: an inverter or an emulator).
+const UPDATE_OTHER_NODES UpdateField = UPDATE_REQUESTS | UPDATE_MANUFACTURER
+
+// Update contains the metrics decoded from a single CANBus message. Only the
+// metrics listed in Fields are valid.
+type Update struct {
+	Id     uint32
+	Fields UpdateField
+	Status LgResuStatus
+}
+
+// Apply merges the metrics contained in the update into lgResu.
+func (u Update) Apply(lgResu *LgResuStatus) {
+	if u.Fields&UPDATE_SOC != 0 {
+		lgResu.Soc = u.Status.Soc
+	}
+	if u.Fields&UPDATE_SOH != 0 {
+		lgResu.Soh = u.Status.Soh
+	}
+	if u.Fields&UPDATE_VOLTAGE != 0 {
+		lgResu.Voltage = u.Status.Voltage
+	}
+	if u.Fields&UPDATE_CURRENT != 0 {
+		lgResu.Current = u.Status.Current
+	}
+	if u.Fields&UPDATE_TEMP != 0 {
+		lgResu.Temp = u.Status.Temp
+	}
+	if u.Fields&UPDATE_MAX_VOLTAGE != 0 {
+		lgResu.MaxVoltage = u.Status.MaxVoltage
+	}
+	if u.Fields&UPDATE_MAX_CHARGE_CURRENT != 0 {
+		lgResu.MaxChargeCurrent = u.Status.MaxChargeCurrent
+	}
+	if u.Fields&UPDATE_MAX_DISCHARGE_CURRENT != 0 {
+		lgResu.MaxDischargeCurrent = u.Status.MaxDischargeCurrent
+	}
+	if u.Fields&UPDATE_IDENTITY != 0 {
+		lgResu.Identity = u.Status.Identity
+		lgResu.Model = u.Status.Model
+		lgResu.SerialNum = u.Status.SerialNum
+		lgResu.FirmwareVersion = u.Status.FirmwareVersion
+		lgResu.HardwareVersion = u.Status.HardwareVersion
+	}
+	if u.Fields&UPDATE_WARNINGS != 0 {
+		lgResu.Warnings = u.Status.Warnings
+	}
+	if u.Fields&UPDATE_ALARMS != 0 {
+		lgResu.Alarms = u.Status.Alarms
+	}
+	if u.Fields&UPDATE_REQUESTS != 0 {
+		lgResu.Requests = u.Status.Requests
+	}
+	if u.Fields&UPDATE_MANUFACTURER != 0 {
+		lgResu.Manufacturer = u.Status.Manufacturer
+	}
+}
+
+// HasReceived returns true if all metrics in fields have been received from the BMS. Metrics are
+// valid only after they have been received (for example: 0 C is a valid temperature, a BMS profile
+// may not report the SOH).
+func (lgResu *LgResuStatus) HasReceived(fields UpdateField) bool {
+	return lgResu.ReceivedFields&fields == fields
+}
+
+// UnknownMessageError is returned by a Decoder for a message id that is not part of the BMS protocol.
+type UnknownMessageError struct {
+	Id uint32
+}
+
+func (e *UnknownMessageError) Error() string {
+	return fmt.Sprintf("unknown message id %#04x", e.Id)
+}
+
+// MessageLengthError is returned by a Decoder for a message that is shorter than
+// required by the BMS protocol.
+type MessageLengthError struct {
+	Id     uint32
+	Length int
+	Expect int
+}
+
+func (e *MessageLengthError) Error() string {
+	return fmt.Sprintf("message id %#04x has %d data bytes, expect at least %d", e.Id, e.Length, e.Expect)
+}
+
+// MalformedMessageError is returned by a Decoder for a message with a payload outside of
+// the valid range.
+type MalformedMessageError struct {
+	Id     uint32
+	Reason string
+}
+
+func (e *MalformedMessageError) Error() string {
+	return fmt.Sprintf("malformed message id %#04x: %s", e.Id, e.Reason)
+}
+
+// CheckLength returns a MessageLengthError if s contains less than length bytes.
+func CheckLength(id uint32, s []byte, length int) error {
+	if len(s) < length {
+		return &MessageLengthError{Id: id, Length: len(s), Expect: length}
+	}
+	return nil
+}
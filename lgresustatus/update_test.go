@@ -0,0 +1,49 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lgresustatus
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+// TestUpdateApply tests that only the metrics listed in Fields are merged.
+func TestUpdateApply(t *testing.T) {
+	lgResu := &LgResuStatus{Soc: 77, Soh: 99, Voltage: 54.51, Warnings: []string{"BATTERY_HIGH_TEMP"}}
+
+	u := Update{
+		Id:     BMS_WARN_ALARM,
+		Fields: UPDATE_VOLTAGE | UPDATE_WARNINGS,
+		Status: LgResuStatus{Soc: 1, Voltage: 53.2},
+	}
+	u.Apply(lgResu)
+
+	expect := LgResuStatus{Soc: 77, Soh: 99, Voltage: 53.2}
+	if !cmp.Equal(*lgResu, expect) {
+		t.Errorf("u.Apply() produce %+v, expect %+v", *lgResu, expect)
+	}
+}
+
+// TestCheckLength tests the minimal message length check.
+func TestCheckLength(t *testing.T) {
+	if err := CheckLength(BMS_SOC_SOH, make([]byte, 4), 4); err != nil {
+		t.Errorf("CheckLength(%x, 4 bytes, 4) returned error %v, expect nil", BMS_SOC_SOH, err)
+	}
+
+	err := CheckLength(BMS_SOC_SOH, make([]byte, 3), 4)
+	if e, ok := err.(*MessageLengthError); !ok || e.Length != 3 || e.Expect != 4 {
+		t.Errorf("CheckLength(%x, 3 bytes, 4) returned error %v, expect MessageLengthError", BMS_SOC_SOH, err)
+	}
+}
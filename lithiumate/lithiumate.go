@@ -0,0 +1,253 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lithiumate provides routines to decode Elithion Lithiumate BMS
+// CANBus messages into the common lgresustatus.LgResuStatus.
+//
+// Importing the package registers the BMS profile 'lithiumate':
+//
+//     import _ "github.com/jens18/lgresu/lithiumate"
+//
+// Note:
+//
+// The Lithiumate controller uses a configurable base CANBus message id,
+// the profile assumes the factory default (0x620). Multi-byte values are
+// send MSB first (bigEndian). The profile does not create a 'keep alive'
+// message.
+//
+// CANBus BMS Message format specification:
+//
+// http://lithiumate.elithion.com/php/controller_can_specs.php
+//
+package lithiumate
+
+import (
+	"encoding/binary"
+	"fmt"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+)
+
+// LITHIUMATE_PROFILE is the name of the Elithion Lithiumate BMS profile.
+const LITHIUMATE_PROFILE string = "lithiumate"
+
+// Definition of the Lithiumate CANBus message id's (factory default base id 0x620)
+const (
+	LTM_BASE        uint32 = 0x620
+	LTM_STATE       uint32 = LTM_BASE + 2
+	LTM_VOLTAGE     uint32 = LTM_BASE + 3
+	LTM_CURRENT     uint32 = LTM_BASE + 4
+	LTM_SOC         uint32 = LTM_BASE + 6
+	LTM_TEMPERATURE uint32 = LTM_BASE + 7
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+// FaultBitValue contains a single fault/warning bit mask and definition.
+type FaultBitValue struct {
+	Description string
+	Value       uint8
+}
+
+// LevelFaultBitValues defines 8 level fault flags (reported as alarms).
+//
+// Raw CANBus message format:
+//
+// 00000622 8 ss tt tt ff cc LL WW 00
+//
+//  LL0 BATTERY_HIGH_VOLTAGE
+//  LL1 BATTERY_LOW_VOLTAGE
+//  LL2 BATTERY_HIGH_TEMP
+//  LL3 BATTERY_HIGH_CURRENT_DISCHARGE
+//  LL4 BATTERY_HIGH_CURRENT_CHARGE
+//  LL5 COMMUNICATION_FAULT
+//  LL6 INTERLOCK_TRIPPED
+//  LL7 DRIVING_WHILE_PLUGGED_IN
+var LevelFaultBitValues = []FaultBitValue{
+	{"BATTERY_HIGH_VOLTAGE", 0x01},
+	{"BATTERY_LOW_VOLTAGE", 0x02},
+	{"BATTERY_HIGH_TEMP", 0x04},
+	{"BATTERY_HIGH_CURRENT_DISCHARGE", 0x08},
+	{"BATTERY_HIGH_CURRENT_CHARGE", 0x10},
+	{"COMMUNICATION_FAULT", 0x20},
+	{"INTERLOCK_TRIPPED", 0x40},
+	{"DRIVING_WHILE_PLUGGED_IN", 0x80},
+}
+
+// WarningBitValues defines 8 warning flags.
+//
+// Raw CANBus message format:
+//
+// 00000622 8 ss tt tt ff cc LL WW 00
+//
+//  WW0 ISOLATION_FAULT
+//  WW1 LOW_SOH
+//  WW2 BATTERY_HIGH_TEMP
+//  WW3 BATTERY_LOW_TEMP
+//  WW4 BATTERY_HIGH_CURRENT_DISCHARGE
+//  WW5 BATTERY_HIGH_CURRENT_CHARGE
+//  WW6 BATTERY_HIGH_VOLTAGE
+//  WW7 BATTERY_LOW_VOLTAGE
+var WarningBitValues = []FaultBitValue{
+	{"ISOLATION_FAULT", 0x01},
+	{"LOW_SOH", 0x02},
+	{"BATTERY_HIGH_TEMP", 0x04},
+	{"BATTERY_LOW_TEMP", 0x08},
+	{"BATTERY_HIGH_CURRENT_DISCHARGE", 0x10},
+	{"BATTERY_HIGH_CURRENT_CHARGE", 0x20},
+	{"BATTERY_HIGH_VOLTAGE", 0x40},
+	{"BATTERY_LOW_VOLTAGE", 0x80},
+}
+
+// decodeFlags returns the descriptions of all flags set in data.
+func decodeFlags(data uint8, bitValues []FaultBitValue) []string {
+	var flags []string
+	for _, bv := range bitValues {
+		if data&bv.Value != 0 {
+			flags = append(flags, bv.Description)
+		}
+	}
+	return flags
+}
+
+// Decode decodes a single message send by the Lithiumate BMS. Decode returns an
+// rs.UnknownMessageError for message id's that are not decoded by the profile and
+// an rs.MessageLengthError for messages that are too short.
+func Decode(id uint32, s []byte) (rs.Update, error) {
+
+	u := rs.Update{Id: id}
+	lgResu := &u.Status
+
+	log.Debugf("%-4x % -24X\n", id, s)
+
+	switch id {
+	case LTM_VOLTAGE:
+		log.Debugf("LTM: voltage (%#04x)\n", LTM_VOLTAGE)
+
+		if err := rs.CheckLength(id, s, 6); err != nil {
+			return u, err
+		}
+
+		// unsigned: pack voltage in 1 V steps
+		data := binary.BigEndian.Uint16(s[0:2])
+		lgResu.Voltage = float32(data)
+		log.Debugf("voltage = %.2f [VDC]\n", lgResu.Voltage)
+
+		// unsigned: cell voltage in 0.1 V steps
+		log.Debugf("min cell voltage = %.1f [VDC] (cell %d)\n", float32(s[2])/10, s[3])
+		log.Debugf("max cell voltage = %.1f [VDC] (cell %d)\n\n", float32(s[4])/10, s[5])
+
+		u.Fields = rs.UPDATE_VOLTAGE
+
+	case LTM_CURRENT:
+		log.Debugf("LTM: current (%#04x)\n", LTM_CURRENT)
+
+		if err := rs.CheckLength(id, s, 6); err != nil {
+			return u, err
+		}
+
+		// signed: + battery is discharged, - battery is charged (inverted in LgResuStatus)
+		data := binary.BigEndian.Uint16(s[0:2])
+		lgResu.Current = -float32(int16(data))
+		log.Debugf("current = %.2f [ADC]\n", lgResu.Current)
+
+		// unsigned: ADC
+		data = binary.BigEndian.Uint16(s[2:4])
+		lgResu.MaxChargeCurrent = float32(data)
+		log.Debugf("max charge current = %.2f [ADC]\n", lgResu.MaxChargeCurrent)
+
+		// unsigned: ADC
+		data = binary.BigEndian.Uint16(s[4:6])
+		lgResu.MaxDischargeCurrent = float32(data)
+		log.Debugf("max discharge current = %.2f [ADC]\n\n", lgResu.MaxDischargeCurrent)
+
+		u.Fields = rs.UPDATE_CURRENT | rs.UPDATE_MAX_CHARGE_CURRENT | rs.UPDATE_MAX_DISCHARGE_CURRENT
+
+	case LTM_SOC:
+		log.Debugf("LTM: state of charge (%#04x)\n", LTM_SOC)
+
+		if err := rs.CheckLength(id, s, 7); err != nil {
+			return u, err
+		}
+
+		lgResu.Soc = uint16(s[0])
+		log.Debugf("soc = %d %%\n", lgResu.Soc)
+
+		log.Debugf("depth of discharge = %d [Ah]\n", binary.BigEndian.Uint16(s[1:3]))
+		log.Debugf("capacity = %d [Ah]\n", binary.BigEndian.Uint16(s[3:5]))
+
+		lgResu.Soh = uint16(s[6])
+		log.Debugf("soh = %d %%\n\n", lgResu.Soh)
+
+		if lgResu.Soc > 100 || lgResu.Soh > 100 {
+			return u, &rs.MalformedMessageError{Id: id,
+				Reason: fmt.Sprintf("soc = %d %%, soh = %d %% (expect <= 100 %%)", lgResu.Soc, lgResu.Soh)}
+		}
+
+		u.Fields = rs.UPDATE_SOC | rs.UPDATE_SOH
+
+	case LTM_TEMPERATURE:
+		log.Debugf("LTM: temperature (%#04x)\n", LTM_TEMPERATURE)
+
+		if err := rs.CheckLength(id, s, 6); err != nil {
+			return u, err
+		}
+
+		// signed: average temperature in Celsius
+		lgResu.Temp = float32(int8(s[0]))
+		log.Debugf("temperature = %.1f [Celsius]\n", lgResu.Temp)
+
+		log.Debugf("min temperature = %d [Celsius] (sensor %d)\n", int8(s[2]), s[3])
+		log.Debugf("max temperature = %d [Celsius] (sensor %d)\n\n", int8(s[4]), s[5])
+
+		u.Fields = rs.UPDATE_TEMP
+
+	case LTM_STATE:
+		log.Debugf("LTM: state (%#04x)\n\n", LTM_STATE)
+
+		if err := rs.CheckLength(id, s, 7); err != nil {
+			return u, err
+		}
+
+		lgResu.Alarms = decodeFlags(s[5], LevelFaultBitValues)
+		lgResu.Warnings = decodeFlags(s[6], WarningBitValues)
+
+		u.Fields = rs.UPDATE_WARNINGS | rs.UPDATE_ALARMS
+
+	default:
+		return u, &rs.UnknownMessageError{Id: id}
+	}
+
+	return u, nil
+}
+
+// profile is the BmsProfile of the Lithiumate BMS.
+type profile struct{}
+
+func (profile) Name() string {
+	return LITHIUMATE_PROFILE
+}
+
+func (profile) Decode(id uint32, s []byte) (rs.Update, error) {
+	return Decode(id, s)
+}
+
+func (profile) CreateKeepAliveMessage() (id uint32, s []byte) {
+	return 0, nil
+}
+
+func init() {
+	rs.RegisterProfile(profile{})
+}
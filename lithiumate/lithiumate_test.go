@@ -0,0 +1,128 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lithiumate
+
+import (
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"testing"
+)
+
+// Lithiumate CANBus test messages
+var CanbusTestMessages = []struct {
+	Identifier uint32
+	Data       [8]byte
+	Expect     rs.LgResuStatus
+}{
+	// voltage (Lithiumate -> Inverter): pack 52 V, min cell 3.2 V (cell 5), max cell 3.4 V (cell 12)
+	{
+		Identifier: LTM_VOLTAGE,
+		Data:       [8]byte{0x00, 0x34, 0x20, 0x05, 0x22, 0x0c, 0x00, 0x00},
+		Expect:     rs.LgResuStatus{Voltage: 52},
+	},
+	// current (Lithiumate -> Inverter): 15 A discharge, charge limit 100 A, discharge limit 150 A
+	{
+		Identifier: LTM_CURRENT,
+		Data:       [8]byte{0x00, 0x0f, 0x00, 0x64, 0x00, 0x96, 0x00, 0x00},
+		Expect: rs.LgResuStatus{Voltage: 52, Current: -15,
+			MaxChargeCurrent: 100, MaxDischargeCurrent: 150},
+	},
+	// state of charge (Lithiumate -> Inverter): 64 %, DOD 36 Ah, capacity 100 Ah, SOH 97 %
+	{
+		Identifier: LTM_SOC,
+		Data:       [8]byte{0x40, 0x00, 0x24, 0x00, 0x64, 0x00, 0x61, 0x00},
+		Expect: rs.LgResuStatus{Voltage: 52, Current: -15,
+			MaxChargeCurrent: 100, MaxDischargeCurrent: 150,
+			Soc: 64, Soh: 97},
+	},
+	// temperature (Lithiumate -> Inverter): average -5 C, min -7 C (sensor 2), max -3 C (sensor 9)
+	{
+		Identifier: LTM_TEMPERATURE,
+		Data:       [8]byte{0xfb, 0x00, 0xf9, 0x02, 0xfd, 0x09, 0x00, 0x00},
+		Expect: rs.LgResuStatus{Voltage: 52, Current: -15, Temp: -5,
+			MaxChargeCurrent: 100, MaxDischargeCurrent: 150,
+			Soc: 64, Soh: 97},
+	},
+	// state (Lithiumate -> Inverter): over voltage fault, cold temperature warning
+	{
+		Identifier: LTM_STATE,
+		Data:       [8]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00},
+		Expect: rs.LgResuStatus{Voltage: 52, Current: -15, Temp: -5,
+			MaxChargeCurrent: 100, MaxDischargeCurrent: 150,
+			Soc: 64, Soh: 97,
+			Warnings: []string{"BATTERY_LOW_TEMP"},
+			Alarms:   []string{"BATTERY_HIGH_VOLTAGE"}},
+	},
+	// state (Lithiumate -> Inverter): all faults and warnings cleared
+	{
+		Identifier: LTM_STATE,
+		Data:       [8]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		Expect: rs.LgResuStatus{Voltage: 52, Current: -15, Temp: -5,
+			MaxChargeCurrent: 100, MaxDischargeCurrent: 150,
+			Soc: 64, Soh: 97},
+	},
+}
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+func TestDecodeLithiumateCanbusMessageToUpdateLgResuStatus(t *testing.T) {
+
+	p, err := rs.LookupProfile(LITHIUMATE_PROFILE)
+	if err != nil {
+		t.Fatalf("rs.LookupProfile(%q) returned error %v", LITHIUMATE_PROFILE, err)
+	}
+
+	lgResu := &rs.LgResuStatus{}
+
+	// process all test messages (the last state message clears the warnings and alarms to nil)
+	for _, tm := range CanbusTestMessages {
+		u, err := p.Decode(tm.Identifier, tm.Data[:])
+		if err != nil {
+			t.Fatalf("p.Decode(%x, %+v) returned error %v", tm.Identifier, tm.Data, err)
+		}
+		u.Apply(lgResu)
+		if !cmp.Equal(*lgResu, tm.Expect) {
+			t.Errorf("p.Decode(%x, %+v) == %+v, expect %+v", tm.Identifier, tm.Data, *lgResu, tm.Expect)
+		}
+	}
+}
+
+func TestLookupLithiumateProfile(t *testing.T) {
+	p, err := rs.LookupProfile(LITHIUMATE_PROFILE)
+	if err != nil {
+		t.Fatalf("rs.LookupProfile(%q) returned error %v", LITHIUMATE_PROFILE, err)
+	}
+
+	lgResu := &rs.LgResuStatus{}
+	tm := CanbusTestMessages[0]
+
+	u, err := p.Decode(tm.Identifier, tm.Data[:])
+	if err != nil {
+		t.Fatalf("p.Decode(%x, %+v) returned error %v", tm.Identifier, tm.Data, err)
+	}
+	u.Apply(lgResu)
+	if !cmp.Equal(*lgResu, tm.Expect) {
+		t.Errorf("p.Decode(%x, %+v) == %+v, expect %+v", tm.Identifier, tm.Data, *lgResu, tm.Expect)
+	}
+
+	// Lithiumate does not use a keep alive message
+	if _, data := p.CreateKeepAliveMessage(); data != nil {
+		t.Errorf("p.CreateKeepAliveMessage() returned data = % X, expect nil", data)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pylontech provides routines to translate the LG Resu 10 LV status
+// (lgresustatus.LgResuStatus) into Pylontech (SMA compatible) CANBus messages.
+//
+// Inverters that only accept Pylontech batteries can be connected to an
+// LG Resu 10 LV through a Bridge:
+//
+//	b := pylontech.NewBridge(30 * time.Second)
+//	// for every LG Resu 10 LV status update:
+//	b.Update(time.Now(), lgResu)
+//	// every second:
+//	for _, m := range b.Messages(time.Now()) {
+//	    // publish m.Id, m.Data on the inverter CANBus
+//	}
+//
+// Note:
+//
+// Limits (0x351), state of charge/health (0x355) and volt/amp/temp (0x356)
+// use the scaling of the LG Resu 10 LV messages. Protection/alarm flags
+// (0x359), charge/discharge requests (0x35C) and the manufacturer name
+// (0x35E) are created from the LG Resu 10 LV warnings/alarms and limits.
+//
+// CANBus BMS Message format specification:
+//
+// Pylontech LV CAN protocol (SMA Sunny Island compatible), version 1.2.
+package pylontech
+
+import (
+	"encoding/binary"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	"math"
+	"sync"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// manufacturer name (0x35E) expected by inverters
+	MANUFACTURER string = "PYLON"
+	// discharge voltage limit of the LG Resu 10 LV (14S, 3.0V per cell) in V
+	MIN_VOLTAGE float32 = 42.0
+	// number of battery modules (0x359)
+	MODULES uint8 = 1
+	// interval between two blocks of messages (Pylontech batteries send every second)
+	PUBLISH_INTERVAL time.Duration = 1 * time.Second
+)
+
+// Pylontech protection/alarm bits (0x359).
+const (
+	BIT_HIGH_VOLTAGE           uint16 = 0x0002
+	BIT_LOW_VOLTAGE            uint16 = 0x0004
+	BIT_HIGH_TEMP              uint16 = 0x0008
+	BIT_LOW_TEMP               uint16 = 0x0010
+	BIT_HIGH_CURRENT_DISCHARGE uint16 = 0x0080
+	BIT_HIGH_CURRENT_CHARGE    uint16 = 0x0100
+	BIT_SYSTEM_ERROR           uint16 = 0x0800
+)
+
+// AlarmBitValues maps the LG Resu 10 LV warnings to the Pylontech alarm bits.
+//
+// Raw CANBus message format:
+//
+// 00000359 8 pp PP aa AA nn 50 4E 00
+//
+//	pp PP protection bits (LG Resu 10 LV alarm: BIT_SYSTEM_ERROR)
+//	aa AA alarm bits
+//	nn    number of modules
+//	50 4E 'PN'
+var AlarmBitValues = []rs.BitValue{
+	{Description: "BATTERY_HIGH_VOLTAGE", Value: BIT_HIGH_VOLTAGE},
+	{Description: "BATTERY_LOW_VOLTAGE", Value: BIT_LOW_VOLTAGE},
+	{Description: "BATTERY_HIGH_TEMP", Value: BIT_HIGH_TEMP},
+	{Description: "BATTERY_LOW_TEMP", Value: BIT_LOW_TEMP},
+	{Description: "BATTERY_HIGH_CURRENT_DISCHARGE", Value: BIT_HIGH_CURRENT_DISCHARGE},
+	{Description: "BATTERY_HIGH_CURRENT_CHARGE", Value: BIT_HIGH_CURRENT_CHARGE},
+	{Description: "BMS_INTERNAL", Value: BIT_SYSTEM_ERROR},
+}
+
+// Message contains a single Pylontech CANBus message.
+type Message struct {
+	Id   uint32
+	Data []byte
+}
+
+// EncodeLimits creates one charge/discharge limits message (0x351): charge voltage, charge current,
+// discharge current (0.1 resolution) and discharge voltage (MIN_VOLTAGE).
+func EncodeLimits(lgResu *rs.LgResuStatus) Message {
+	_, s := lgResu.EncodeLimits()
+	binary.LittleEndian.PutUint16(s[6:8], uint16(math.Round(float64(MIN_VOLTAGE)*10)))
+	return Message{rs.BMS_LIMITS, s}
+}
+
+// EncodeProtectionAlarm creates one protection/alarm message (0x359). LG Resu 10 LV warnings are mapped to
+// alarm bits (AlarmBitValues), any LG Resu 10 LV alarm sets the protection bit BIT_SYSTEM_ERROR.
+func EncodeProtectionAlarm(lgResu *rs.LgResuStatus) Message {
+	var protection, alarm uint16
+
+	for _, w := range lgResu.Warnings {
+		for _, bv := range AlarmBitValues {
+			if w == bv.Description {
+				alarm |= bv.Value
+			}
+		}
+	}
+	if len(lgResu.Alarms) != 0 {
+		protection |= BIT_SYSTEM_ERROR
+	}
+
+	s := make([]byte, 8)
+	binary.LittleEndian.PutUint16(s[0:2], protection)
+	binary.LittleEndian.PutUint16(s[2:4], alarm)
+	s[4] = MODULES
+	s[5], s[6] = 'P', 'N'
+	return Message{rs.BMS_WARN_ALARM, s}
+}
+
+// Requests returns the charge/discharge requests (0x35C): charging (discharging) is enabled if the
+// charge (discharge) current limit is not 0 and no LG Resu 10 LV alarm is active.
+func Requests(lgResu *rs.LgResuStatus) []string {
+	requests := []string{}
+	if len(lgResu.Alarms) != 0 {
+		return requests
+	}
+	if lgResu.MaxChargeCurrent > 0 {
+		requests = append(requests, "CHARGE_ENABLE")
+	}
+	if lgResu.MaxDischargeCurrent > 0 {
+		requests = append(requests, "DISCHARGE_ENABLE")
+	}
+	return requests
+}
+
+// Encode creates the Pylontech messages (0x351, 0x355, 0x356, 0x359, 0x35C, 0x35E) for lgResu.
+func Encode(lgResu *rs.LgResuStatus) []Message {
+	// charge/discharge requests and manufacturer name are not send by the LG Resu 10 LV
+	status := *lgResu
+	status.Requests = Requests(lgResu)
+	status.Manufacturer = MANUFACTURER
+
+	messages := []Message{EncodeLimits(&status)}
+
+	id, s := status.EncodeSocSoh()
+	messages = append(messages, Message{id, s})
+
+	id, s = status.EncodeVoltAmpTemp()
+	messages = append(messages, Message{id, s})
+
+	messages = append(messages, EncodeProtectionAlarm(&status))
+
+	id, s = status.EncodeRequests()
+	messages = append(messages, Message{id, s})
+
+	id, s = status.EncodeManufacturer()
+	messages = append(messages, Message{id, s})
+
+	return messages
+}
+
+// Bridge translates the latest LG Resu 10 LV status into Pylontech messages. Bridge can be used
+// concurrently.
+type Bridge struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	status  rs.LgResuStatus
+	updated time.Time
+}
+
+// NewBridge is the constructor for Bridge. No messages are created if the status has not been updated
+// within timeout (the inverter detects the missing battery).
+func NewBridge(timeout time.Duration) *Bridge {
+	return &Bridge{timeout: timeout}
+}
+
+// Update stores the latest LG Resu 10 LV status (implements the StatusUpdaterIf interface of lgresu_mon).
+func (b *Bridge) Update(t time.Time, lgResu *rs.LgResuStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.status = *lgResu
+	b.status.Warnings = append([]string{}, lgResu.Warnings...)
+	b.status.Alarms = append([]string{}, lgResu.Alarms...)
+	b.updated = t
+}
+
+// Messages returns the Pylontech messages for the latest status. Messages returns nil until SOC and
+// voltage have been received and if the status has not been updated within timeout. The SOH is 0 if
+// the BMS profile does not report the SOH.
+func (b *Bridge) Messages(t time.Time) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.status.HasReceived(rs.UPDATE_SOC|rs.UPDATE_VOLTAGE) || t.Sub(b.updated) > b.timeout {
+		return nil
+	}
+	return Encode(&b.status)
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pylontech
+
+import (
+	"github.com/google/go-cmp/cmp"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"testing"
+	"time"
+)
+
+// LG Resu 10 LV status (discharging, high temperature warning)
+var TestStatus = rs.LgResuStatus{
+	Soc: 77, Soh: 99, Voltage: 54.51, Current: -1.9, Temp: 18.6,
+	MaxVoltage: 57.70, MaxChargeCurrent: 91.80, MaxDischargeCurrent: 91.80,
+	Warnings:       []string{"BATTERY_HIGH_TEMP", "CELL_IMBALANCE"},
+	ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_SOH | rs.UPDATE_VOLTAGE | rs.UPDATE_CURRENT | rs.UPDATE_TEMP,
+}
+
+// Pylontech messages expected for TestStatus
+var TestMessages = []Message{
+	{rs.BMS_LIMITS, []byte{0x41, 0x02, 0x96, 0x03, 0x96, 0x03, 0xa4, 0x01}},
+	{rs.BMS_SOC_SOH, []byte{0x4d, 0x00, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{rs.BMS_VOLT_AMP_TEMP, []byte{0x4b, 0x15, 0xed, 0xff, 0xba, 0x00, 0x00, 0x00}},
+	{rs.BMS_WARN_ALARM, []byte{0x00, 0x00, 0x08, 0x00, 0x01, 0x50, 0x4e, 0x00}},
+	{rs.BMS_REQUEST, []byte{0xc0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{rs.BMS_MANUFACTURER, []byte("PYLON   ")},
+}
+
+func init() {
+	log.SetLevel(log.WarnLevel)
+}
+
+func TestEncode(t *testing.T) {
+	messages := Encode(&TestStatus)
+
+	if !cmp.Equal(messages, TestMessages) {
+		t.Errorf("Encode() == %X, expect %X", messages, TestMessages)
+	}
+}
+
+func TestEncodeAlarm(t *testing.T) {
+	lgResu := TestStatus
+	lgResu.Alarms = []string{"UNKNOWN_aa0"}
+
+	m := EncodeProtectionAlarm(&lgResu)
+	if m.Data[0] != 0x00 || m.Data[1] != 0x08 {
+		t.Errorf("EncodeProtectionAlarm() == % X, expect protection bit BIT_SYSTEM_ERROR", m.Data)
+	}
+
+	// charging/discharging is disabled while an alarm is active
+	if r := Requests(&lgResu); len(r) != 0 {
+		t.Errorf("Requests() == %v, expect []", r)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	b := NewBridge(30 * time.Second)
+	now := time.Now()
+
+	if m := b.Messages(now); m != nil {
+		t.Errorf("b.Messages() == %X before the first update, expect nil", m)
+	}
+
+	// SOC has not been received yet
+	b.Update(now, &rs.LgResuStatus{Voltage: 54.51, ReceivedFields: rs.UPDATE_VOLTAGE})
+	if m := b.Messages(now); m != nil {
+		t.Errorf("b.Messages() == %X before SOC is received, expect nil", m)
+	}
+
+	// BMS profile without SOH
+	b.Update(now, &rs.LgResuStatus{Soc: 77, Voltage: 54.51, ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_VOLTAGE})
+	if m := b.Messages(now); len(m) != len(TestMessages) {
+		t.Errorf("b.Messages() == %X without SOH, expect %d messages", m, len(TestMessages))
+	}
+
+	b.Update(now, &TestStatus)
+	if m := b.Messages(now.Add(30 * time.Second)); !cmp.Equal(m, TestMessages) {
+		t.Errorf("b.Messages() == %X, expect %X", m, TestMessages)
+	}
+
+	// LG Resu 10 LV is offline
+	if m := b.Messages(now.Add(31 * time.Second)); m != nil {
+		t.Errorf("b.Messages() == %X after timeout, expect nil", m)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package socestimator estimates the SOC independently from the BMS by
+// coulomb counting (integration of the battery current).
+//
+// The estimated SOC is initialized with the SOC reported by the BMS and
+// recalibrated to 100% at the end of every full charge: the battery voltage
+// is near the maximal charge voltage (MaxVoltage - FULL_VOLTAGE_DELTA) and
+// the charge current has tapered off (below FULL_CURRENT) for at least
+// FULL_TIME.
+//
+// The divergence between the estimated SOC and the SOC reported by the BMS
+// indicates a calibration drift of the BMS (or of the estimation).
+//
+// The estimated charge is persisted in a JSON file and survives restarts of
+// lgresu_mon (a restart does not hide the drift by re-initializing the
+// estimate with the BMS SOC). Intervals without updates longer than
+// MAX_INTEGRATION_INTERVAL are not integrated.
+//
+// Example JSON file:
+//
+//	{"time":"2018-06-11T18:01:53-07:00","charge":143.2,"current":-1.9}
+package socestimator
+
+import (
+	"encoding/json"
+	dr "github.com/jens18/lgresu/datarecorder"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// filename of the persisted estimated charge (in the datafile root directory)
+	SOC_FILE_NAME string = "soc.json"
+	// interval between two saves of the estimated charge
+	SAVE_INTERVAL time.Duration = 60 * time.Second
+	// longer intervals between two updates are not integrated
+	MAX_INTEGRATION_INTERVAL time.Duration = 30 * time.Second
+	// full charge: voltage >= MaxVoltage - FULL_VOLTAGE_DELTA (V)
+	FULL_VOLTAGE_DELTA float32 = 0.3
+	// full charge: current < FULL_CURRENT (A)
+	FULL_CURRENT float32 = 4
+	// full charge: voltage and current conditions are met for FULL_TIME
+	FULL_TIME time.Duration = 2 * time.Minute
+)
+
+// estimatorState contains the persisted state of the Estimator.
+type estimatorState struct {
+	// time and current of the last update
+	Time    time.Time `json:"time"`
+	Current float32   `json:"current"`
+	// charge in Ah (negative: not initialized)
+	Charge float64 `json:"charge"`
+}
+
+// Estimator estimates the SOC by coulomb counting. Estimator can be used concurrently.
+type Estimator struct {
+	mu       sync.Mutex
+	fileName string
+	capacity float32
+	state    estimatorState
+	// start of the full charge condition (zero: condition not met)
+	full  time.Time
+	saved time.Time
+}
+
+// NewEstimator is the constructor for Estimator. fileName is the JSON file used to persist the estimated
+// charge (no persistence if fileName is empty), capacity is the nominal battery capacity in Ah. An existing
+// estimated charge is read from fileName, otherwise the estimate is initialized with the BMS SOC.
+func NewEstimator(fileName string, capacity float32) *Estimator {
+	e := &Estimator{fileName: fileName, capacity: capacity, state: estimatorState{Charge: -1}}
+
+	if len(fileName) == 0 {
+		return e
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		log.Infof("NewEstimator: %s does not exist, estimated SOC starts with the BMS SOC\n", fileName)
+		return e
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &e.state)
+	}
+	if err != nil {
+		log.Warnf("NewEstimator: could not read %s (%v), estimated SOC starts with the BMS SOC\n", fileName, err)
+		e.state = estimatorState{Charge: -1}
+	}
+	return e
+}
+
+// Update integrates the battery current since the last update and reports the estimated SOC and
+// the divergence from the SOC reported by the BMS in lgResu.
+func (e *Estimator) Update(t time.Time, lgResu *rs.LgResuStatus) {
+	// SOC has not been received yet
+	if !lgResu.HasReceived(rs.UPDATE_SOC) {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// nominal capacity if the BMS profile does not report the SOH
+	capacity := float64(e.capacity)
+	if lgResu.HasReceived(rs.UPDATE_SOH) && lgResu.Soh > 0 && lgResu.Soh <= 100 {
+		capacity = capacity * float64(lgResu.Soh) / 100
+	}
+
+	if e.state.Charge < 0 {
+		e.state.Charge = capacity * float64(lgResu.Soc) / 100
+		log.Infof("socestimator: initialized with BMS SOC %d %%\n", lgResu.Soc)
+	} else if dt := t.Sub(e.state.Time); dt > 0 && dt <= MAX_INTEGRATION_INTERVAL {
+		e.state.Charge += float64(e.state.Current) * dt.Hours()
+	}
+	e.state.Time = t
+	e.state.Current = lgResu.Current
+
+	e.calibrate(t, lgResu, capacity)
+
+	// the estimation is limited to 0 - 100 %
+	if e.state.Charge > capacity {
+		e.state.Charge = capacity
+	}
+	if e.state.Charge < 0 {
+		e.state.Charge = 0
+	}
+
+	lgResu.EstimatedSoc = float32(e.state.Charge / capacity * 100)
+	lgResu.SocDivergence = lgResu.EstimatedSoc - float32(lgResu.Soc)
+
+	if len(e.fileName) != 0 && t.Sub(e.saved) >= SAVE_INTERVAL {
+		if err := e.save(); err != nil {
+			log.Warnf("socestimator: could not save estimated charge (%v)\n", err)
+		}
+		e.saved = t
+	}
+}
+
+// calibrate sets the charge to capacity at the end of a full charge (e.mu must be locked).
+func (e *Estimator) calibrate(t time.Time, lgResu *rs.LgResuStatus, capacity float64) {
+	if lgResu.MaxVoltage == 0 || lgResu.Voltage < lgResu.MaxVoltage-FULL_VOLTAGE_DELTA ||
+		lgResu.Current < 0 || lgResu.Current >= FULL_CURRENT {
+		e.full = time.Time{}
+		return
+	}
+
+	if e.full.IsZero() {
+		e.full = t
+	}
+	if t.Sub(e.full) >= FULL_TIME && e.state.Charge != capacity {
+		log.Infof("socestimator: full charge, recalibrated estimated SOC %.1f %% to 100 %% (BMS SOC %d %%)\n",
+			e.state.Charge/capacity*100, lgResu.Soc)
+		e.state.Charge = capacity
+	}
+}
+
+// Save writes the estimated charge to the JSON file.
+func (e *Estimator) Save() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.save()
+}
+
+// save writes the estimated charge to the JSON file (e.mu must be locked).
+func (e *Estimator) save() error {
+	return dr.WriteJSONFile(e.fileName, e.state)
+}
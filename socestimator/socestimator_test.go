@@ -0,0 +1,146 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socestimator
+
+import (
+	rs "github.com/jens18/lgresu/lgresustatus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	// only log warning severity or above.
+	log.SetLevel(log.WarnLevel)
+}
+
+// metrics received by the tests (SOC/SOH and volt/amp/temp messages)
+const socSoh = rs.UPDATE_SOC | rs.UPDATE_SOH | rs.UPDATE_VOLTAGE | rs.UPDATE_CURRENT
+
+// update calls e.Update every second for d.
+func update(e *Estimator, start time.Time, d time.Duration, lgResu *rs.LgResuStatus) time.Time {
+	for t := start; t.Before(start.Add(d)); t = t.Add(time.Second) {
+		e.Update(t, lgResu)
+	}
+	return start.Add(d)
+}
+
+func TestEstimatorCoulombCounting(t *testing.T) {
+	e := NewEstimator("", rs.NOMINAL_CAPACITY)
+
+	// SOC/SOH not received yet
+	lgResu := &rs.LgResuStatus{Current: -18.9}
+	e.Update(time.Now(), lgResu)
+	if lgResu.EstimatedSoc != 0 {
+		t.Errorf("e.Update() produce EstimatedSoc = %.1f without SOC, expect 0", lgResu.EstimatedSoc)
+	}
+
+	// 18.9A discharge for 1 hour = 10 % of 189Ah
+	lgResu = &rs.LgResuStatus{Soc: 80, Soh: 100, Voltage: 52, Current: -18.9, MaxVoltage: 57.7, ReceivedFields: socSoh}
+	now := update(e, time.Now(), time.Hour, lgResu)
+	e.Update(now, lgResu)
+
+	if math.Abs(float64(lgResu.EstimatedSoc-70)) > 0.01 || math.Abs(float64(lgResu.SocDivergence+10)) > 0.01 {
+		t.Errorf("e.Update() produce EstimatedSoc = %.2f, SocDivergence = %.2f, expect 70, -10",
+			lgResu.EstimatedSoc, lgResu.SocDivergence)
+	}
+
+	// gap (lgresu_mon not running)
+	e.Update(now.Add(time.Hour), lgResu)
+	if math.Abs(float64(lgResu.EstimatedSoc-70)) > 0.01 {
+		t.Errorf("e.Update() produce EstimatedSoc = %.2f after gap, expect 70", lgResu.EstimatedSoc)
+	}
+}
+
+// TestEstimatorWithoutSoh tests a BMS profile that does not report the SOH (nominal capacity).
+func TestEstimatorWithoutSoh(t *testing.T) {
+	e := NewEstimator("", rs.NOMINAL_CAPACITY)
+
+	// 18.9A discharge for 1 hour = 10 % of 189Ah
+	lgResu := &rs.LgResuStatus{Soc: 80, Voltage: 52, Current: -18.9,
+		ReceivedFields: rs.UPDATE_SOC | rs.UPDATE_VOLTAGE | rs.UPDATE_CURRENT}
+	now := update(e, time.Now(), time.Hour, lgResu)
+	e.Update(now, lgResu)
+
+	if math.Abs(float64(lgResu.EstimatedSoc-70)) > 0.01 {
+		t.Errorf("e.Update() produce EstimatedSoc = %.2f without SOH, expect 70", lgResu.EstimatedSoc)
+	}
+}
+
+func TestEstimatorRecalibration(t *testing.T) {
+	e := NewEstimator("", rs.NOMINAL_CAPACITY)
+
+	// initialized with 50 %
+	lgResu := &rs.LgResuStatus{Soc: 50, Soh: 100, Voltage: 54, Current: 0, MaxVoltage: 57.7, ReceivedFields: socSoh}
+	now := update(e, time.Now(), time.Minute, lgResu)
+
+	// constant voltage phase: voltage near max voltage, current tapers (not yet below FULL_CURRENT)
+	lgResu.Voltage, lgResu.Current, lgResu.Soc = 57.6, 10, 97
+	now = update(e, now, time.Minute, lgResu)
+
+	if lgResu.EstimatedSoc > 51 {
+		t.Errorf("e.Update() produce EstimatedSoc = %.2f before full charge, expect approx. 50", lgResu.EstimatedSoc)
+	}
+
+	// full charge: current below FULL_CURRENT for FULL_TIME
+	lgResu.Current, lgResu.Soc = 2, 99
+	now = update(e, now, FULL_TIME+time.Second, lgResu)
+
+	if lgResu.EstimatedSoc != 100 || lgResu.SocDivergence != 1 {
+		t.Errorf("e.Update() produce EstimatedSoc = %.2f, SocDivergence = %.2f after full charge, expect 100, 1",
+			lgResu.EstimatedSoc, lgResu.SocDivergence)
+	}
+}
+
+// TestEstimatorPersistence tests that the estimated charge survives a restart (and is not re-initialized
+// with the BMS SOC).
+func TestEstimatorPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socestimator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "data", SOC_FILE_NAME)
+
+	// 18.9A discharge for 1 hour = 10 % of 189Ah
+	e := NewEstimator(fileName, rs.NOMINAL_CAPACITY)
+	lgResu := &rs.LgResuStatus{Soc: 80, Soh: 100, Voltage: 52, Current: -18.9, MaxVoltage: 57.7, ReceivedFields: socSoh}
+	now := update(e, time.Now(), time.Hour, lgResu)
+	e.Update(now, lgResu)
+	if err := e.Save(); err != nil {
+		t.Fatalf("e.Save() == %v, expect nil", err)
+	}
+
+	restarted := NewEstimator(fileName, rs.NOMINAL_CAPACITY)
+	lgResu = &rs.LgResuStatus{Soc: 80, Soh: 100, Voltage: 52, Current: 0, MaxVoltage: 57.7, ReceivedFields: socSoh}
+	restarted.Update(now.Add(time.Second), lgResu)
+	if math.Abs(float64(lgResu.EstimatedSoc-70)) > 0.01 || math.Abs(float64(lgResu.SocDivergence+10)) > 0.01 {
+		t.Errorf("restarted.Update() produce EstimatedSoc = %.2f, SocDivergence = %.2f, expect 70, -10",
+			lgResu.EstimatedSoc, lgResu.SocDivergence)
+	}
+
+	// invalid JSON file
+	ioutil.WriteFile(fileName, []byte("{"), 0644)
+	lgResu = &rs.LgResuStatus{Soc: 80, Soh: 100, ReceivedFields: socSoh}
+	NewEstimator(fileName, rs.NOMINAL_CAPACITY).Update(now, lgResu)
+	if lgResu.EstimatedSoc != 80 {
+		t.Errorf("NewEstimator() of invalid file produce EstimatedSoc = %.2f, expect 80", lgResu.EstimatedSoc)
+	}
+}
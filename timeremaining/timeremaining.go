@@ -0,0 +1,134 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package timeremaining estimates the time until the battery is empty
+// (battery is discharged) or full (battery is charged).
+//
+// The estimation is based on the rolling average of the battery current
+// (to ignore short load spikes of the inverter), the SOC and the battery
+// capacity (nominal capacity * SOH). The average is weighted by time: the
+// current of a message is used until the next message (a burst of messages
+// does not skew the average).
+//
+//     time to empty = capacity * SOC / -average current
+//     time to full  = capacity * (100 - SOC) / min(average current, max charge current)
+//
+// Note:
+// The charge current decreases at the end of the charge cycle (constant voltage
+// phase), the time to full is therefore a lower bound.
+package timeremaining
+
+import (
+	"fmt"
+	rs "github.com/jens18/lgresu/lgresustatus"
+	"time"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// time window of the rolling average of the battery current
+	AVERAGE_WINDOW time.Duration = 5 * time.Minute
+	// the current is used for at most MAX_INTEGRATION_INTERVAL after a sample (longer intervals: BMS offline)
+	MAX_INTEGRATION_INTERVAL time.Duration = 30 * time.Second
+	// the battery is idle if the absolute value of the average current is below IDLE_CURRENT (A)
+	IDLE_CURRENT float32 = 0.5
+)
+
+// sample contains a single battery current value.
+type sample struct {
+	t       time.Time
+	current float32
+}
+
+// Estimator estimates the time to empty/full.
+type Estimator struct {
+	capacity float32
+	window   time.Duration
+	samples  []sample
+}
+
+// NewEstimator is the constructor for Estimator. capacity is the nominal battery capacity
+// in Ah, window is the time window of the rolling average of the battery current.
+func NewEstimator(capacity float32, window time.Duration) *Estimator {
+	return &Estimator{capacity: capacity, window: window}
+}
+
+// average adds the current at time t and returns the time weighted average current of the time window.
+// The current of a sample is weighted by the time until the next sample (at most MAX_INTEGRATION_INTERVAL).
+// The current at time t is returned until the samples span a time interval.
+func (e *Estimator) average(t time.Time, current float32) float32 {
+	e.samples = append(e.samples, sample{t, current})
+
+	// remove samples that are followed by a sample at or before the start of the time window
+	start := t.Add(-e.window)
+	i := 0
+	for i+1 < len(e.samples) && !e.samples[i+1].t.After(start) {
+		i++
+	}
+	e.samples = e.samples[i:]
+
+	var sum, total float64
+	for i := 0; i+1 < len(e.samples); i++ {
+		from, to := e.samples[i].t, e.samples[i+1].t
+		if to.Sub(from) > MAX_INTEGRATION_INTERVAL {
+			to = from.Add(MAX_INTEGRATION_INTERVAL)
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if d := to.Sub(from).Seconds(); d > 0 {
+			sum += float64(e.samples[i].current) * d
+			total += d
+		}
+	}
+	if total == 0 {
+		return current
+	}
+	return float32(sum / total)
+}
+
+// FormatDuration formats d as hours and minutes (for example: 3h20m).
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Update estimates the time to empty/full for the status lgResu at time t.
+func (e *Estimator) Update(t time.Time, lgResu *rs.LgResuStatus) {
+	current := e.average(t, lgResu.Current)
+
+	capacity := e.capacity
+	if lgResu.Soh > 0 && lgResu.Soh <= 100 {
+		capacity = capacity * float32(lgResu.Soh) / 100
+	}
+
+	lgResu.TimeToEmpty, lgResu.TimeToFull, lgResu.TimeRemaining = 0, 0, ""
+
+	switch {
+	case current <= -IDLE_CURRENT:
+		hours := capacity * float32(lgResu.Soc) / 100 / -current
+		lgResu.TimeToEmpty = uint32(hours * 3600)
+		lgResu.TimeRemaining = FormatDuration(time.Duration(lgResu.TimeToEmpty) * time.Second)
+
+	case current >= IDLE_CURRENT:
+		if lgResu.MaxChargeCurrent > 0 && current > lgResu.MaxChargeCurrent {
+			current = lgResu.MaxChargeCurrent
+		}
+		hours := capacity * float32(100-lgResu.Soc) / 100 / current
+		lgResu.TimeToFull = uint32(hours * 3600)
+		lgResu.TimeRemaining = FormatDuration(time.Duration(lgResu.TimeToFull) * time.Second)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeremaining
+
+import (
+	rs "github.com/jens18/lgresu/lgresustatus"
+	"testing"
+	"time"
+)
+
+var EstimatorTestCases = []struct {
+	Name   string
+	Status rs.LgResuStatus
+	Empty  uint32
+	Full   uint32
+	Expect string
+}{
+	// 189Ah * 50% / 10A = 9.45h
+	{"discharging", rs.LgResuStatus{Soc: 50, Soh: 100, Current: -10}, 34020, 0, "9h27m"},
+	// 189Ah * 90% * 20% / 18.9A = 1.8h
+	{"discharging (soh 90%)", rs.LgResuStatus{Soc: 20, Soh: 90, Current: -18.9}, 6480, 0, "1h48m"},
+	// 189Ah * 20% / 37.8A = 1h
+	{"charging", rs.LgResuStatus{Soc: 80, Soh: 100, Current: 37.8, MaxChargeCurrent: 91.8}, 0, 3600, "1h00m"},
+	// 189Ah * 50% / 94.5A (limited by max charge current) = 1h
+	{"charging (limit)", rs.LgResuStatus{Soc: 50, Soh: 100, Current: 100, MaxChargeCurrent: 94.5}, 0, 3600, "1h00m"},
+	{"idle", rs.LgResuStatus{Soc: 50, Soh: 100, Current: -0.2}, 0, 0, ""},
+}
+
+func TestEstimatorUpdate(t *testing.T) {
+	for _, tc := range EstimatorTestCases {
+		e := NewEstimator(rs.NOMINAL_CAPACITY, AVERAGE_WINDOW)
+		lgResu := tc.Status
+
+		e.Update(time.Now(), &lgResu)
+
+		// allow 1 second rounding error
+		if lgResu.TimeToEmpty+1 < tc.Empty || lgResu.TimeToEmpty > tc.Empty+1 ||
+			lgResu.TimeToFull+1 < tc.Full || lgResu.TimeToFull > tc.Full+1 || lgResu.TimeRemaining != tc.Expect {
+			t.Errorf("%s: e.Update() produce TimeToEmpty = %d, TimeToFull = %d, TimeRemaining = %q, expect %d, %d, %q",
+				tc.Name, lgResu.TimeToEmpty, lgResu.TimeToFull, lgResu.TimeRemaining, tc.Empty, tc.Full, tc.Expect)
+		}
+	}
+}
+
+// TestEstimatorRollingAverage tests that a short load spike does not change the estimation significantly
+// and that samples older than the time window are ignored.
+func TestEstimatorRollingAverage(t *testing.T) {
+	e := NewEstimator(rs.NOMINAL_CAPACITY, AVERAGE_WINDOW)
+	lgResu := &rs.LgResuStatus{Soc: 50, Soh: 100, Current: -10}
+
+	start := time.Now()
+	for i := 0; i < 290; i++ {
+		e.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+
+	// 10 second load spike (100A)
+	lgResu.Current = -100
+	for i := 290; i < 300; i++ {
+		e.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+
+	// average current = (290s * 10A + 9s * 100A) / 299s = 12.7A (the last current is not weighted yet)
+	if lgResu.TimeRemaining != "7h26m" {
+		t.Errorf("e.Update() produce TimeRemaining = %q after load spike, expect 7h26m", lgResu.TimeRemaining)
+	}
+
+	// the load spike is older than the time window
+	lgResu.Current = -10
+	for i := 300; i <= 300+int(AVERAGE_WINDOW/time.Second); i++ {
+		e.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+
+	if lgResu.TimeRemaining != "9h27m" {
+		t.Errorf("e.Update() produce TimeRemaining = %q, expect 9h27m", lgResu.TimeRemaining)
+	}
+}
+
+// TestEstimatorBurst tests that a burst of messages does not skew the average current.
+func TestEstimatorBurst(t *testing.T) {
+	e := NewEstimator(rs.NOMINAL_CAPACITY, AVERAGE_WINDOW)
+	lgResu := &rs.LgResuStatus{Soc: 50, Soh: 100, Current: -10}
+
+	start := time.Now()
+	for i := 0; i < 290; i++ {
+		e.Update(start.Add(time.Duration(i)*time.Second), lgResu)
+	}
+
+	// 100 messages (100A) within 100ms
+	lgResu.Current = -100
+	for i := 0; i < 100; i++ {
+		e.Update(start.Add(290*time.Second+time.Duration(i)*time.Millisecond), lgResu)
+	}
+
+	lgResu.Current = -10
+	e.Update(start.Add(291*time.Second), lgResu)
+
+	// average current = (290s * 10A + 1s * 100A) / 291s = 10.3A (not (290 * 10A + 100 * 100A) / 391 = 33A)
+	if lgResu.TimeRemaining != "9h10m" {
+		t.Errorf("e.Update() produce TimeRemaining = %q after burst, expect 9h10m", lgResu.TimeRemaining)
+	}
+}
+
+// TestEstimatorGap tests that the current before a gap (BMS offline) is used for at most MAX_INTEGRATION_INTERVAL.
+func TestEstimatorGap(t *testing.T) {
+	e := NewEstimator(rs.NOMINAL_CAPACITY, AVERAGE_WINDOW)
+	lgResu := &rs.LgResuStatus{Soc: 50, Soh: 100, Current: -100}
+
+	start := time.Now()
+	e.Update(start, lgResu)
+	lgResu.Current = -10
+	e.Update(start.Add(time.Minute), lgResu)
+	e.Update(start.Add(time.Minute+10*time.Second), lgResu)
+
+	// average current = (30s * 100A + 10s * 10A) / 40s = 77.5A (not (60s * 100A + 10s * 10A) / 70s = 87.1A)
+	if lgResu.TimeRemaining != "1h13m" {
+		t.Errorf("e.Update() produce TimeRemaining = %q after gap, expect 1h13m", lgResu.TimeRemaining)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, expect := range map[time.Duration]string{
+		3*time.Hour + 20*time.Minute: "3h20m",
+		59 * time.Second:             "0h01m",
+		26*time.Hour + 5*time.Minute: "26h05m",
+	} {
+		if s := FormatDuration(d); s != expect {
+			t.Errorf("FormatDuration(%v) == %q, expect %q", d, s, expect)
+		}
+	}
+}
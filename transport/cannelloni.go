@@ -0,0 +1,223 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/brutella/can"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+)
+
+const (
+	// URL scheme of a cannelloni (CAN over UDP) tunnel (cannelloni://remote:20000?local=:20000)
+	CANNELLONI_SCHEME string = "cannelloni"
+	// default cannelloni port (remote and local, use different ports for a loopback pair)
+	CANNELLONI_PORT string = "20000"
+	// cannelloni protocol version and operation code of data packets
+	CANNELLONI_VERSION uint8 = 2
+	CANNELLONI_DATA    uint8 = 0
+	// size of the cannelloni packet header (version, operation code, sequence number, frame count)
+	cannelloniHeaderSize int = 5
+	// CAN FD frame flag (length)
+	cannelloniFdFlag uint8 = 0x80
+)
+
+// cannelloniConn exchanges frames with a cannelloni peer (https://github.com/mguentner/cannelloni).
+//
+// cannelloni packet format (UDP, big endian):
+//
+//	02 00 ss nn nn                       version 2, data, sequence number, frame count
+//	ii ii ii ii ll dd dd dd dd ...       id (SocketCAN, incl. extended flag), length, data (for every frame)
+//
+// CAN FD frames (length flag 0x80, followed by a flags byte) are ignored. Every frame is send in its own
+// packet. Packets from other hosts than the peer are ignored.
+type cannelloniConn struct {
+	conn   *net.UDPConn
+	remote *net.UDPAddr
+	buf    []byte
+	// frames (SocketCAN frame format) of the last received packet not yet read
+	pending [][]byte
+
+	mu  sync.Mutex
+	seq uint8
+}
+
+// dialCannelloni listens on the local address and sends frames to the remote address.
+func dialCannelloni(remote string, local string) (*cannelloniConn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return nil, fmt.Errorf("transport: cannelloni: %v", err)
+	}
+	laddr, err := net.ResolveUDPAddr("udp", local)
+	if err != nil {
+		return nil, fmt.Errorf("transport: cannelloni: %v", err)
+	}
+	// a loopback peer on the local port is this transport itself (both ends default to port 20000)
+	if raddr.IP.IsLoopback() && raddr.Port == laddr.Port &&
+		(laddr.IP == nil || laddr.IP.IsUnspecified() || sameIP(laddr.IP, raddr.IP)) {
+		return nil, fmt.Errorf("transport: cannelloni: remote %v is the local address %v, use different ports "+
+			"(example: cannelloni://127.0.0.1:20001?local=127.0.0.1:20000)", raddr, local)
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("transport: cannelloni: %v", err)
+	}
+	return &cannelloniConn{conn: conn, remote: raddr, buf: make([]byte, 65536)}, nil
+}
+
+// Read reads the next received frame (SocketCAN frame format).
+func (c *cannelloniConn) Read(b []byte) (int, error) {
+	if len(b) < FRAME_SIZE {
+		return 0, io.ErrShortBuffer
+	}
+
+	for len(c.pending) == 0 {
+		n, addr, err := c.conn.ReadFromUDP(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		if !sameIP(addr.IP, c.remote.IP) {
+			log.Debugf("cannelloni: ignoring packet from %v\n", addr)
+			continue
+		}
+
+		frames, err := parseCannelloniPacket(c.buf[:n])
+		if err != nil {
+			log.Warnf("cannelloni: %v\n", err)
+			continue
+		}
+		c.pending = frames
+	}
+
+	n := copy(b, c.pending[0])
+	c.pending = c.pending[1:]
+	return n, nil
+}
+
+// sameIP compares two IP addresses, IPv4 addresses in IPv6 form (::ffff:a.b.c.d, received on a dual
+// stack socket) are compared in the 4-byte form.
+func sameIP(a net.IP, b net.IP) bool {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		return a4.Equal(b4)
+	}
+	return a.Equal(b)
+}
+
+// parseCannelloniPacket returns the frames (SocketCAN frame format) of a data packet.
+func parseCannelloniPacket(p []byte) ([][]byte, error) {
+	if len(p) < cannelloniHeaderSize {
+		return nil, fmt.Errorf("packet has %d bytes, expect at least %d bytes", len(p), cannelloniHeaderSize)
+	}
+	if p[0] != CANNELLONI_VERSION || p[1] != CANNELLONI_DATA {
+		return nil, fmt.Errorf("packet has version %d, operation code %d, expect %d, %d",
+			p[0], p[1], CANNELLONI_VERSION, CANNELLONI_DATA)
+	}
+
+	count := int(binary.BigEndian.Uint16(p[3:5]))
+	frames := [][]byte{}
+
+	for i, pos := 0, cannelloniHeaderSize; i < count; i++ {
+		if len(p) < pos+5 {
+			return nil, fmt.Errorf("packet truncated (frame %d of %d)", i+1, count)
+		}
+		id := binary.BigEndian.Uint32(p[pos : pos+4])
+		length := p[pos+4]
+		pos += 5
+
+		fd := length&cannelloniFdFlag != 0
+		if fd {
+			// flags byte
+			length &^= cannelloniFdFlag
+			pos++
+		}
+
+		if len(p) < pos+int(length) {
+			return nil, fmt.Errorf("packet truncated (frame %d of %d)", i+1, count)
+		}
+		data := p[pos : pos+int(length)]
+		pos += int(length)
+
+		if fd || length > 8 {
+			log.Debugf("cannelloni: ignoring CAN FD frame %#x\n", id)
+			continue
+		}
+		frames = append(frames, encodeFrame(id, data))
+	}
+	return frames, nil
+}
+
+// Write sends one frame (SocketCAN frame format) in its own packet.
+func (c *cannelloniConn) Write(b []byte) (int, error) {
+	id, data, err := decodeFrame(b)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	seq := c.seq
+	c.seq++
+	c.mu.Unlock()
+
+	p := make([]byte, cannelloniHeaderSize+5+len(data))
+	p[0], p[1], p[2] = CANNELLONI_VERSION, CANNELLONI_DATA, seq
+	binary.BigEndian.PutUint16(p[3:5], 1)
+	binary.BigEndian.PutUint32(p[5:9], id)
+	p[9] = uint8(len(data))
+	copy(p[10:], data)
+
+	if _, err := c.conn.WriteToUDP(p, c.remote); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the UDP socket.
+func (c *cannelloniConn) Close() error {
+	return c.conn.Close()
+}
+
+// openCannelloni connects to a cannelloni peer (cannelloni://remote:20000?local=:20000).
+func openCannelloni(u *url.URL) (can.ReadWriteCloser, error) {
+	if len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("transport: cannelloni URL %q has no remote host (example: cannelloni://host:%s)",
+			u, CANNELLONI_PORT)
+	}
+
+	remote := u.Host
+	if len(u.Port()) == 0 {
+		remote = net.JoinHostPort(u.Hostname(), CANNELLONI_PORT)
+	}
+
+	local := u.Query().Get("local")
+	if len(local) == 0 {
+		local = ":" + CANNELLONI_PORT
+	}
+
+	c, err := dialCannelloni(remote, local)
+	if err != nil {
+		return nil, err
+	}
+	return can.NewReadWriteCloser(c), nil
+}
+
+func init() {
+	Register(CANNELLONI_SCHEME, openCannelloni)
+}
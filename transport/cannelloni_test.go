@@ -0,0 +1,142 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"github.com/brutella/can"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// cannelloni test packet: standard frame, CAN FD frame (ignored), extended frame
+var CannelloniTestPacket = []byte{
+	0x02, 0x00, 0x07, 0x00, 0x03,
+	0x00, 0x00, 0x03, 0x55, 0x04, 0x4d, 0x00, 0x63, 0x00,
+	0x00, 0x00, 0x01, 0x23, 0x82, 0x00, 0x01, 0x02,
+	0x98, 0xff, 0x50, 0xe5, 0x02, 0x01, 0x02,
+}
+
+// freeUDPPort returns a local UDP port that is currently unused.
+func freeUDPPort(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// TestCannelloni tests the exchange of frames between two cannelloni transports (ie. lgresu_sim and
+// lgresu_mon) over loopback UDP.
+func TestCannelloni(t *testing.T) {
+	port1, port2 := freeUDPPort(t), freeUDPPort(t)
+
+	sim, err := Open("cannelloni://127.0.0.1:" + port2 + "?local=127.0.0.1:" + port1)
+	if err != nil {
+		t.Fatalf("Open() == %v, expect nil", err)
+	}
+	defer sim.Close()
+
+	mon, err := Open("cannelloni://127.0.0.1:" + port1 + "?local=127.0.0.1:" + port2)
+	if err != nil {
+		t.Fatalf("Open() == %v, expect nil", err)
+	}
+	defer mon.Close()
+
+	for _, tm := range []struct {
+		from, to can.ReadWriteCloser
+		frm      can.Frame
+	}{
+		{sim, mon, can.Frame{ID: 0x355, Length: 8, Data: [8]byte{0x4d, 0x00, 0x63, 0x00}}},
+		{mon, sim, can.Frame{ID: 0x305, Length: 8}},
+		{sim, mon, can.Frame{ID: 0x18ff50e5 | CAN_EFF_FLAG, Length: 2, Data: [8]byte{0x01, 0x02}}},
+	} {
+		if err := tm.from.WriteFrame(tm.frm); err != nil {
+			t.Errorf("WriteFrame(%+v) == %v, expect nil", tm.frm, err)
+		}
+
+		frm := can.Frame{}
+		if err := tm.to.ReadFrame(&frm); err != nil || frm != tm.frm {
+			t.Errorf("ReadFrame() == %+v, %v, expect %+v, nil", frm, err, tm.frm)
+		}
+	}
+}
+
+// TestCannelloniPacket tests a packet with several frames sent by cannelloni.
+func TestCannelloniPacket(t *testing.T) {
+	port1, port2 := freeUDPPort(t), freeUDPPort(t)
+
+	rwc, err := Open("cannelloni://127.0.0.1:" + port2 + "?local=127.0.0.1:" + port1)
+	if err != nil {
+		t.Fatalf("Open() == %v, expect nil", err)
+	}
+	defer rwc.Close()
+
+	conn, err := net.Dial("udp", "127.0.0.1:"+port1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// invalid packet (version 1) is ignored
+	conn.Write([]byte{0x01, 0x00, 0x00, 0x00, 0x00})
+	conn.Write(CannelloniTestPacket)
+
+	for _, expect := range []can.Frame{
+		{ID: 0x355, Length: 4, Data: [8]byte{0x4d, 0x00, 0x63, 0x00}},
+		{ID: 0x18ff50e5 | CAN_EFF_FLAG, Length: 2, Data: [8]byte{0x01, 0x02}},
+	} {
+		frm := can.Frame{}
+		if err := rwc.ReadFrame(&frm); err != nil || frm != expect {
+			t.Errorf("rwc.ReadFrame() == %+v, %v, expect %+v, nil", frm, err, expect)
+		}
+	}
+
+	if _, err := Open("cannelloni://?local=:" + port1); err == nil {
+		t.Errorf("Open() without remote host == nil, expect error")
+	}
+}
+
+// TestCannelloniLoopback tests that a loopback peer on the local port (default ports) is refused.
+func TestCannelloniLoopback(t *testing.T) {
+	port1 := freeUDPPort(t)
+
+	for _, rawurl := range []string{"cannelloni://127.0.0.1",
+		"cannelloni://127.0.0.1:" + port1 + "?local=:" + port1,
+		"cannelloni://127.0.0.1:" + port1 + "?local=127.0.0.1:" + port1} {
+		if rwc, err := Open(rawurl); err == nil {
+			rwc.Close()
+			t.Errorf("Open(%q) == nil, expect error", rawurl)
+		}
+	}
+}
+
+func TestSameIP(t *testing.T) {
+	for _, tm := range []struct {
+		a, b   net.IP
+		expect bool
+	}{
+		{net.ParseIP("192.168.1.10"), net.IPv4(192, 168, 1, 10).To4(), true},
+		{net.ParseIP("::ffff:192.168.1.10"), net.ParseIP("192.168.1.10").To4(), true},
+		{net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.11"), false},
+		{net.ParseIP("::1"), net.ParseIP("127.0.0.1"), false},
+		{net.ParseIP("fe80::1"), net.ParseIP("fe80::1"), true},
+	} {
+		if got := sameIP(tm.a, tm.b); got != tm.expect {
+			t.Errorf("sameIP(%v, %v) == %v, expect %v", tm.a, tm.b, got, tm.expect)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/brutella/can"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// URL scheme of a SLCAN (Lawicel) serial adapter (slcan:///dev/ttyACM0?bitrate=500000)
+	SLCAN_SCHEME string = "slcan"
+	// default bitrate (LG Resu 10 LV)
+	SLCAN_BITRATE int = 500000
+)
+
+// slcanBitrates maps the bitrate to the SLCAN setup command.
+var slcanBitrates = map[int]string{
+	10000:   "S0",
+	20000:   "S1",
+	50000:   "S2",
+	100000:  "S3",
+	125000:  "S4",
+	250000:  "S5",
+	500000:  "S6",
+	800000:  "S7",
+	1000000: "S8",
+}
+
+// errSlcanBell is the error response (BEL) of the adapter.
+var errSlcanBell = errors.New("transport: slcan: adapter responded with error (BEL)")
+
+// slcanConn exchanges frames with a SLCAN (Lawicel ASCII) serial adapter (CANable, USBtin, ...).
+//
+// SLCAN protocol (every command and frame is terminated by CR, the adapter responds with CR or BEL):
+//
+//	C                        close the CANBus channel
+//	S6                       set bitrate 500 kbit/s
+//	O                        open the CANBus channel
+//	t3558 4D00630000000000   standard frame (3 hex digits id, length, data)
+//	T18FF50E52 0102          extended frame (8 hex digits id, length, data)
+//
+// (without the spaces between length and data). A timestamp (4 hex digits) after the data is ignored.
+type slcanConn struct {
+	f *os.File
+	r *bufio.Reader
+}
+
+// dialSlcan opens the serial adapter device and the CANBus channel with bitrate.
+func dialSlcan(device string, bitrate int) (*slcanConn, error) {
+	setup, ok := slcanBitrates[bitrate]
+	if !ok {
+		return nil, fmt.Errorf("transport: slcan: unsupported bitrate %d", bitrate)
+	}
+
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("transport: slcan: %v", err)
+	}
+	if err := setRawMode(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("transport: slcan: %s: %v", device, err)
+	}
+	c := &slcanConn{f: f, r: bufio.NewReader(f)}
+
+	// the channel may still be open (the response to close is ignored)
+	for _, cmd := range []string{"C", setup, "O"} {
+		err := c.command(cmd)
+		if err != nil && (cmd != "C" || err != errSlcanBell) {
+			f.Close()
+			return nil, fmt.Errorf("transport: slcan: %s: command %s: %v", device, cmd, err)
+		}
+	}
+	return c, nil
+}
+
+// command sends cmd and waits for the response of the adapter (received frames are dropped).
+func (c *slcanConn) command(cmd string) error {
+	if _, err := io.WriteString(c.f, cmd+"\r"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+		log.Debugf("slcan: ignoring %q\n", line)
+	}
+}
+
+// readLine returns the next line without CR. readLine returns errSlcanBell if the adapter responds
+// with BEL.
+func (c *slcanConn) readLine() (string, error) {
+	line := []byte{}
+
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch b {
+		case '\r':
+			return string(line), nil
+		case '\a':
+			return "", errSlcanBell
+		case '\n':
+			// some adapters terminate lines with CR LF
+		default:
+			line = append(line, b)
+		}
+	}
+}
+
+// Read reads the next received frame (SocketCAN frame format). Remote frames, transmit
+// acknowledgements (z, Z) and transmit errors are ignored.
+func (c *slcanConn) Read(b []byte) (int, error) {
+	if len(b) < FRAME_SIZE {
+		return 0, io.ErrShortBuffer
+	}
+
+	for {
+		line, err := c.readLine()
+		switch {
+		case err == errSlcanBell:
+			log.Warnf("slcan: %v\n", err)
+			continue
+		case err != nil:
+			return 0, err
+		case len(line) == 0:
+			continue
+		}
+
+		switch line[0] {
+		case 't', 'T':
+			id, data, err := parseSlcanFrame(line)
+			if err != nil {
+				// a corrupted line (serial noise) does not terminate the connection
+				log.Warnf("slcan: %v\n", err)
+				continue
+			}
+			return copy(b, encodeFrame(id, data)), nil
+		default:
+			log.Debugf("slcan: ignoring %q\n", line)
+		}
+	}
+}
+
+// parseSlcanFrame parses a received standard (t) or extended (T) frame.
+func parseSlcanFrame(line string) (id uint32, data []byte, err error) {
+	digits := 3
+	if line[0] == 'T' {
+		digits = 8
+	}
+
+	if len(line) < 1+digits+1 {
+		return 0, nil, fmt.Errorf("transport: slcan: invalid frame %q", line)
+	}
+
+	v, err := strconv.ParseUint(line[1:1+digits], 16, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("transport: slcan: invalid frame id %q", line)
+	}
+	id = uint32(v)
+	if line[0] == 'T' {
+		id |= CAN_EFF_FLAG
+	}
+
+	length := int(line[1+digits] - '0')
+	start := 1 + digits + 1
+	if length < 0 || length > 8 || len(line) < start+2*length {
+		return 0, nil, fmt.Errorf("transport: slcan: invalid frame length %q", line)
+	}
+
+	data, err = hex.DecodeString(line[start : start+2*length])
+	if err != nil {
+		return 0, nil, fmt.Errorf("transport: slcan: invalid frame data %q", line)
+	}
+	return id, data, nil
+}
+
+// Write transmits one frame (SocketCAN frame format).
+func (c *slcanConn) Write(b []byte) (int, error) {
+	id, data, err := decodeFrame(b)
+	if err != nil {
+		return 0, err
+	}
+
+	line := fmt.Sprintf("t%03X%d%X\r", id, len(data), data)
+	if id&CAN_EFF_FLAG != 0 {
+		line = fmt.Sprintf("T%08X%d%X\r", id&CAN_EFF_MASK, len(data), data)
+	}
+
+	if _, err := io.WriteString(c.f, line); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the CANBus channel and the serial adapter device.
+func (c *slcanConn) Close() error {
+	io.WriteString(c.f, "C\r")
+	return c.f.Close()
+}
+
+// openSlcan opens a SLCAN serial adapter (slcan:///dev/ttyACM0?bitrate=500000 or slcan://ttyACM0).
+func openSlcan(u *url.URL) (can.ReadWriteCloser, error) {
+	device := u.Path
+	if len(u.Host) != 0 {
+		device = "/dev/" + u.Host + u.Path
+	}
+	if len(strings.Trim(device, "/")) == 0 {
+		return nil, fmt.Errorf("transport: slcan URL %q has no serial device (example: slcan:///dev/ttyACM0)", u)
+	}
+
+	bitrate := SLCAN_BITRATE
+	if s := u.Query().Get("bitrate"); len(s) != 0 {
+		var err error
+		if bitrate, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("transport: slcan URL %q has invalid bitrate %q", u, s)
+		}
+	}
+
+	c, err := dialSlcan(device, bitrate)
+	if err != nil {
+		return nil, err
+	}
+	return can.NewReadWriteCloser(c), nil
+}
+
+func init() {
+	Register(SLCAN_SCHEME, openSlcan)
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package transport
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/brutella/can"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// SLCAN test frames (adapter -> client): standard frame, invalid frames (skipped), extended frame, frame with
+// timestamp, transmit acknowledgement
+var SlcanTestFrames = "t35544D006300\r" + "t3G5100\r" + "t3554AB\r" + "T18FF50E520102\r" + "t305100ABCD\r" + "z\r"
+
+// openPty returns the master and the name of the slave of a new pseudo terminal.
+func openPty() (*os.File, string, error) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		m.Close()
+		return nil, "", errno
+	}
+
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		m.Close()
+		return nil, "", errno
+	}
+	return m, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+// serveSlcan emulates a SLCAN adapter on the pty master: answers the setup commands (close, bitrate,
+// open) and sends the test frames. All commands received from the client are send to received.
+func serveSlcan(m *os.File, received chan<- string) {
+	r := bufio.NewReader(m)
+
+	for n := 0; ; n++ {
+		s, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSuffix(s, "\r")
+		received <- cmd
+
+		switch {
+		case cmd == "C" && n == 0:
+			// channel is not open
+			m.Write([]byte("\a"))
+		case n < 3:
+			m.Write([]byte("\r"))
+		}
+		if n == 2 {
+			m.Write([]byte(SlcanTestFrames))
+		}
+	}
+}
+
+func TestSlcan(t *testing.T) {
+	m, name, err := openPty()
+	if err != nil {
+		t.Skipf("no pseudo terminal (%v)", err)
+	}
+	defer m.Close()
+
+	received := make(chan string, 10)
+	go serveSlcan(m, received)
+
+	rwc, err := Open("slcan://" + name)
+	if err != nil {
+		t.Fatalf("Open() == %v, expect nil", err)
+	}
+	defer rwc.Close()
+
+	for _, expect := range []string{"C", "S6", "O"} {
+		if cmd := <-received; cmd != expect {
+			t.Errorf("adapter received %q, expect %q", cmd, expect)
+		}
+	}
+
+	for _, expect := range []can.Frame{
+		{ID: 0x355, Length: 4, Data: [8]byte{0x4d, 0x00, 0x63, 0x00}},
+		{ID: 0x18ff50e5 | CAN_EFF_FLAG, Length: 2, Data: [8]byte{0x01, 0x02}},
+		{ID: 0x305, Length: 1},
+	} {
+		frm := can.Frame{}
+		if err := rwc.ReadFrame(&frm); err != nil || frm != expect {
+			t.Errorf("rwc.ReadFrame() == %+v, %v, expect %+v, nil", frm, err, expect)
+		}
+	}
+
+	for _, tm := range []struct {
+		frm    can.Frame
+		expect string
+	}{
+		{can.Frame{ID: 0x305, Length: 8}, "t30580000000000000000"},
+		{can.Frame{ID: 0x18ff50e5 | CAN_EFF_FLAG, Length: 1, Data: [8]byte{0xab}}, "T18FF50E51AB"},
+	} {
+		if err := rwc.WriteFrame(tm.frm); err != nil {
+			t.Errorf("rwc.WriteFrame(%+v) == %v, expect nil", tm.frm, err)
+		}
+		if cmd := <-received; cmd != tm.expect {
+			t.Errorf("adapter received %q, expect %q", cmd, tm.expect)
+		}
+	}
+}
+
+func TestOpenSlcanErrors(t *testing.T) {
+	for _, rawurl := range []string{"slcan://", "slcan:///dev/nottyACM9", "slcan:///dev/null?bitrate=400000",
+		"slcan:///dev/null?bitrate=fast"} {
+		if _, err := Open(rawurl); err == nil {
+			t.Errorf("Open(%q) == nil, expect error", rawurl)
+		}
+	}
+}
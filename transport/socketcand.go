@@ -0,0 +1,294 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/brutella/can"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// URL scheme of a remote SocketCAN interface (socketcand://host:29536/can0)
+	SOCKETCAND_SCHEME string = "socketcand"
+	// default socketcand port
+	SOCKETCAND_PORT string = "29536"
+	// timeout to connect to socketcand and to open the CANBus interface
+	DIAL_TIMEOUT time.Duration = 10 * time.Second
+	// interval between two attempts to reconnect to socketcand
+	RECONNECT_INTERVAL time.Duration = 5 * time.Second
+)
+
+// ErrNotConnected is returned by Write while the connection to socketcand is lost.
+var ErrNotConnected = errors.New("transport: socketcand: not connected")
+
+// socketcandConn exchanges frames with socketcand (https://github.com/linux-can/socketcand) in raw mode.
+//
+// socketcand protocol (ASCII, every element is enclosed in '< >'):
+//
+//	< hi >                              server greeting
+//	< open can0 >                       open the CANBus interface, server responds < ok >
+//	< rawmode >                         receive all frames, server responds < ok >
+//	< frame 359 1528761712.310000 0000000000000000 >   received frame (id, timestamp, data)
+//	< send 305 8 00 00 00 00 00 00 00 00 >             send frame (id, length, data)
+//
+// Extended (29 bit) message id's have 8 hex digits.
+//
+// If the connection to socketcand is lost, Read reconnects every reconnect interval until the
+// connection is closed. Frames written while the connection is lost are dropped (Write returns
+// ErrNotConnected).
+type socketcandConn struct {
+	addr      string
+	channel   string
+	reconnect time.Duration
+	// r is only used by Read (and the handshake)
+	r *bufio.Reader
+	// mu protects conn and closed (Write and Close are called concurrently to Read),
+	// conn is nil while the connection is lost
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+	// done is closed by Close (stops waiting for the next attempt to reconnect)
+	done chan struct{}
+}
+
+// dialSocketcand connects to socketcand at addr (host:port) and opens the CANBus interface channel
+// in raw mode.
+func dialSocketcand(addr string, channel string) (*socketcandConn, error) {
+	c := &socketcandConn{addr: addr, channel: channel, reconnect: RECONNECT_INTERVAL, done: make(chan struct{})}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect connects to socketcand and opens the CANBus interface in raw mode.
+func (c *socketcandConn) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, DIAL_TIMEOUT)
+	if err != nil {
+		return fmt.Errorf("transport: could not connect to socketcand %s (%v)", c.addr, err)
+	}
+	c.r = bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(DIAL_TIMEOUT))
+	for _, step := range []struct{ send, expect string }{
+		{"", "hi"},
+		{"< open " + c.channel + " >", "ok"},
+		{"< rawmode >", "ok"},
+	} {
+		if len(step.send) != 0 {
+			if _, err := io.WriteString(conn, step.send); err != nil {
+				conn.Close()
+				return fmt.Errorf("transport: socketcand %s: %v", c.addr, err)
+			}
+		}
+		e, err := c.readElement()
+		if err != nil || e != step.expect {
+			conn.Close()
+			return fmt.Errorf("transport: socketcand %s: received < %s > (%v), expect < %s >", c.addr, e, err, step.expect)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		conn.Close()
+		return fmt.Errorf("transport: socketcand %s: connection closed", c.addr)
+	}
+	c.conn = conn
+	return nil
+}
+
+// reconnectLoop drops the lost connection and reconnects to socketcand every reconnect interval.
+// reconnectLoop returns an error if the connection has been closed.
+func (c *socketcandConn) reconnectLoop(cause error) error {
+	c.mu.Lock()
+	if !c.closed && c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.mu.Unlock()
+
+	for {
+		log.Warnf("socketcand %s: connection lost (%v), reconnecting in %v\n", c.addr, cause, c.reconnect)
+		select {
+		case <-time.After(c.reconnect):
+		case <-c.done:
+			return cause
+		}
+
+		err := c.connect()
+		if err == nil {
+			log.Infof("socketcand %s: reconnected\n", c.addr)
+			return nil
+		}
+		cause = err
+	}
+}
+
+// readElement returns the next element without '< >' (for example: frame 359 1528761712.310000 00).
+func (c *socketcandConn) readElement() (string, error) {
+	if _, err := c.r.ReadString('<'); err != nil {
+		return "", err
+	}
+	s, err := c.r.ReadString('>')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(s, ">")), nil
+}
+
+// Read reads the next received frame (SocketCAN frame format). Invalid frames and errors reported by
+// socketcand are logged and skipped, all other elements are ignored. Read reconnects if the connection
+// is lost and returns an error only after Close.
+func (c *socketcandConn) Read(b []byte) (int, error) {
+	if len(b) < FRAME_SIZE {
+		return 0, io.ErrShortBuffer
+	}
+
+	for {
+		e, err := c.readElement()
+		if err != nil {
+			if err := c.reconnectLoop(err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		fields := strings.Fields(e)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "frame":
+			id, data, err := parseSocketcandFrame(fields)
+			if err != nil {
+				log.Warnf("socketcand %s: %v\n", c.addr, err)
+				continue
+			}
+			return copy(b, encodeFrame(id, data)), nil
+		case "error":
+			log.Warnf("socketcand %s: < %s >\n", c.addr, e)
+		default:
+			log.Debugf("socketcand: ignoring < %s >\n", e)
+		}
+	}
+}
+
+// parseSocketcandFrame parses a received frame element (frame <id> <timestamp> [<data>]).
+func parseSocketcandFrame(fields []string) (id uint32, data []byte, err error) {
+	if len(fields) < 3 {
+		return 0, nil, fmt.Errorf("transport: socketcand: invalid frame %v", fields)
+	}
+
+	v, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("transport: socketcand: invalid frame id %q", fields[1])
+	}
+	id = uint32(v)
+	if len(fields[1]) > 3 {
+		id |= CAN_EFF_FLAG
+	}
+
+	if len(fields) > 3 {
+		data, err = hex.DecodeString(fields[3])
+		if err != nil || len(data) > 8 {
+			return 0, nil, fmt.Errorf("transport: socketcand: invalid frame data %q", fields[3])
+		}
+	}
+	return id, data, nil
+}
+
+// Write sends one frame (SocketCAN frame format). Write returns ErrNotConnected while Read
+// reconnects to socketcand.
+func (c *socketcandConn) Write(b []byte) (int, error) {
+	id, data, err := decodeFrame(b)
+	if err != nil {
+		return 0, err
+	}
+
+	e := fmt.Sprintf("< send %03X %d", id, len(data))
+	if id&CAN_EFF_FLAG != 0 {
+		e = fmt.Sprintf("< send %08X %d", id&CAN_EFF_MASK, len(data))
+	}
+	for _, d := range data {
+		e += fmt.Sprintf(" %02X", d)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return 0, ErrNotConnected
+	}
+	if _, err := io.WriteString(c.conn, e+" >"); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the connection to socketcand (and stops reconnecting).
+func (c *socketcandConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// openSocketcand connects to a remote SocketCAN interface (socketcand://host:29536/can0).
+func openSocketcand(u *url.URL) (can.ReadWriteCloser, error) {
+	addr := u.Host
+	if len(u.Port()) == 0 {
+		addr = net.JoinHostPort(u.Hostname(), SOCKETCAND_PORT)
+	}
+
+	channel := strings.Trim(u.Path, "/")
+	if len(channel) == 0 {
+		return nil, fmt.Errorf("transport: socketcand URL %q has no CANBus interface (example: socketcand://host:%s/can0)",
+			u, SOCKETCAND_PORT)
+	}
+
+	c, err := dialSocketcand(addr, channel)
+	if err != nil {
+		return nil, err
+	}
+	return can.NewReadWriteCloser(c), nil
+}
+
+func init() {
+	Register(SOCKETCAND_SCHEME, openSocketcand)
+}
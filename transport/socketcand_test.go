@@ -0,0 +1,268 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bufio"
+	"github.com/brutella/can"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// socketcand test frames (server -> client), invalid frames and errors are skipped
+var SocketcandTestFrames = []string{
+	"< frame 35G 1528761712.300000 00 >< error bus off >",
+	"< frame 355 1528761712.310000 4D006300 >< echo >",
+	"< frame 356 1528761712.315000 4D0 >",
+	"< frame 18FF50E5 1528761712.320000 0102 >",
+}
+
+// serveSocketcand accepts a single connection, answers the handshake (open, rawmode) and sends the
+// test frames. All elements received from the client are send to received.
+func serveSocketcand(t *testing.T, l net.Listener, received chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("< hi >"))
+
+	for n := 0; ; n++ {
+		// handshake completed (open, rawmode): send the test frames
+		if n == 2 {
+			conn.Write([]byte(strings.Join(SocketcandTestFrames, "")))
+		}
+
+		s, err := r.ReadString('>')
+		if err != nil {
+			return
+		}
+		received <- strings.TrimSpace(s)
+
+		if n < 2 {
+			conn.Write([]byte("< ok >"))
+		}
+	}
+}
+
+func TestSocketcand(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 10)
+	go serveSocketcand(t, l, received)
+
+	rwc, err := Open("socketcand://" + l.Addr().String() + "/can0")
+	if err != nil {
+		t.Fatalf("Open() == %v, expect nil", err)
+	}
+	defer rwc.Close()
+
+	for _, expect := range []string{"< open can0 >", "< rawmode >"} {
+		if e := <-received; e != expect {
+			t.Errorf("socketcand received %q, expect %q", e, expect)
+		}
+	}
+
+	for _, expect := range []can.Frame{
+		{ID: 0x355, Length: 4, Data: [8]byte{0x4d, 0x00, 0x63, 0x00}},
+		{ID: 0x18ff50e5 | CAN_EFF_FLAG, Length: 2, Data: [8]byte{0x01, 0x02}},
+	} {
+		frm := can.Frame{}
+		if err := rwc.ReadFrame(&frm); err != nil || frm != expect {
+			t.Errorf("rwc.ReadFrame() == %+v, %v, expect %+v, nil", frm, err, expect)
+		}
+	}
+
+	for _, tm := range []struct {
+		frm    can.Frame
+		expect string
+	}{
+		{can.Frame{ID: 0x305, Length: 8}, "< send 305 8 00 00 00 00 00 00 00 00 >"},
+		{can.Frame{ID: 0x18ff50e5 | CAN_EFF_FLAG, Length: 1, Data: [8]byte{0xab}}, "< send 18FF50E5 1 AB >"},
+	} {
+		if err := rwc.WriteFrame(tm.frm); err != nil {
+			t.Errorf("rwc.WriteFrame(%+v) == %v, expect nil", tm.frm, err)
+		}
+		if e := <-received; e != tm.expect {
+			t.Errorf("socketcand received %q, expect %q", e, tm.expect)
+		}
+	}
+}
+
+func TestOpenSocketcandErrors(t *testing.T) {
+	// no CANBus interface
+	if _, err := Open("socketcand://127.0.0.1:29536"); err == nil {
+		t.Errorf("Open() without CANBus interface == nil, expect error")
+	}
+
+	// CANBus interface can not be opened
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("< hi >"))
+		bufio.NewReader(conn).ReadString('>')
+		conn.Write([]byte("< error could not open bus >"))
+	}()
+
+	if _, err := Open("socketcand://" + l.Addr().String() + "/can9"); err == nil {
+		t.Errorf("Open() with invalid CANBus interface == nil, expect error")
+	}
+}
+
+// TestSocketcandReconnect tests that the connection is reestablished if socketcand closes the connection.
+func TestSocketcandReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// every connection: handshake, one frame, close (the last connection remains open)
+	go func() {
+		for i, frame := range []string{"< frame 355 1528761712.310000 4D006300 >", "< frame 356 1528761712.320000 0102 >"} {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			conn.Write([]byte("< hi >"))
+			for n := 0; n < 2; n++ {
+				r.ReadString('>')
+				conn.Write([]byte("< ok >"))
+			}
+			conn.Write([]byte(frame))
+			if i == 0 {
+				conn.Close()
+				continue
+			}
+			defer conn.Close()
+			r.ReadString('>')
+		}
+	}()
+
+	c, err := dialSocketcand(l.Addr().String(), "can0")
+	if err != nil {
+		t.Fatalf("dialSocketcand() == %v, expect nil", err)
+	}
+	c.reconnect = 10 * time.Millisecond
+	rwc := can.NewReadWriteCloser(c)
+
+	for _, id := range []uint32{0x355, 0x356} {
+		frm := can.Frame{}
+		if err := rwc.ReadFrame(&frm); err != nil || frm.ID != id {
+			t.Errorf("rwc.ReadFrame() == %+v, %v, expect id %#03x", frm, err, id)
+		}
+	}
+
+	// no reconnect after Close
+	done := make(chan error)
+	go func() {
+		frm := can.Frame{}
+		done <- rwc.ReadFrame(&frm)
+	}()
+	rwc.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("rwc.ReadFrame() after Close == nil, expect error")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("rwc.ReadFrame() after Close did not return")
+	}
+}
+
+// TestSocketcandWriteWhileReconnecting tests that Write returns ErrNotConnected while Read reconnects
+// and that Close stops waiting for the next attempt to reconnect.
+func TestSocketcandWriteWhileReconnecting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// handshake, then close the connection
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("< hi >"))
+		for n := 0; n < 2; n++ {
+			r.ReadString('>')
+			conn.Write([]byte("< ok >"))
+		}
+		conn.Close()
+	}()
+
+	c, err := dialSocketcand(l.Addr().String(), "can0")
+	if err != nil {
+		t.Fatalf("dialSocketcand() == %v, expect nil", err)
+	}
+	c.reconnect = time.Hour
+	rwc := can.NewReadWriteCloser(c)
+
+	done := make(chan error)
+	go func() {
+		frm := can.Frame{}
+		done <- rwc.ReadFrame(&frm)
+	}()
+
+	// wait until Read has detected the lost connection
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		lost := c.conn == nil
+		c.mu.Unlock()
+		if lost {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("socketcand connection lost not detected")
+		}
+	}
+
+	frm := can.Frame{ID: 0x305, Length: 8}
+	if err := rwc.WriteFrame(frm); err != ErrNotConnected {
+		t.Errorf("rwc.WriteFrame(%+v) == %v, expect %v", frm, err, ErrNotConnected)
+	}
+
+	rwc.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("rwc.ReadFrame() after Close == nil, expect error")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("rwc.ReadFrame() after Close did not return (reconnect interval %v)", c.reconnect)
+	}
+}
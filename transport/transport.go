@@ -0,0 +1,154 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transport connects a can.Bus to a CANBus. The CANBus is selected
+// by URL:
+//
+//	socketcan://can0                 local SocketCAN interface
+//	socketcand://host:29536/can0     remote SocketCAN interface (socketcand)
+//	slcan:///dev/ttyACM0             SLCAN (Lawicel) serial adapter
+//	cannelloni://host:20000          CAN over UDP tunnel (cannelloni)
+//	cannelloni://127.0.0.1:20001?local=127.0.0.1:20000
+//	                                 CAN over loopback UDP (peer listens on 20001)
+//	can0                             local SocketCAN interface (no scheme)
+//
+// Example:
+//
+//	rwc, err := transport.Open("socketcand://raspberrypi:29536/can0")
+//	bus := can.NewBus(rwc)
+//
+// Transports exchange frames in the SocketCAN frame format (struct can_frame,
+// one frame per Read/Write) and are wrapped with can.NewReadWriteCloser.
+// Additional transports are added with Register.
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/brutella/can"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Github triggers update of godoc documentation.
+type Github int
+
+const (
+	// URL scheme of a local SocketCAN interface
+	SOCKETCAN_SCHEME string = "socketcan"
+	// size of a SocketCAN frame (struct can_frame)
+	FRAME_SIZE int = 16
+	// extended (29 bit) message id flag and mask (SocketCAN)
+	CAN_EFF_FLAG uint32 = 0x80000000
+	CAN_EFF_MASK uint32 = 0x1fffffff
+)
+
+// Opener connects to the CANBus selected by u.
+type Opener func(u *url.URL) (can.ReadWriteCloser, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = make(map[string]Opener)
+)
+
+// Register makes a transport available for the URL scheme. Register panics if it is called
+// twice for the same scheme.
+func Register(scheme string, o Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+
+	if o == nil {
+		panic("transport: Register opener is nil")
+	}
+	if _, dup := openers[scheme]; dup {
+		panic("transport: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = o
+}
+
+// Schemes returns a sorted list of the URL schemes of all registered transports.
+func Schemes() []string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+
+	schemes := make([]string, 0, len(openers))
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open connects to the CANBus selected by rawurl. A name without scheme (for example: can0) is a
+// local SocketCAN interface.
+func Open(rawurl string) (can.ReadWriteCloser, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("transport: invalid CANBus URL %q (%v)", rawurl, err)
+	}
+	if len(u.Scheme) == 0 {
+		u = &url.URL{Scheme: SOCKETCAN_SCHEME, Host: rawurl}
+	}
+
+	openersMu.RLock()
+	o, ok := openers[u.Scheme]
+	openersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("transport: unknown CANBus URL scheme %q (registered: %v)", u.Scheme, Schemes())
+	}
+
+	rwc, err := o(u)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("transport: connected to %s\n", u)
+	return rwc, nil
+}
+
+// openSocketcan opens a local SocketCAN interface (socketcan://can0).
+func openSocketcan(u *url.URL) (can.ReadWriteCloser, error) {
+	iface, err := net.InterfaceByName(u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("transport: could not find network interface %s (%v)", u.Host, err)
+	}
+	return can.NewReadWriteCloserForInterface(iface)
+}
+
+// encodeFrame returns the SocketCAN frame (struct can_frame) for id and data.
+func encodeFrame(id uint32, data []byte) []byte {
+	b := make([]byte, FRAME_SIZE)
+	binary.LittleEndian.PutUint32(b[0:4], id)
+	b[4] = uint8(copy(b[8:], data))
+	return b
+}
+
+// decodeFrame returns id and data of a SocketCAN frame (struct can_frame).
+func decodeFrame(b []byte) (id uint32, data []byte, err error) {
+	if len(b) < FRAME_SIZE {
+		return 0, nil, fmt.Errorf("transport: frame has %d bytes, expect %d bytes", len(b), FRAME_SIZE)
+	}
+	length := int(b[4])
+	if length > 8 {
+		return 0, nil, fmt.Errorf("transport: frame has length %d, expect at most 8", length)
+	}
+	return binary.LittleEndian.Uint32(b[0:4]), b[8 : 8+length], nil
+}
+
+func init() {
+	Register(SOCKETCAN_SCHEME, openSocketcan)
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Jens Kaemmerer. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"testing"
+)
+
+func init() {
+	log.SetLevel(log.WarnLevel)
+}
+
+func TestSchemes(t *testing.T) {
+	for _, scheme := range []string{SOCKETCAN_SCHEME, SOCKETCAND_SCHEME} {
+		found := false
+		for _, s := range Schemes() {
+			found = found || s == scheme
+		}
+		if !found {
+			t.Errorf("Schemes() == %v, expect %q", Schemes(), scheme)
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	for _, rawurl := range []string{"unknown://can0", "socketcan://nocan0", "nocan0", "socketcand://127.0.0.1:1"} {
+		if _, err := Open(rawurl); err == nil {
+			t.Errorf("Open(%q) == nil, expect error", rawurl)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	data := []byte{0x4d, 0x00, 0x63, 0x00}
+
+	b := encodeFrame(0x355, data)
+	if len(b) != FRAME_SIZE || b[4] != 4 {
+		t.Errorf("encodeFrame() == % X, expect %d bytes, length 4", b, FRAME_SIZE)
+	}
+
+	id, d, err := decodeFrame(b)
+	if err != nil || id != 0x355 || !cmp.Equal(d, data) {
+		t.Errorf("decodeFrame() == %#x, % X, %v, expect 0x355, % X, nil", id, d, err, data)
+	}
+
+	b[4] = 9
+	if _, _, err := decodeFrame(b); err == nil {
+		t.Errorf("decodeFrame() with length 9 == nil, expect error")
+	}
+	if _, _, err := decodeFrame(b[:8]); err == nil {
+		t.Errorf("decodeFrame() with 8 bytes == nil, expect error")
+	}
+}